@@ -16,6 +16,10 @@
 
 package stompws
 
+import (
+	"context"
+)
+
 /*
 	Abort a STOMP transaction.
 
@@ -30,7 +34,15 @@ package stompws
 		}
 */
 func (c *Connection) Abort(h Headers) error {
-	c.log(ABORT, "start", h)
+	return c.AbortContext(context.Background(), h)
+}
+
+/*
+	AbortContext is like Abort, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) AbortContext(ctx context.Context, h Headers) error {
+	c.log(LogLifecycle, LevelDebug, "ABORT start", "headers", h)
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -42,7 +54,7 @@ func (c *Connection) Abort(h Headers) error {
 	if h.Value(HK_TRANSACTION) == "" {
 		return ETIDABTEMT
 	}
-	e := c.transmitCommon(ABORT, h) // transmitCommon Clones() the headers
-	c.log(ABORT, "end", h)
+	e := c.transmitCommonContext(ctx, ABORT, h) // transmitCommon Clones() the headers
+	c.log(LogLifecycle, LevelDebug, "ABORT end", "headers", h)
 	return e
 }
@@ -16,6 +16,10 @@
 
 package stompws
 
+import (
+	"context"
+)
+
 /*
 	Ack a STOMP MESSAGE.
 
@@ -40,7 +44,22 @@ package stompws
 
 */
 func (c *Connection) Ack(h Headers) error {
-	c.log(ACK, "start", h, c.Protocol())
+	return c.AckContext(context.Background(), h)
+}
+
+/*
+	AckContext is like Ack, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) AckContext(ctx context.Context, h Headers) error {
+	return c.ackContext(ctx, h, h.Value(HK_SUBSCRIPTION))
+}
+
+/*
+	Ack, counted for subscription sid when sid is known.
+*/
+func (c *Connection) ackContext(ctx context.Context, h Headers, sid string) error {
+	c.log(LogLifecycle, LevelDebug, "ACK start", "headers", h, "protocol", c.Protocol())
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -66,7 +85,10 @@ func (c *Connection) Ack(h Headers) error {
 		}
 	}
 
-	e = c.transmitCommon(ACK, h) // transmitCommon Clones() the headers
-	c.log(ACK, "end", h, c.Protocol())
+	e = c.transmitCommonContext(ctx, ACK, h) // transmitCommon Clones() the headers
+	if e == nil {
+		c.subAcked(sid, ACK)
+	}
+	c.log(LogLifecycle, LevelDebug, "ACK end", "headers", h, "protocol", c.Protocol())
 	return e
 }
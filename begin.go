@@ -16,6 +16,10 @@
 
 package stompws
 
+import (
+	"context"
+)
+
 /*
 	Begin a STOMP transaction.
 
@@ -30,7 +34,15 @@ package stompws
 		}
 */
 func (c *Connection) Begin(h Headers) error {
-	c.log(BEGIN, "start", h)
+	return c.BeginContext(context.Background(), h)
+}
+
+/*
+	BeginContext is like Begin, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) BeginContext(ctx context.Context, h Headers) error {
+	c.log(LogLifecycle, LevelDebug, "BEGIN start", "headers", h)
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -42,7 +54,7 @@ func (c *Connection) Begin(h Headers) error {
 	if h.Value(HK_TRANSACTION) == "" {
 		return ETIDBEGEMT
 	}
-	e := c.transmitCommon(BEGIN, h) // transmitCommon Clones() the headers
-	c.log(BEGIN, "end", h)
+	e := c.transmitCommonContext(ctx, BEGIN, h) // transmitCommon Clones() the headers
+	c.log(LogLifecycle, LevelDebug, "BEGIN end", "headers", h)
 	return e
 }
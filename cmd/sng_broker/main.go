@@ -0,0 +1,62 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+/*
+	Run the in memory test broker for local development.
+
+	Example:
+		go run ./cmd/sng_broker -tcp localhost:61613 -ws localhost:61614
+*/
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	//
+	"github.com/drawdy/stomp-ws-go/stomptest"
+)
+
+func main() {
+	tcp := flag.String("tcp", "localhost:61613", "TCP listen address, empty for none")
+	ws := flag.String("ws", "", "WebSocket listen address, empty for none")
+	login := flag.String("login", "", "required login, empty for any")
+	passcode := flag.String("passcode", "", "required passcode")
+	flag.Parse()
+	//
+	b := stomptest.NewBroker(&stomptest.Options{Login: *login,
+		Passcode: *passcode})
+	if *tcp != "" {
+		a, e := b.Listen(*tcp)
+		if e != nil {
+			log.Fatalln("TCP listen error:", e)
+		}
+		log.Printf("STOMP over TCP: %s\n", a)
+	}
+	if *ws != "" {
+		u, e := b.ListenWS(*ws)
+		if e != nil {
+			log.Fatalln("WebSocket listen error:", e)
+		}
+		log.Printf("STOMP over WebSocket: %s\n", u)
+	}
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Interrupt)
+	<-sc
+	_ = b.Close()
+}
@@ -0,0 +1,195 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"mime"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	Codec marshals values to and from message bodies.
+*/
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+/*
+	Content types of the built in codecs.
+*/
+const (
+	CT_JSON     = "application/json"
+	CT_GOB      = "application/x-gob"
+	CT_PROTOBUF = "application/x-protobuf"
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{
+	CT_JSON:     JSONCodec{},
+	CT_GOB:      GobCodec{},
+	CT_PROTOBUF: ProtobufCodec{},
+}}
+
+/*
+	RegisterCodec registers cd for the MIME type ct, replacing any codec
+	already registered for it.  A nil cd removes the registration.
+
+	Example:
+		stompngo.RegisterCodec("application/x-msgpack", myMsgpackCodec{})
+*/
+func RegisterCodec(ct string, cd Codec) {
+	ct = mediaType(ct)
+	codecs.Lock()
+	defer codecs.Unlock()
+	if cd == nil {
+		delete(codecs.m, ct)
+		return
+	}
+	codecs.m[ct] = cd
+}
+
+/*
+	LookupCodec returns the codec for a content-type header value.  Media
+	type parameters, e.g. "; charset=UTF-8", are ignored.
+*/
+func LookupCodec(ct string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	cd, ok := codecs.m[mediaType(ct)]
+	return cd, ok
+}
+
+func mediaType(ct string) string {
+	if mt, _, e := mime.ParseMediaType(ct); e == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(ct))
+}
+
+/*
+	SendValue marshals v, and sends it with SendBytes.  The codec is chosen
+	by the content-type header in h, which defaults to CT_JSON and is then
+	added to the frame.
+
+	Example:
+		h := stompngo.Headers{stompngo.HK_DESTINATION, "/queue/orders",
+			stompngo.HK_CONTENT_TYPE, stompngo.CT_PROTOBUF}
+		e := c.SendValue(h, order) // order is a proto.Message
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+func (c *Connection) SendValue(h Headers, v interface{}) error {
+	ct, ok := h.Contains(HK_CONTENT_TYPE)
+	if !ok {
+		ct = CT_JSON
+		h = h.Add(HK_CONTENT_TYPE, ct)
+	}
+	cd, ok := LookupCodec(ct)
+	if !ok {
+		return Error(ENOCODEC.Error() + ": " + ct)
+	}
+	b, e := cd.Marshal(v)
+	if e != nil {
+		return e
+	}
+	return c.SendBytes(h, b)
+}
+
+/*
+	Decode unmarshals a received message body into v, using the codec for
+	the message content-type header.
+
+	Example:
+		var o Order
+		if e := stompngo.Decode(md, &o); e != nil {
+			// Do something sane ...
+		}
+*/
+func Decode(md MessageData, v interface{}) error {
+	if md.Error != nil {
+		return md.Error
+	}
+	ct := md.Message.Headers.Value(HK_CONTENT_TYPE)
+	cd, ok := LookupCodec(ct)
+	if !ok {
+		return Error(ENOCODEC.Error() + ": " + ct)
+	}
+	return cd.Unmarshal(md.Message.Body, v)
+}
+
+/*
+	JSONCodec uses encoding/json.
+*/
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+/*
+	GobCodec uses encoding/gob.  Each body is a complete gob stream, so
+	type information is sent with every message.
+*/
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if e := gob.NewEncoder(&b).Encode(v); e != nil {
+		return nil, e
+	}
+	return b.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+/*
+	ProtobufCodec uses the protobuf binary format.  Values must implement
+	proto.Message.
+*/
+type ProtobufCodec struct{}
+
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ECODECTYP
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtobufCodec) Unmarshal(b []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ECODECTYP
+	}
+	return proto.Unmarshal(b, m)
+}
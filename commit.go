@@ -16,6 +16,10 @@
 
 package stompws
 
+import (
+	"context"
+)
+
 /*
 	Commit a STOMP transaction.
 
@@ -31,7 +35,15 @@ package stompws
 
 */
 func (c *Connection) Commit(h Headers) error {
-	c.log(COMMIT, "start", h)
+	return c.CommitContext(context.Background(), h)
+}
+
+/*
+	CommitContext is like Commit, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) CommitContext(ctx context.Context, h Headers) error {
+	c.log(LogLifecycle, LevelDebug, "COMMIT start", "headers", h)
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -43,7 +55,7 @@ func (c *Connection) Commit(h Headers) error {
 	if h.Value(HK_TRANSACTION) == "" {
 		return ETIDCOMEMT
 	}
-	e := c.transmitCommon(COMMIT, h) // transmitCommon Clones() the headers
-	c.log(COMMIT, "end", h)
+	e := c.transmitCommonContext(ctx, COMMIT, h) // transmitCommon Clones() the headers
+	c.log(LogLifecycle, LevelDebug, "COMMIT end", "headers", h)
 	return e
 }
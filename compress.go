@@ -0,0 +1,266 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+	Body compression.
+
+	When enabled, SEND bodies of at least the threshold size are compressed,
+	and a content-encoding header naming the encoding is added.  A received
+	MESSAGE with a known content-encoding is decompressed before it is
+	delivered, and its content-encoding header removed.
+
+	The SEND extension header StompPlusCompress overrides the connection
+	encoding for one message, and is not sent to the broker.  The value
+	CE_NONE disables compression.  A SEND that already has a content-encoding
+	header is never compressed.
+
+	Example:
+		e := c.SetCompression(stompngo.CE_GZIP, 4096)
+		if e != nil {
+			// Do something sane ...
+		}
+		h := stompngo.Headers{stompngo.HK_DESTINATION, "/queue/big",
+			stompngo.StompPlusCompress, stompngo.CE_ZSTD}
+		e = c.SendBytes(h, document)
+*/
+const (
+	CE_NONE   = "none"
+	CE_GZIP   = "gzip"
+	CE_ZSTD   = "zstd"
+	CE_SNAPPY = "snappy"
+)
+
+/*
+	A body compression algorithm.  decompress fails with ELIMBODY, before
+	allocating the output, when it would be larger than max bytes.  A max of
+	0 means no limit.
+*/
+type compressor interface {
+	compress(b []byte) ([]byte, error)
+	decompress(b []byte, max int) ([]byte, error)
+}
+
+var compressors = map[string]compressor{CE_GZIP: gzipCompressor{},
+	CE_ZSTD: &zstdCompressor{}, CE_SNAPPY: snappyCompressor{}}
+
+/*
+	SetCompression sets the encoding used to compress SEND bodies of at least
+	min bytes.  An empty enc, or CE_NONE, disables compression.  Received
+	bodies are always decompressed.
+*/
+func (c *Connection) SetCompression(enc string, min int) error {
+	if enc == CE_NONE {
+		enc = ""
+	}
+	if _, ok := compressors[enc]; !ok && enc != "" {
+		return Error(EBADCENC.Error() + ": " + enc)
+	}
+	c.cencLock.Lock()
+	c.cenc, c.cmin = enc, min
+	c.cencLock.Unlock()
+	return nil
+}
+
+/*
+	Compress a SEND body if required.  The returned count is the number of
+	bytes saved.
+*/
+func (c *Connection) compress(h Headers, b []byte) (Headers, []byte, int64, error) {
+	c.cencLock.Lock()
+	enc, min := c.cenc, c.cmin
+	c.cencLock.Unlock()
+	if v, ok := h.Contains(StompPlusCompress); ok {
+		enc = v
+		h = h.Delete(StompPlusCompress) // Not for the broker
+	}
+	if enc == "" || enc == CE_NONE || len(b) < min || len(b) == 0 {
+		return h, b, 0, nil
+	}
+	if _, ok := h.Contains(HK_CONTENT_ENCODING); ok {
+		return h, b, 0, nil
+	}
+	cp, ok := compressors[enc]
+	if !ok {
+		return h, b, 0, Error(EBADCENC.Error() + ": " + enc)
+	}
+	cb, e := cp.compress(b)
+	if e != nil {
+		return h, b, 0, e
+	}
+	h = h.Add(HK_CONTENT_ENCODING, enc)
+	if _, ok := h.Contains(HK_CONTENT_LENGTH); ok {
+		h = h.Delete(HK_CONTENT_LENGTH).Add(HK_CONTENT_LENGTH, strconv.Itoa(len(cb)))
+	}
+	return h, cb, int64(len(b) - len(cb)), nil
+}
+
+/*
+	Decompress a received MESSAGE body if required.  Unknown encodings are
+	left to the client.  The decompressed body is held to the MaxBodySize
+	frame limit.
+*/
+func (c *Connection) decompress(m *Message) error {
+	enc, ok := m.Headers.Contains(HK_CONTENT_ENCODING)
+	if !ok {
+		return nil
+	}
+	cp, ok := compressors[enc]
+	if !ok {
+		return nil
+	}
+	b, e := cp.decompress(m.Body, c.frameLimits().MaxBodySize)
+	if e != nil {
+		return e
+	}
+	atomic.AddInt64(&c.mets.xbr, int64(len(b)-len(m.Body)))
+	m.Headers = m.Headers.Delete(HK_CONTENT_ENCODING)
+	if _, ok := m.Headers.Contains(HK_CONTENT_LENGTH); ok {
+		m.Headers = m.Headers.Delete(HK_CONTENT_LENGTH).Add(HK_CONTENT_LENGTH,
+			strconv.Itoa(len(b)))
+	}
+	m.Body = b
+	return nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) compress(b []byte) ([]byte, error) {
+	var o bytes.Buffer
+	w := gzip.NewWriter(&o)
+	if _, e := w.Write(b); e != nil {
+		return nil, e
+	}
+	if e := w.Close(); e != nil {
+		return nil, e
+	}
+	return o.Bytes(), nil
+}
+
+func (gzipCompressor) decompress(b []byte, max int) ([]byte, error) {
+	r, e := gzip.NewReader(bytes.NewReader(b))
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+	if max <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	d, e := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if e == nil && len(d) > max {
+		return nil, ELIMBODY
+	}
+	return d, e
+}
+
+/*
+	The zstd encoder and decoders are safe for concurrent EncodeAll and
+	DecodeAll calls, and are created on first use.  The decoded size limit
+	is a decoder option, so there is one decoder per limit.  The decoder
+	also rejects windows over that size, so it is allowed one standard window
+	more than the body limit, and the body is checked after decoding.
+*/
+const zstdWindow = 8 << 20 // Largest window of the standard levels
+
+type zstdCompressor struct {
+	once sync.Once
+	enc  *zstd.Encoder
+	err  error
+	lock sync.Mutex
+	decs map[int]*zstd.Decoder
+}
+
+func (z *zstdCompressor) init() error {
+	z.once.Do(func() {
+		z.enc, z.err = zstd.NewWriter(nil)
+		z.decs = make(map[int]*zstd.Decoder)
+	})
+	return z.err
+}
+
+func (z *zstdCompressor) decoder(max int) (*zstd.Decoder, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+	if d, ok := z.decs[max]; ok {
+		return d, nil
+	}
+	var o []zstd.DOption
+	if max > 0 {
+		o = append(o, zstd.WithDecoderMaxMemory(uint64(max)+zstdWindow))
+	}
+	d, e := zstd.NewReader(nil, o...)
+	if e != nil {
+		return nil, e
+	}
+	z.decs[max] = d
+	return d, nil
+}
+
+func (z *zstdCompressor) compress(b []byte) ([]byte, error) {
+	if e := z.init(); e != nil {
+		return nil, e
+	}
+	return z.enc.EncodeAll(b, nil), nil
+}
+
+func (z *zstdCompressor) decompress(b []byte, max int) ([]byte, error) {
+	if e := z.init(); e != nil {
+		return nil, e
+	}
+	d, e := z.decoder(max)
+	if e != nil {
+		return nil, e
+	}
+	o, e := d.DecodeAll(b, nil)
+	switch {
+	case e == zstd.ErrDecoderSizeExceeded || e == zstd.ErrWindowSizeExceeded:
+		return nil, ELIMBODY
+	case e == nil && max > 0 && len(o) > max: // Checked per block
+		return nil, ELIMBODY
+	}
+	return o, e
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) compress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func (snappyCompressor) decompress(b []byte, max int) ([]byte, error) {
+	n, e := snappy.DecodedLen(b)
+	if e != nil {
+		return nil, e
+	}
+	if max > 0 && n > max {
+		return nil, ELIMBODY
+	}
+	return snappy.Decode(nil, b)
+}
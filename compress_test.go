@@ -0,0 +1,182 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Test each encoding, and the threshold.
+*/
+func TestCompressEncodings(t *testing.T) {
+	c := newConnection()
+	c.mets = &metrics{st: time.Now()}
+	body := bytes.Repeat([]byte("compressible "), 100)
+	if e := c.SetCompression("brotli", 0); e == nil ||
+		!strings.HasPrefix(e.Error(), EBADCENC.Error()) {
+		t.Fatalf("TestCompressEncodings expected [%v], got [%v]\n", EBADCENC, e)
+	}
+	for _, enc := range []string{CE_GZIP, CE_ZSTD, CE_SNAPPY} {
+		if e := c.SetCompression(enc, 100); e != nil {
+			t.Fatalf("TestCompressEncodings %s expected nil, got %v\n", enc, e)
+		}
+		h, b, x, e := c.compress(Headers{HK_DESTINATION, "/queue/q"}, body)
+		if e != nil || h.Value(HK_CONTENT_ENCODING) != enc ||
+			int(x) != len(body)-len(b) || len(b) >= len(body) {
+			t.Fatalf("TestCompressEncodings %s bad compress %v %d %d %v\n",
+				enc, h, len(b), x, e)
+		}
+		m := Message{MESSAGE, h.Add(HK_CONTENT_LENGTH, "1"), b}
+		if e = c.decompress(&m); e != nil || !bytes.Equal(m.Body, body) {
+			t.Fatalf("TestCompressEncodings %s decompress expected nil, got %v\n", enc, e)
+		}
+		if _, ok := m.Headers.Contains(HK_CONTENT_ENCODING); ok ||
+			m.Headers.Value(HK_CONTENT_LENGTH) != "1300" {
+			t.Fatalf("TestCompressEncodings %s bad headers %v\n", enc, m.Headers)
+		}
+		// Below the threshold
+		if h, _, _, _ = c.compress(Headers{}, body[:99]); len(h) != 0 {
+			t.Fatalf("TestCompressEncodings %s expected no compression, got %v\n", enc, h)
+		}
+	}
+	// Per send override
+	h, _, _, _ := c.compress(Headers{StompPlusCompress, CE_NONE}, body)
+	if len(h) != 0 {
+		t.Fatalf("TestCompressEncodings expected no headers, got %v\n", h)
+	}
+	h, _, _, _ = c.compress(Headers{StompPlusCompress, CE_SNAPPY}, body)
+	if h.Value(HK_CONTENT_ENCODING) != CE_SNAPPY {
+		t.Fatalf("TestCompressEncodings expected snappy, got %v\n", h)
+	}
+	if _, ok := h.Contains(StompPlusCompress); ok {
+		t.Fatalf("TestCompressEncodings expected no %s header, got %v\n",
+			StompPlusCompress, h)
+	}
+	// Corrupt data
+	m := Message{MESSAGE, Headers{HK_CONTENT_ENCODING, CE_GZIP}, body}
+	if e := c.decompress(&m); e == nil {
+		t.Fatalf("TestCompressEncodings expected error, got nil\n")
+	}
+}
+
+/*
+	Test compressed messages through a broker, and the byte counts.
+*/
+func TestCompressSend(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	if e := c.SetCompression(CE_GZIP, 1024); e != nil {
+		t.Fatalf("TestCompressSend expected nil, got %v\n", e)
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/gz", HK_ID, "gz"})
+	if e != nil {
+		t.Fatalf("TestCompressSend Subscribe expected nil, got %v\n", e)
+	}
+	big := strings.Repeat("a large json document ", 200)
+	for _, s := range []string{"small", big} {
+		if e = c.Send(Headers{HK_DESTINATION, "/queue/gz"}, s); e != nil {
+			t.Fatalf("TestCompressSend Send expected nil, got %v\n", e)
+		}
+		select {
+		case md := <-sc:
+			if md.Error != nil || md.Message.BodyString() != s {
+				t.Fatalf("TestCompressSend bad MESSAGE %v\n", md)
+			}
+			if _, ok := md.Message.Headers.Contains(HK_CONTENT_ENCODING); ok {
+				t.Fatalf("TestCompressSend bad headers %v\n", md.Message.Headers)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestCompressSend no MESSAGE\n")
+		}
+	}
+	if c.LogicalBytesWritten()-c.BytesWritten() < int64(len(big))/2 {
+		t.Fatalf("TestCompressSend bad write counts %d %d\n",
+			c.BytesWritten(), c.LogicalBytesWritten())
+	}
+	if c.LogicalBytesRead()-c.BytesRead() < int64(len(big))/2 {
+		t.Fatalf("TestCompressSend bad read counts %d %d\n",
+			c.BytesRead(), c.LogicalBytesRead())
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test decompressed bodies are held to the frame body limit.
+*/
+func TestCompressLimit(t *testing.T) {
+	c := newConnection()
+	c.mets = &metrics{st: time.Now()}
+	body := make([]byte, 64*1024)
+	for _, enc := range []string{CE_GZIP, CE_ZSTD, CE_SNAPPY} {
+		b, e := compressors[enc].compress(body)
+		if e != nil {
+			t.Fatalf("TestCompressLimit %s compress expected nil, got %v\n", enc, e)
+		}
+		for _, d := range []struct {
+			max int
+			err error
+		}{
+			{0, nil},
+			{len(body), nil},
+			{len(body) - 1, ELIMBODY},
+			{1024, ELIMBODY},
+		} {
+			l := DefaultFrameLimits
+			l.MaxBodySize = d.max
+			c.SetFrameLimits(l)
+			m := Message{MESSAGE, Headers{HK_CONTENT_ENCODING, enc}, b}
+			if e = c.decompress(&m); e != d.err {
+				t.Fatalf("TestCompressLimit %s %d expected [%v], got [%v]\n",
+					enc, d.max, d.err, e)
+			}
+			if e == nil && len(m.Body) != len(body) {
+				t.Fatalf("TestCompressLimit %s %d bad length %d\n", enc, d.max, len(m.Body))
+			}
+			if e != nil && !bytes.Equal(m.Body, b) {
+				t.Fatalf("TestCompressLimit %s %d body changed\n", enc, d.max)
+			}
+		}
+	}
+}
+
+/*
+	Test SetCompression while another goroutine sends.
+*/
+func TestCompressConcurrent(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			_ = c.SetCompression([]string{CE_NONE, CE_GZIP, CE_SNAPPY}[i%3], i)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if e := c.Send(Headers{HK_DESTINATION, "/queue/gz.set"}, "body"); e != nil {
+			t.Fatalf("TestCompressConcurrent SEND expected nil, got %v\n", e)
+		}
+	}
+	<-done
+	e := c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -24,7 +24,6 @@ import (
 
 	// "fmt"
 	"net"
-	"time"
 
 	"github.com/drawdy/stomp-ws-go/senv"
 )
@@ -74,87 +73,68 @@ func NewConnectorOverWS(n *websocket.Conn, h Headers) (STOMPConnector, error) {
 		// Use c
 */
 func Connect(n net.Conn, h Headers) (*Connection, error) {
-	if h == nil {
-		return nil, EHDRNIL
-	}
-	if e := h.Validate(); e != nil {
+	ch, e := checkConnectHeaders(h)
+	if e != nil {
 		return nil, e
 	}
-	if _, ok := h.Contains(HK_RECEIPT); ok {
-		return nil, ENORECPT
-	}
-	ch := h.Clone()
-	//fmt.Printf("CONDB01\n")
-	c := &Connection{netconn: n,
-		input:             make(chan MessageData, 1),
-		output:            make(chan wiredata),
-		connected:         false,
-		session:           "",
-		protocol:          SPL_10,
-		subs:              make(map[string]*subscription),
-		DisconnectReceipt: MessageData{},
-		ssdc:              make(chan struct{}),
-		wtrsdc:            make(chan struct{}),
-		scc:               1,
-		dld:               &deadlineData{}}
-
-	// Basic metric data
-	c.mets = &metrics{st: time.Now()}
-
-	// Assumed for now
-	c.MessageData = c.input
+	c := newConnection()
+	c.setTransport(n, nil, "")
 
 	// Check that the client wants a version we support
+	c.stateEvent(StateConnecting, nil)
 	if e := c.checkClientVersions(h); e != nil {
+		c.stateEvent(StateClosed, e)
 		return c, e
 	}
-	// Optional logging from connection start
-	ln := senv.WantLogger()
-	if ln != "" {
-		c.SetLogger(log.New(os.Stdout, ln+" ",
-			log.Ldate|log.Lmicroseconds|log.Lshortfile))
-	}
 
 	// OK, put a CONNECT on the wire
-	c.wtr = bufio.NewWriter(n) // Create the writer
-	go c.writer()              // Start it
-	var f Frame
-	if senv.UseStomp() {
-		if ch.Value("accept-version") == SPL_11 || ch.Value("accept-version") == SPL_12 {
-			f = Frame{STOMP, ch, NULLBUFF} // Create actual STOMP frame
-		} else {
-			f = Frame{CONNECT, ch, NULLBUFF} // Create actual STOMP frame
-		}
-		// fmt.Printf("Frame: %q\n", f)
-	} else {
-		f = Frame{CONNECT, ch, NULLBUFF} // Create actual CONNECT frame
-		// fmt.Printf("Frame: %q\n", f)
-	}
-	r := make(chan error)                               // Make the error channel for a write
-	if e := c.writeWireData(wiredata{f, r}); e != nil { // Send the CONNECT frame
+	e = c.connectWire(ch)
+	if e != nil {
+		c.stateEvent(StateClosed, e)
 		return c, e
 	}
-	e := <-r // Retrieve any error
+	// We are connected
+	c.startSession()
+	c.stateEvent(StateConnected, nil)
+	go c.reader()
 	//
+	return c, e
+}
+
+func ConnectOverWS(n *websocket.Conn, h Headers) (STOMPConnector, error) {
+	ch, e := checkConnectHeaders(h)
 	if e != nil {
-		c.sysAbort() // Shutdown,  we are done with errors
+		return nil, e
+	}
+	c := newConnection()
+	c.setTransport(nil, n, "")
+
+	// Validate that the client wants a version we support
+	c.stateEvent(StateConnecting, nil)
+	if e := c.checkClientVersions(h); e != nil {
+		c.stateEvent(StateClosed, e)
 		return c, e
 	}
-	//fmt.Printf("CONDB03\n")
-	//
-	e = c.connectHandler(ch)
+
+	// OK, put a CONNECT on the wire
+	e = c.connectWire(ch)
 	if e != nil {
-		c.sysAbort() // Shutdown ,  we are done with errors
+		c.stateEvent(StateClosed, e)
 		return c, e
 	}
-	//fmt.Printf("CONDB04\n")
 	// We are connected
+	c.startSession()
+	c.stateEvent(StateConnected, nil)
 	go c.reader()
 	//
 	return c, e
 }
 
-func ConnectOverWS(n *websocket.Conn, h Headers) (STOMPConnector, error) {
+/*
+	Common CONNECT header checks.  Returns a copy of the headers for use on the
+	wire.
+*/
+func checkConnectHeaders(h Headers) (Headers, error) {
 	if h == nil {
 		return nil, EHDRNIL
 	}
@@ -164,10 +144,14 @@ func ConnectOverWS(n *websocket.Conn, h Headers) (STOMPConnector, error) {
 	if _, ok := h.Contains(HK_RECEIPT); ok {
 		return nil, ENORECPT
 	}
-	ch := h.Clone()
+	return h.Clone(), nil
+}
 
-	//fmt.Printf("CONDB01\n")
-	c := &Connection{wsConn: n,
+/*
+	Create a new, not yet connected, Connection.
+*/
+func newConnection() *Connection {
+	c := &Connection{
 		input:             make(chan MessageData, 1),
 		output:            make(chan wiredata),
 		connected:         false,
@@ -177,60 +161,72 @@ func ConnectOverWS(n *websocket.Conn, h Headers) (STOMPConnector, error) {
 		DisconnectReceipt: MessageData{},
 		ssdc:              make(chan struct{}),
 		wtrsdc:            make(chan struct{}),
+		wtrdc:             make(chan struct{}),
 		scc:               1,
-		dld:               &deadlineData{}}
+		dld:               &deadlineData{},
+		lim:               DefaultFrameLimits,
+		rcpm:              newReceiptManager(),
+		txs:               make(map[string]*Tx),
+		logLevels:         [logCategories]Level{LevelDebug, LevelDebug, LevelDebug},
+		evc:               make(chan Event, EventChanCap)}
 
 	// Basic metric data
-	c.mets = &metrics{st: time.Now()}
+	c.mets = newMetrics()
 
 	// Assumed for now
 	c.MessageData = c.input
 
-	// Validate that the client wants a version we support
-	if e := c.checkClientVersions(h); e != nil {
-		return c, e
-	}
 	// Optional logging from connection start
 	ln := senv.WantLogger()
 	if ln != "" {
 		c.SetLogger(log.New(os.Stdout, ln+" ",
 			log.Ldate|log.Lmicroseconds|log.Lshortfile))
 	}
+	return c
+}
 
-	// OK, put a CONNECT on the wire
-	go c.writerOverWS()
-	var f Frame
+/*
+	Put a CONNECT frame on the wire, and handle the broker response.  The
+	writer is not started, see startSession.
+*/
+func (c *Connection) connectWire(ch Headers) error {
+	if c.wsConn == nil {
+		c.wtr = bufio.NewWriter(c.netconn) // Create the writer
+	}
+	if c.wsConn != nil {
+		if e := checkSubprotocol(c.wsConn.Subprotocol(), ch); e != nil {
+			c.connectAbort()
+			return e
+		}
+	}
+	f := Frame{CONNECT, ch, NULLBUFF} // Create actual CONNECT frame
 	if senv.UseStomp() {
 		if ch.Value("accept-version") == SPL_11 || ch.Value("accept-version") == SPL_12 {
 			f = Frame{STOMP, ch, NULLBUFF} // Create actual STOMP frame
-		} else {
-			f = Frame{CONNECT, ch, NULLBUFF} // Create actual STOMP frame
 		}
 		// fmt.Printf("Frame: %q\n", f)
-	} else {
-		f = Frame{CONNECT, ch, NULLBUFF} // Create actual CONNECT frame
-		// fmt.Printf("Frame: %q\n", f)
-	}
-	r := make(chan error)                               // Make the error channel for a write
-	if e := c.writeWireData(wiredata{f, r}); e != nil { // Send the CONNECT frame
-		return c, e
 	}
-	e := <-r // Retrieve any error
+	e := c.writeDirect(f) // Send the CONNECT frame
 	//
 	if e != nil {
-		c.sysAbort() // Shutdown,  we are done with errors
-		return c, e
+		c.connectAbort() // Shutdown,  we are done with errors
+		return e
 	}
 	//fmt.Printf("CONDB03\n")
 	//
-	e = c.connectHandlerOverWS(ch)
+	e = c.connectHandler(ch)
 	if e != nil {
-		c.sysAbort() // Shutdown ,  we are done with errors
-		return c, e
+		c.connectAbort() // Shutdown ,  we are done with errors
+		return e
 	}
+	return nil
+}
 
-	// We are connected
-	go c.readerOverWS()
-	//
-	return c, e
+/*
+	Mark the connection connected, and start the writer for the current
+	transport.  Client frames are written from here on.
+*/
+func (c *Connection) startSession() {
+	c.setConnected(true)
+	go c.writer()
 }
@@ -17,9 +17,7 @@
 package stompws
 
 import (
-	"bufio"
 	"bytes"
-	"io"
 
 	// "fmt"
 	"strings"
@@ -42,65 +40,18 @@ func (e *CONNERROR) Error() string {
 */
 func (c *Connection) connectHandler(h Headers) (e error) {
 	//fmt.Printf("CHDB01\n")
-	c.rdr = bufio.NewReader(c.netconn)
-	b, e := c.rdr.ReadBytes(0)
-	if e != nil {
-		return e
+	// One reader for the life of the transport, frames may span messages
+	if c.wsConn != nil {
+		c.newReader(newWSStream(c.wsConn))
+	} else {
+		c.newReader(c.netconn)
 	}
-	//fmt.Printf("CHDB02\n")
-	f, e := connectResponse(string(b))
+	b, e := c.readResponse()
 	if e != nil {
 		return e
 	}
-	//fmt.Printf("CHDB03\n")
-	//
-	c.ConnectResponse = &Message{f.Command, f.Headers, f.Body}
-	if c.ConnectResponse.Command == ERROR {
-		return &CONNERROR{ECONERR, string(f.Body)}
-	}
-	//fmt.Printf("CHDB04\n")
-	//
-	e = c.setProtocolLevel(h, c.ConnectResponse.Headers)
-	if e != nil {
-		return e
-	}
-	//fmt.Printf("CHDB05\n")
-	//
-	if s, ok := c.ConnectResponse.Headers.Contains(HK_SESSION); ok {
-		c.sessLock.Lock()
-		c.session = s
-		c.sessLock.Unlock()
-	}
-
-	if c.Protocol() >= SPL_11 {
-		e = c.initializeHeartBeats(h)
-		if e != nil {
-			return e
-		}
-	}
-	//fmt.Printf("CHDB06\n")
-
-	c.setConnected(true)
-	c.mets.tfr += 1
-	c.mets.tbr += c.ConnectResponse.Size(false)
-	return nil
-}
-
-func (c *Connection) connectHandlerOverWS(h Headers) (e error) {
-	//fmt.Printf("CHDB01\n")
-	var b []byte
-	// affected by heartbeat may receive empty frame
-	for strings.TrimSpace(string(b)) == "" {
-		_, r, e := c.wsConn.NextReader()
-		if e != nil {
-			return e
-		}
-		c.rdr = bufio.NewReader(r)
-		b, e = c.rdr.ReadBytes(0)
-		if e != nil && e != io.EOF {
-			return e
-		}
-	}
+	// Heart beats may arrive before the response
+	b = bytes.TrimLeft(b, "\r\n")
 
 	//fmt.Printf("CHDB02\n")
 	f, e := connectResponse(string(b))
@@ -135,9 +86,7 @@ func (c *Connection) connectHandlerOverWS(h Headers) (e error) {
 	}
 	//fmt.Printf("CHDB06\n")
 
-	c.setConnected(true)
-	c.mets.tfr += 1
-	c.mets.tbr += c.ConnectResponse.Size(false)
+	c.mets.read(CONNECTED, c.ConnectResponse.Size(false))
 	return nil
 }
 
@@ -152,6 +101,7 @@ func connectResponse(s string) (*Frame, error) {
 	f := new(Frame)
 	f.Headers = Headers{}
 	f.Body = make([]uint8, 0)
+	s = trimCR(s)
 
 	// Get f.Command
 	c := strings.SplitN(s, "\n", 2)
@@ -211,6 +161,32 @@ func connectResponse(s string) (*Frame, error) {
 	return f, nil
 }
 
+/*
+	STOMP 1.2 allows CRLF line ends.  Remove the CR from the command and
+	header lines, but never from the body.
+*/
+func trimCR(s string) string {
+	if !strings.Contains(s, "\r") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		l := strings.TrimSuffix(s[:i], "\r")
+		b.WriteString(l)
+		b.WriteByte('\n')
+		s = s[i+1:]
+		if l == "" { // End of headers
+			b.WriteString(s)
+			return b.String()
+		}
+	}
+}
+
 /*
 	Check client version, one time use during initial connect.
 */
@@ -17,8 +17,7 @@
 package stompws
 
 import (
-	"log"
-	"runtime"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,6 +30,17 @@ func (c *Connection) Connected() bool {
 	return c.isConnected()
 }
 
+/*
+	Broker returns a description of the broker currently in use.  For
+	failover connections this is the endpoint URI, otherwise it is the
+	remote network address.
+*/
+func (c *Connection) Broker() string {
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	return c.broker
+}
+
 /*
 	Session returns the broker assigned session id.
 */
@@ -47,38 +57,16 @@ func (c *Connection) Protocol() string {
 	return c.protocol
 }
 
-/*
-	SetLogger enables a client defined logger for this connection.
-
-	Set to "nil" to disable logging.
-
-	Example:
-		// Start logging
-		l := log.New(os.Stdout, "", log.Ldate|log.Lmicroseconds)
-		c.SetLogger(l)
-*/
-func (c *Connection) SetLogger(l *log.Logger) {
-	logLock.Lock()
-	c.logger = l
-	logLock.Unlock()
-}
-
-/*
-	GetLogger - returns the current connection logger.
-*/
-func (c *Connection) GetLogger() *log.Logger {
-	return c.logger
-}
-
 /*
 	SendTickerInterval returns any heartbeat send ticker interval in ms.  A return
 	value of zero means	no heartbeats are being sent.
 */
 func (c *Connection) SendTickerInterval() int64 {
-	if c.hbd == nil {
+	hbd := c.heartBeatData()
+	if hbd == nil {
 		return 0
 	}
-	return c.hbd.sti / 1000000
+	return hbd.sti / 1000000
 }
 
 /*
@@ -86,10 +74,11 @@ func (c *Connection) SendTickerInterval() int64 {
 	A return value of zero means no heartbeats are being received.
 */
 func (c *Connection) ReceiveTickerInterval() int64 {
-	if c.hbd == nil {
+	hbd := c.heartBeatData()
+	if hbd == nil {
 		return 0
 	}
-	return c.hbd.rti / 1000000
+	return hbd.rti / 1000000
 }
 
 /*
@@ -97,10 +86,11 @@ func (c *Connection) ReceiveTickerInterval() int64 {
 	zero usually indicates no send heartbeats are enabled.
 */
 func (c *Connection) SendTickerCount() int64 {
-	if c.hbd == nil {
+	hbd := c.heartBeatData()
+	if hbd == nil {
 		return 0
 	}
-	return c.hbd.sc
+	return atomic.LoadInt64(&hbd.sc)
 }
 
 /*
@@ -108,38 +98,55 @@ func (c *Connection) SendTickerCount() int64 {
 	value of zero usually indicates no read heartbeats are enabled.
 */
 func (c *Connection) ReceiveTickerCount() int64 {
-	if c.hbd == nil {
+	hbd := c.heartBeatData()
+	if hbd == nil {
 		return 0
 	}
-	return c.hbd.rc
+	return atomic.LoadInt64(&hbd.rc)
 }
 
 /*
 	FramesRead returns a count of the number of frames read on the connection.
 */
 func (c *Connection) FramesRead() int64 {
-	return c.mets.tfr
+	return atomic.LoadInt64(&c.mets.tfr)
 }
 
 /*
 	BytesRead returns a count of the number of bytes read on the connection.
 */
 func (c *Connection) BytesRead() int64 {
-	return c.mets.tbr
+	return atomic.LoadInt64(&c.mets.tbr)
 }
 
 /*
 	FramesWritten returns a count of the number of frames written on the connection.
 */
 func (c *Connection) FramesWritten() int64 {
-	return c.mets.tfw
+	return atomic.LoadInt64(&c.mets.tfw)
 }
 
 /*
 	BytesWritten returns a count of the number of bytes written on the connection.
 */
 func (c *Connection) BytesWritten() int64 {
-	return c.mets.tbw
+	return atomic.LoadInt64(&c.mets.tbw)
+}
+
+/*
+	LogicalBytesRead returns a count of the number of bytes read on the
+	connection, counting received bodies after decompression.
+*/
+func (c *Connection) LogicalBytesRead() int64 {
+	return atomic.LoadInt64(&c.mets.tbr) + atomic.LoadInt64(&c.mets.xbr)
+}
+
+/*
+	LogicalBytesWritten returns a count of the number of bytes written on the
+	connection, counting sent bodies before compression.
+*/
+func (c *Connection) LogicalBytesWritten() int64 {
+	return atomic.LoadInt64(&c.mets.tbw) + atomic.LoadInt64(&c.mets.xbw)
 }
 
 /*
@@ -165,41 +172,58 @@ func (c *Connection) SetSubChanCap(nc int) {
 	return
 }
 
-// Unexported Connection methods
+/*
+	SetCRLF selects CRLF line ends for frames sent after the call, for brokers
+	that prefer them.  It has effect only when STOMP 1.2 is negotiated, since
+	earlier levels do not allow CRLF.  Received frames may use either.
+*/
+func (c *Connection) SetCRLF(on bool) {
+	c.crlfLock.Lock()
+	c.crlf = on
+	c.crlfLock.Unlock()
+}
+
+func (c *Connection) writeCRLF() bool {
+	c.crlfLock.Lock()
+	defer c.crlfLock.Unlock()
+	return c.crlf
+}
 
 /*
-	Log data if possible.
+	ErrorHandler is a callback function, provided by the client, for errors
+	found by the connection reader.  It is called from the reader goroutine,
+	and should return promptly.
 */
-func (c *Connection) log(v ...interface{}) {
-	logLock.Lock()
-	defer logLock.Unlock()
-	if c.logger == nil {
-		return
-	}
-	_, fn, ld, ok := runtime.Caller(1)
+type ErrorHandler func(md MessageData)
 
-	if ok {
-		c.logger.Printf("%s %s %d %v\n", c.session, fn, ld, v)
-	} else {
-		c.logger.Printf("%s %v\n", c.session, v)
-	}
-	return
+/*
+	SetErrorHandler sets the reader error callback.  Each error the reader
+	reports on the MessageData channel, including the error that ends a
+	session, is also passed to f.  A nil f removes the callback.
+
+	Example:
+		c.SetErrorHandler(func(md stompngo.MessageData) {
+			log.Printf("reader error: %v %v\n", md.Error, md.Message.Command)
+		})
+*/
+func (c *Connection) SetErrorHandler(f ErrorHandler) {
+	c.errhLock.Lock()
+	c.errh = f
+	c.errhLock.Unlock()
 }
 
+// Unexported Connection methods
+
 /*
-	Log data if possible (extended / abbreviated) logic).
+	Pass a reader error to any client callback.
 */
-func (c *Connection) logx(v ...interface{}) {
-	_, fn, ld, ok := runtime.Caller(1)
-
-	c.sessLock.Lock()
-	if ok {
-		c.logger.Printf("%s %s %d %v\n", c.session, fn, ld, v)
-	} else {
-		c.logger.Printf("%s %v\n", c.session, v)
+func (c *Connection) notifyError(md MessageData) {
+	c.errhLock.Lock()
+	f := c.errh
+	c.errhLock.Unlock()
+	if f != nil {
+		f(md)
 	}
-	c.sessLock.Unlock()
-	return
 }
 
 /*
@@ -207,18 +231,18 @@ func (c *Connection) logx(v ...interface{}) {
 */
 func (c *Connection) shutdownHeartBeats() {
 	// Shutdown heartbeats if necessary
-	if c.hbd != nil {
-		c.hbd.clk.Lock()
-		if !c.hbd.ssdn {
-			if c.hbd.hbs {
-				close(c.hbd.ssd)
+	if hbd := c.heartBeatData(); hbd != nil {
+		hbd.clk.Lock()
+		if !hbd.ssdn {
+			if hbd.hbs {
+				close(hbd.ssd)
 			}
-			if c.hbd.hbr {
-				close(c.hbd.rsd)
+			if hbd.hbr {
+				close(hbd.rsd)
 			}
-			c.hbd.ssdn = true
+			hbd.ssdn = true
 		}
-		c.hbd.clk.Unlock()
+		hbd.clk.Unlock()
 	}
 }
 
@@ -226,7 +250,7 @@ func (c *Connection) shutdownHeartBeats() {
 	Shutdown logic.
 */
 func (c *Connection) shutdown() {
-	c.log("SHUTDOWN", "starts")
+	c.log(LogLifecycle, LevelDebug, "SHUTDOWN starts")
 	c.shutdownHeartBeats()
 	// Close all individual subscribe channels
 	// This is a write lock
@@ -237,7 +261,7 @@ func (c *Connection) shutdown() {
 	}
 	c.setConnected(false)
 	c.subsLock.Unlock()
-	c.log("SHUTDOWN", "ends")
+	c.log(LogLifecycle, LevelDebug, "SHUTDOWN ends")
 	return
 }
 
@@ -249,11 +273,22 @@ func (c *Connection) sysAbort() {
 	return
 }
 
+/*
+	Abort logic for a failed CONNECT.  A failed reconnect attempt leaves
+	shutdown to the reconnect logic.
+*/
+func (c *Connection) connectAbort() {
+	if c.isReconnecting() {
+		return
+	}
+	c.sysAbort()
+}
+
 /*
 	Read error handler.
 */
 func (c *Connection) handleReadError(md MessageData) {
-	c.log("HDRERR", "starts", md)
+	c.log(LogLifecycle, LevelError, "HDRERR starts", "error", md.Error, "headers", md.Message.Headers)
 	c.shutdownHeartBeats() // We are done here
 	// Notify any general subscriber of error
 	select {
@@ -264,18 +299,38 @@ func (c *Connection) handleReadError(md MessageData) {
 	// This is a read lock
 	c.subsLock.RLock()
 	if c.isConnected() {
-		for key := range c.subs {
-			c.subs[key].md <- md
+		for _, ps := range c.subs {
+			c.deliverError(ps, md)
 		}
 	}
 	c.subsLock.RUnlock()
 	// Try to catch the writer
 	close(c.wtrsdc)
-	c.log("HDRERR", "ends")
+	c.readerClosed(md.Error)
+	c.log(LogLifecycle, LevelDebug, "HDRERR ends")
 	// Let further shutdown logic proceed normally.
 	return
 }
 
+/*
+	The heart beat data for the current transport, nil if there are no heart
+	beats.  A reconnect replaces it.
+*/
+func (c *Connection) heartBeatData() *heartBeatData {
+	c.hbdLock.Lock()
+	defer c.hbdLock.Unlock()
+	return c.hbd
+}
+
+/*
+	Heart beat data set
+*/
+func (c *Connection) setHeartBeatData(hbd *heartBeatData) {
+	c.hbdLock.Lock()
+	c.hbd = hbd
+	c.hbdLock.Unlock()
+}
+
 /*
 	Connected check
 */
@@ -0,0 +1,216 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+	Test SendContext with a stuck writer, and that the writer recovers.
+*/
+func TestContextSendStuck(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{})
+	if e != nil {
+		t.Fatalf("TestContextSendStuck CONNECT expected nil, got %v\n", e)
+	}
+	// Nothing reads the pipe, the writer is stuck on the first SEND.
+	sh := Headers{HK_DESTINATION, "/queue/ctx.stuck"}
+	ctx, cf := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cf()
+	if e = c.SendContext(ctx, sh, "one"); e != context.DeadlineExceeded {
+		t.Fatalf("TestContextSendStuck expected [%v], got [%v]\n",
+			context.DeadlineExceeded, e)
+	}
+	// The writer must still be usable once the wire drains.
+	go s.run()
+	if f := s.next(t); string(f.Body) != "one" {
+		t.Fatalf("TestContextSendStuck expected one, got %v\n", f)
+	}
+	if e = c.Send(sh, "two"); e != nil {
+		t.Fatalf("TestContextSendStuck Send expected nil, got %v\n", e)
+	}
+	if f := s.next(t); string(f.Body) != "two" {
+		t.Fatalf("TestContextSendStuck expected two, got %v\n", f)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test operations with an already canceled context.
+*/
+func TestContextCanceled(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	n, _ := b.dial()
+	defer n.Close()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestContextCanceled CONNECT expected nil, got %v\n", e)
+	}
+	ctx, cf := context.WithCancel(context.Background())
+	cf()
+	sh := Headers{HK_DESTINATION, "/queue/ctx.canceled", HK_ID, "ctxsub"}
+	if _, e = c.SubscribeContext(ctx, sh); e != context.Canceled {
+		t.Fatalf("TestContextCanceled Subscribe expected [%v], got [%v]\n",
+			context.Canceled, e)
+	}
+	// The subscription must not be left behind
+	if _, e = c.Subscribe(sh); e != nil {
+		t.Fatalf("TestContextCanceled Subscribe expected nil, got %v\n", e)
+	}
+	if e = c.BeginContext(ctx, Headers{HK_TRANSACTION, "tx1"}); e != context.Canceled {
+		t.Fatalf("TestContextCanceled Begin expected [%v], got [%v]\n",
+			context.Canceled, e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test DisconnectContext when the broker never sends a RECEIPT.
+*/
+func TestContextDisconnectNoReceipt(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	b.withoutReceipts()
+	n, _ := b.dial()
+	defer n.Close()
+	c, e := Connect(n, Headers{})
+	if e != nil {
+		t.Fatalf("TestContextDisconnectNoReceipt CONNECT expected nil, got %v\n", e)
+	}
+	ctx, cf := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cf()
+	if e = c.DisconnectContext(ctx, empty_headers); e != context.DeadlineExceeded {
+		t.Fatalf("TestContextDisconnectNoReceipt expected [%v], got [%v]\n",
+			context.DeadlineExceeded, e)
+	}
+	if c.Connected() {
+		t.Fatalf("TestContextDisconnectNoReceipt expected not connected\n")
+	}
+}
+
+/*
+	Test SubscribeContext when ctx ends after the SUBSCRIBE was queued.
+*/
+func TestContextSubscribeQueued(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestContextSubscribeQueued CONNECT expected nil, got %v\n", e)
+	}
+	// Nothing reads the pipe, the writer is stuck on the SUBSCRIBE.
+	sh := Headers{HK_DESTINATION, "/queue/ctx.queued", HK_ID, "ctxq"}
+	ctx, cf := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cf()
+	if _, e = c.SubscribeContext(ctx, sh); e != context.DeadlineExceeded {
+		t.Fatalf("TestContextSubscribeQueued expected [%v], got [%v]\n",
+			context.DeadlineExceeded, e)
+	}
+	go s.run()
+	if f := s.next(t); f.Command != SUBSCRIBE {
+		t.Fatalf("TestContextSubscribeQueued expected SUBSCRIBE, got %v\n", f)
+	}
+	f := s.next(t)
+	if f.Command != UNSUBSCRIBE || f.Headers.Value(HK_ID) != "ctxq" {
+		t.Fatalf("TestContextSubscribeQueued expected UNSUBSCRIBE ctxq, got %v\n", f)
+	}
+	// The subscription is removed once the UNSUBSCRIBE is written
+	for i := 0; ; i++ {
+		c.subsLock.RLock()
+		_, ok := c.subs["ctxq"]
+		c.subsLock.RUnlock()
+		if !ok {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("TestContextSubscribeQueued subscription not removed\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, e = c.Subscribe(sh); e != nil {
+		t.Fatalf("TestContextSubscribeQueued Subscribe expected nil, got %v\n", e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test SubscribeContext when ctx ends, and more messages arrive than the
+	subscription channel holds before the UNSUBSCRIBE is written.
+*/
+func TestContextSubscribeBacklog(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestContextSubscribeBacklog CONNECT expected nil, got %v\n", e)
+	}
+	c.SetSubChanCap(2)
+	// Nothing reads the pipe, the writer is stuck on the SUBSCRIBE.
+	sh := Headers{HK_DESTINATION, "/queue/ctx.backlog", HK_ID, "ctxb"}
+	ctx, cf := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cf()
+	if _, e = c.SubscribeContext(ctx, sh); e != context.DeadlineExceeded {
+		t.Fatalf("TestContextSubscribeBacklog expected [%v], got [%v]\n",
+			context.DeadlineExceeded, e)
+	}
+	if f, e := s.readFrame(); e != nil || f.Command != SUBSCRIBE {
+		t.Fatalf("TestContextSubscribeBacklog expected SUBSCRIBE, got %v %v\n", f, e)
+	}
+	go func() {
+		for i := 0; i < 5; i++ {
+			s.send(MESSAGE, Headers{HK_DESTINATION, "/queue/ctx.backlog",
+				HK_SUBSCRIPTION, "ctxb", HK_MESSAGE_ID, strconv.Itoa(i)}, "backlog")
+		}
+	}()
+	time.Sleep(50 * time.Millisecond) // The reader fills the channel
+	go s.run()
+	f := s.next(t)
+	if f.Command != UNSUBSCRIBE || f.Headers.Value(HK_ID) != "ctxb" {
+		t.Fatalf("TestContextSubscribeBacklog expected UNSUBSCRIBE ctxb, got %v\n", f)
+	}
+	// A stuck reader also blocks subsLock, so poll it in a goroutine
+	gone := make(chan bool)
+	go func() {
+		for {
+			c.subsLock.RLock()
+			_, ok := c.subs["ctxb"]
+			c.subsLock.RUnlock()
+			if !ok {
+				close(gone)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-gone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestContextSubscribeBacklog subscription not removed\n")
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -0,0 +1,137 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Test CONNECTED / ERROR parsing with CRLF line ends.
+*/
+func TestCRLFConnectResponse(t *testing.T) {
+	for i, d := range []struct {
+		data string
+		resp error
+		hdrs Headers
+		body string
+	}{
+		{"CONNECTED\r\n\r\n\x00", nil, Headers{}, ""},
+		{"CONNECTED\r\nversion:1.2\r\nsession:s1\r\n\r\n\x00", nil,
+			Headers{HK_VERSION, SPL_12, HK_SESSION, "s1"}, ""},
+		{"ERROR\r\nmessage:bad\r\n\r\nline 1\r\nline 2\r\n\x00", nil,
+			Headers{HK_MESSAGE, "bad"}, "line 1\r\nline 2\r\n"},
+		{"CONNECTED\r\nk1\r\n\r\n\x00", EUNKHDR, nil, ""},
+		{"CONNECTED\r\n\r\nconnbody\x00", EBDYDATA, nil, ""},
+	} {
+		f, e := connectResponse(d.data)
+		if e != d.resp {
+			t.Fatalf("TestCRLFConnectResponse [%d] expected [%v], got [%v]\n", i, d.resp, e)
+		}
+		if e != nil {
+			continue
+		}
+		if !f.Headers.Compare(d.hdrs) || string(f.Body) != d.body {
+			t.Fatalf("TestCRLFConnectResponse [%d] expected %q %q, got %q %q\n",
+				i, d.hdrs, d.body, f.Headers, f.Body)
+		}
+	}
+}
+
+/*
+	Test a 1.2 session with CRLF line ends in both directions.
+*/
+func TestCRLFSession(t *testing.T) {
+	n, s := fakePipe()
+	go func() {
+		if _, e := s.readFrame(); e == nil {
+			s.sendRaw("CONNECTED\r\nversion:1.2\r\nsession:crlf\r\n\r\n\x00")
+		}
+	}()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestCRLFSession CONNECT expected nil, got %v\n", e)
+	}
+	if c.Protocol() != SPL_12 || c.Session() != "crlf" {
+		t.Fatalf("TestCRLFSession bad CONNECTED %v\n", c.ConnectResponse)
+	}
+	c.SetCRLF(true)
+	type subResult struct {
+		sc <-chan MessageData
+		e  error
+	}
+	rc := make(chan subResult, 1)
+	go func() {
+		sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/crlf", HK_ID, "s1"})
+		rc <- subResult{sc, e}
+	}()
+	raw, e := s.r.ReadString(0)
+	if e != nil {
+		t.Fatalf("TestCRLFSession read expected nil, got %v\n", e)
+	}
+	if !strings.HasPrefix(raw, "SUBSCRIBE\r\n") || !strings.HasSuffix(raw, "\r\n\r\n\x00") ||
+		strings.Contains(strings.Replace(raw, "\r\n", "", -1), "\n") {
+		t.Fatalf("TestCRLFSession expected CRLF line ends, got %q\n", raw)
+	}
+	r := <-rc
+	if r.e != nil {
+		t.Fatalf("TestCRLFSession Subscribe expected nil, got %v\n", r.e)
+	}
+	s.sendRaw("\r\nMESSAGE\r\nsubscription:s1\r\nmessage-id:m1\r\n" +
+		"destination:/queue/crlf\r\ncontent-length:4\r\n\r\nbody\x00" +
+		"MESSAGE\r\nsubscription:s1\r\nmessage-id:m2\r\n" +
+		"destination:/queue/crlf\r\n\r\nno length\x00")
+	for _, w := range []string{"body", "no length"} {
+		select {
+		case md := <-r.sc:
+			if md.Error != nil || md.Message.BodyString() != w ||
+				md.Message.Headers.Value(HK_DESTINATION) != "/queue/crlf" {
+				t.Fatalf("TestCRLFSession bad MESSAGE %q %v\n", md.Message, md.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestCRLFSession no MESSAGE\n")
+		}
+	}
+	go s.run()
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test SetCRLF while another goroutine sends.
+*/
+func TestCRLFConcurrent(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			c.SetCRLF(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if e := c.Send(Headers{HK_DESTINATION, "/queue/crlf.set"}, "body"); e != nil {
+			t.Fatalf("TestCRLFConcurrent SEND expected nil, got %v\n", e)
+		}
+	}
+	<-done
+	e := c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -18,11 +18,13 @@ package stompws
 
 import (
 	"bufio"
+	"context"
 	"log"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/drawdy/stomp-ws-go/frame"
 	"github.com/gorilla/websocket"
 )
 
@@ -122,6 +124,71 @@ type Stomper interface {
 	SendBytes(h Headers, b []byte) error
 }
 
+/*
+	ContextStomper is an interface that models STOMP specification commands
+	which can be canceled using a context.Context.  It is not part of
+	STOMPConnector, type assert a STOMPConnector to reach it.
+*/
+type ContextStomper interface {
+	AbortContext(ctx context.Context, h Headers) error
+	AckContext(ctx context.Context, headers Headers) error
+	BeginContext(ctx context.Context, h Headers) error
+	CommitContext(ctx context.Context, h Headers) error
+	DisconnectContext(ctx context.Context, headers Headers) error
+	NackContext(ctx context.Context, headers Headers) error
+	SendContext(ctx context.Context, h Headers, b string) error
+	SubscribeContext(ctx context.Context, headers Headers) (<-chan MessageData, error)
+	UnsubscribeContext(ctx context.Context, headers Headers) error
+	//
+	SendBytesContext(ctx context.Context, h Headers, b []byte) error
+}
+
+/*
+	ReceiptStomper is an interface that models STOMP specification commands
+	which return a Receipt for a broker RECEIPT frame.
+*/
+type ReceiptStomper interface {
+	SendWithReceipt(h Headers, b string) (*Receipt, error)
+	SendBytesWithReceipt(h Headers, b []byte) (*Receipt, error)
+	SubscribeWithReceipt(h Headers) (<-chan MessageData, *Receipt, error)
+	WithReceipt(cmd string, h Headers) (*Receipt, error)
+}
+
+/*
+	HandlerStomper is an interface that models subscriptions served by
+	handler functions.  It is not part of STOMPConnector, type assert a
+	STOMPConnector to reach it.
+*/
+type HandlerStomper interface {
+	SubscribeFunc(h Headers, hf func(MessageData) error, o *SubscribeOptions) (*Subscription, error)
+}
+
+/*
+	DeliveryStomper is an interface that models self acknowledging
+	Deliveries.  It is not part of STOMPConnector, type assert a
+	STOMPConnector to reach it.
+*/
+type DeliveryStomper interface {
+	NewDelivery(md MessageData) (*Delivery, error)
+}
+
+/*
+	TxStomper is an interface that models transactions as objects.  It is
+	not part of STOMPConnector, type assert a STOMPConnector to reach it.
+*/
+type TxStomper interface {
+	BeginTx() (*Tx, error)
+}
+
+/*
+	ValueStomper is an interface that models sending values marshaled by a
+	Codec.  It is not part of STOMPConnector, type assert a STOMPConnector
+	to reach it.
+*/
+type ValueStomper interface {
+	SendValue(h Headers, v interface{}) error
+}
+
 /*
 	StatsReader is an interface that modela a reader for the statistics
 	maintained by the stompngo package.
@@ -170,6 +237,16 @@ type Monitor interface {
 	SubChanCap() int
 }
 
+/*
+	BrokerMonitor is an interface that models reporting the broker a
+	stompngo connection is using.  Broker is not added to Monitor, so that
+	existing Monitor implementations keep compiling.  A Connection
+	implements both.
+*/
+type BrokerMonitor interface {
+	Broker() string
+}
+
 /*
 	ParmHandler is an interface that models stompngo client parameter
 	specification.
@@ -180,6 +257,80 @@ type ParmHandler interface {
 	SetSubChanCap(nc int)
 }
 
+/*
+	WSModeHandler is an interface that models the WebSocket message type
+	choice.  It is not part of STOMPConnector, type assert a STOMPConnector
+	to reach it.
+*/
+type WSModeHandler interface {
+	SetWSMessageMode(m WSMessageMode)
+}
+
+/*
+	CompressionHandler is an interface that models SEND body compression,
+	and the uncompressed byte counts.  It is not part of STOMPConnector,
+	type assert a STOMPConnector to reach it.
+*/
+type CompressionHandler interface {
+	SetCompression(enc string, min int) error
+	LogicalBytesRead() int64
+	LogicalBytesWritten() int64
+}
+
+/*
+	CRLFHandler is an interface that models CRLF line ends on sent frames.
+	It is not part of STOMPConnector, type assert a STOMPConnector to reach
+	it.
+*/
+type CRLFHandler interface {
+	SetCRLF(on bool)
+}
+
+/*
+	LimitsHandler is an interface that models received frame size limits.
+	It is not part of STOMPConnector, type assert a STOMPConnector to reach
+	it.
+*/
+type LimitsHandler interface {
+	SetFrameLimits(l frame.Limits)
+}
+
+/*
+	ErrorReporter is an interface that models the callback for frames the
+	client cannot handle, and for session ending read errors.  It is not
+	part of STOMPConnector, type assert a STOMPConnector to reach it.
+*/
+type ErrorReporter interface {
+	SetErrorHandler(f ErrorHandler)
+}
+
+/*
+	SnapshotReader is an interface that models a snapshot of the connection
+	counters.  It is not part of STOMPConnector, type assert a
+	STOMPConnector to reach it.
+*/
+type SnapshotReader interface {
+	Stats() Stats
+}
+
+/*
+	LogHandler is an interface that models structured, leveled logging.  It
+	is not part of STOMPConnector, type assert a STOMPConnector to reach it.
+*/
+type LogHandler interface {
+	SetStructuredLogger(l Logger)
+	SetLogLevel(lc LogCategory, l Level)
+}
+
+/*
+	EventMonitor is an interface that models connection lifecycle events.
+	It is not part of STOMPConnector, type assert a STOMPConnector to reach
+	it.
+*/
+type EventMonitor interface {
+	Events() <-chan Event
+}
+
 /*
 	STOMPConnector is an interface that encapsulates the Connection struct.
 */
@@ -202,6 +353,7 @@ type Connection struct {
 	MessageData       <-chan MessageData // Inbound data for the client.
 	connected         bool
 	connLock          sync.Mutex // connected variable lock
+	broker            string     // Current broker, see Broker()
 	session           string
 	sessLock          sync.Mutex // session variable lock
 	protocol          string
@@ -214,17 +366,43 @@ type Connection struct {
 	ssdc              chan struct{} // System shutdown channel
 	abortOnce         sync.Once     // Ensure close ssdc once
 	wtrsdc            chan struct{} // Special writer shutdown channel
+	wtrdc             chan struct{} // Writer done channel
 	hbd               *heartBeatData
+	hbdLock           sync.Mutex // hbd lock
 	wtr               *bufio.Writer
 	rdr               *bufio.Reader
+	dec               *frame.Decoder
 	Hbrf              bool // Indicates a heart beat read/receive failure, which is possibly transient.  Valid for 1.1+ only.
 	Hbsf              bool // Indicates a heart beat send failure, which is possibly transient.  Valid for 1.1+ only.
-	logger            *log.Logger
+	logger            Logger
 	mets              *metrics        // Client metrics
 	scc               int             // Subscribe channel capacity
 	discLock          sync.Mutex      // DISCONNECT lock
 	dld               *deadlineData   // Deadline data
 	wsConn            *websocket.Conn // WebSocket connection
+	rcd               *reconnectData  // Reconnect data, nil if not enabled
+	connectHeaders    Headers         // CONNECT headers, replayed on reconnect
+	rcpm              *receiptManager // Pending receipts
+	wsmmLock          sync.Mutex      // wsmm lock
+	wsmm              WSMessageMode   // WebSocket message type selection
+	txLock            sync.Mutex      // txs lock
+	txs               map[string]*Tx  // Open BeginTx transactions
+	cencLock          sync.Mutex      // cenc, cmin lock
+	cenc              string          // SEND body compression, "" for none
+	cmin              int             // Compression size threshold
+	crlfLock          sync.Mutex      // crlf lock
+	crlf              bool            // Write CRLF line ends for 1.2
+	limLock           sync.Mutex      // lim lock
+	lim               frame.Limits    // Received frame limits
+	errhLock          sync.Mutex      // errh lock
+	errh              ErrorHandler    // Reader error callback, may be nil
+	stdl              *log.Logger     // Logger set by SetLogger
+	logLevels         [logCategories]Level
+	evLock            sync.Mutex // Event lock
+	evc               chan Event // Lifecycle events
+	evcd              bool       // evc closed
+	evst              ConnState  // Latest state event
+	evhb              int        // Heart beat failure flags
 }
 
 type subscription struct {
@@ -235,6 +413,14 @@ type subscription struct {
 	drav bool             // Drain After value validity
 	dra  uint             // Start draining after # messages (MESSAGE frames)
 	drmc uint             // Current drain count if draining
+	hdrs Headers          // SUBSCRIBE headers, replayed on reconnect
+	stop chan struct{}    // Closed on UNSUBSCRIBE
+	ovf  string           // Overflow policy
+	//
+	drops int64 // Messages dropped on overflow, atomic
+	dlv   int64 // Messages delivered, atomic
+	acks  int64 // ACKs sent, atomic
+	nacks int64 // NACKs sent, atomic
 }
 
 /*
@@ -327,11 +513,68 @@ const (
 	// Invalid broker command
 	EINVBCMD = Error("invalid broker command")
 
+	// MESSAGE frame without a subscription header
+	EMSGNOSUB = Error("subscription header required, MESSAGE")
+
+	// Connection protocol level is not one this package supports
+	EBADPROTO = Error("internal protocol level error")
+
 	// Invalid receipt-id string
 	EBADRID = Error("invalid receipt-id")
 
 	// DISCONNECT timeout
 	EDISCTO = Error("DISCONNECT timeout")
+
+	// No dial function supplied for reconnect
+	ENODIAL = Error("dial function required, reconnect")
+
+	// Failover errors
+	ENOENDPT = Error("no endpoints, failover")
+	EBADENDP = Error("invalid endpoint, failover")
+
+	// Receipt errors
+	ERCPTTO  = Error("receipt timeout")
+	ERCPTERR = Error("broker returned ERROR frame, receipt")
+	ERCPTCMD = Error("receipt not supported for command")
+
+	// Negotiated WebSocket subprotocol does not match accept-version
+	EBADWSSP = Error("WebSocket subprotocol not accepted, CONNECT")
+
+	// Server side session errors.
+	ESVRCON   = Error("CONNECT or STOMP frame required, server")
+	EINVCCMD  = Error("invalid client command")
+	EBADHBCLI = Error("invalid heart-beat header, client")
+
+	// Handler required.
+	ENOHNDLR = Error("handler function required, SubscribeFunc")
+
+	// Delivery errors.
+	EDLVMSG  = Error("MESSAGE frame required, Delivery")
+	EACKAUTO = Error("ACK and NACK not allowed, auto ack mode")
+
+	// Transaction already committed or aborted.
+	ETXDONE = Error("transaction already completed")
+
+	// Request has no reply-to header.
+	ENOREPLY = Error("reply-to header required, Reply")
+
+	// Flow control errors.
+	ESBADMIF = Error("invalid max in flight value, SUBSCRIBE")
+	ESBADOVF = Error("invalid overflow policy, SUBSCRIBE")
+	ESUBOVFL = Error("subscription overflow, messages dropped")
+
+	// Codec errors.
+	ENOCODEC  = Error("no codec registered for content-type")
+	ECODECTYP = Error("value type not supported by codec")
+
+	// Compression errors.
+	EBADCENC = Error("unsupported content-encoding")
+
+	// Frame limit errors, see DefaultFrameLimits.
+	ELIMLINE = Error("frame line length limit exceeded")
+	ELIMHDRS = Error("frame header count limit exceeded")
+	ELIMHDRB = Error("frame header size limit exceeded")
+	ELIMBODY = Error("frame body size limit exceeded")
 )
 
 /*
@@ -398,11 +641,30 @@ type heartBeatData struct {
 	Control structure for basic client metrics.
 */
 type metrics struct {
-	st  time.Time // Start Time
-	tfr int64     // Total frame reads
-	tbr int64     // Total bytes read
-	tfw int64     // Total frame writes
-	tbw int64     // Total bytes written
+	// Counters are atomic, and first for 64 bit alignment
+	tfr int64 // Total frame reads
+	tbr int64 // Total bytes read
+	tfw int64 // Total frame writes
+	tbw int64 // Total bytes written
+	xbr int64 // Bytes added by decompression, reads
+	xbw int64 // Bytes saved by compression, writes
+	rer int64 // Read errors
+	wer int64 // Write errors
+	per int64 // Frames reported with a protocol error
+	lrt int64 // Last frame read, ns
+	lwt int64 // Last frame written, ns
+	hsf int64 // Heart beat send failures
+	hrf int64 // Heart beat receive ticks with no data
+	evd int64 // Events dropped, Events channel full
+	//
+	cfr map[string]*cmdCount // Frames read by command, fixed keys
+	cfw map[string]*cmdCount // Frames written by command, fixed keys
+	st  time.Time            // Start Time
+}
+
+type cmdCount struct {
+	f int64 // Frames, atomic
+	b int64 // Bytes, atomic
 }
 
 /*
@@ -410,6 +672,13 @@ type metrics struct {
 */
 var validCmds = map[string]bool{MESSAGE: true, ERROR: true, RECEIPT: true}
 
+/*
+  Valid client commands, for the server side.
+*/
+var validClientCmds = map[string]bool{CONNECT: true, STOMP: true,
+	DISCONNECT: true, SEND: true, SUBSCRIBE: true, UNSUBSCRIBE: true,
+	ACK: true, NACK: true, BEGIN: true, COMMIT: true, ABORT: true}
+
 var logLock sync.Mutex
 
 const (
@@ -444,6 +713,17 @@ const (
 	HK_TRANSACTION    = "transaction"
 	HK_VERSION        = "version"
 	HK_VHOST          = "host" // HK_HOST alias
+
+	// Request / reply headers
+	HK_REPLY_TO       = "reply-to"       // Not in any spec, but widely used
+	HK_CORRELATION_ID = "correlation-id" // Not in any spec, but widely used
+
+	// Broker prefetch headers
+	HK_AMQ_PREFETCH   = "activemq.prefetchSize" // ActiveMQ, Artemis
+	HK_PREFETCH_COUNT = "prefetch-count"        // RabbitMQ
+
+	// Body compression header
+	HK_CONTENT_ENCODING = "content-encoding" // Not in any spec, see compress.go
 )
 
 /*
@@ -474,6 +754,11 @@ const (
 const (
 	StompPlusDrainAfter = "sng_drafter" // SUBSCRIBE Header
 	StompPlusDrainNow   = "sng_drnow"   // UNSUBSCRIBE Header
+
+	StompPlusMaxInFlight = "sng_maxinflight" // SUBSCRIBE Header, see flow.go
+	StompPlusOverflow    = "sng_overflow"    // SUBSCRIBE Header, see flow.go
+
+	StompPlusCompress = "sng_compress" // SEND Header, see compress.go
 )
 
 var (
@@ -17,6 +17,7 @@
 package stompws
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -168,3 +169,31 @@ func BenchmarkHeaderAppend(b *testing.B) {
 		_ = append(h, "akey", "avalue")
 	}
 }
+
+/*
+	Data Test: a Connection implements the optional interfaces.
+*/
+func TestDataOptionalInterfaces(t *testing.T) {
+	ct := reflect.TypeOf(&Connection{})
+	for _, it := range []interface{}{
+		(*BrokerMonitor)(nil),
+		(*CRLFHandler)(nil),
+		(*CompressionHandler)(nil),
+		(*ContextStomper)(nil),
+		(*DeliveryStomper)(nil),
+		(*ErrorReporter)(nil),
+		(*EventMonitor)(nil),
+		(*HandlerStomper)(nil),
+		(*LimitsHandler)(nil),
+		(*LogHandler)(nil),
+		(*ReceiptStomper)(nil),
+		(*SnapshotReader)(nil),
+		(*TxStomper)(nil),
+		(*ValueStomper)(nil),
+		(*WSModeHandler)(nil),
+	} {
+		if i := reflect.TypeOf(it).Elem(); !ct.Implements(i) {
+			t.Fatalf("TestDataOptionalInterfaces %s not implemented\n", i.Name())
+		}
+	}
+}
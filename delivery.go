@@ -0,0 +1,137 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+)
+
+/*
+	Delivery is a received MESSAGE that remembers its Connection and
+	subscription, and can acknowledge itself.  The ACK and NACK headers are
+	built for the negotiated protocol level:
+		1.0	message-id
+		1.1	message-id and subscription
+		1.2	id, from the MESSAGE ack header
+*/
+type Delivery struct {
+	MessageData
+	c   *Connection
+	sid string // Subscription id
+	am  string // Subscription ack mode
+}
+
+/*
+	NewDelivery returns a Delivery for a MESSAGE received on one of this
+	Connection's subscriptions.
+
+	Example:
+		for md := range s {
+			d, e := c.NewDelivery(md)
+			if e != nil {
+				// Do something sane ...
+			}
+			// Process d.Message ...
+			e = d.Ack()
+		}
+*/
+func (c *Connection) NewDelivery(md MessageData) (*Delivery, error) {
+	if md.Error != nil {
+		return nil, md.Error
+	}
+	if md.Message.Command != MESSAGE {
+		return nil, EDLVMSG
+	}
+	sid := md.Message.Headers.Value(HK_SUBSCRIPTION)
+	c.subsLock.RLock()
+	s, ok := c.subs[sid]
+	c.subsLock.RUnlock()
+	if !ok {
+		return nil, EBADSID
+	}
+	return &Delivery{MessageData: md, c: c, sid: sid, am: s.am}, nil
+}
+
+/*
+	Subscription returns the subscription id.
+*/
+func (d *Delivery) Subscription() string {
+	return d.sid
+}
+
+/*
+	Ack acknowledges the message.
+*/
+func (d *Delivery) Ack() error {
+	return d.AckInTx("")
+}
+
+/*
+	Nack rejects the message.  STOMP 1.1+ only.
+*/
+func (d *Delivery) Nack() error {
+	return d.NackInTx("")
+}
+
+/*
+	AckInTx acknowledges the message within transaction tx.  An empty tx
+	means no transaction.
+*/
+func (d *Delivery) AckInTx(tx string) error {
+	h, e := d.headers(tx)
+	if e != nil {
+		return e
+	}
+	return d.c.ackContext(context.Background(), h, d.sid)
+}
+
+/*
+	NackInTx rejects the message within transaction tx.  An empty tx means
+	no transaction.  STOMP 1.1+ only.
+*/
+func (d *Delivery) NackInTx(tx string) error {
+	h, e := d.headers(tx)
+	if e != nil {
+		return e
+	}
+	return d.c.nackContext(context.Background(), h, d.sid)
+}
+
+func (d *Delivery) headers(tx string) (Headers, error) {
+	if d.am != AckModeClient && d.am != AckModeClientIndividual {
+		return nil, EACKAUTO
+	}
+	h := ackHeaders(d.c.Protocol(), d.Message)
+	if tx != "" {
+		h = h.Add(HK_TRANSACTION, tx)
+	}
+	return h, nil
+}
+
+/*
+	The ACK or NACK headers for a received MESSAGE, per protocol level.
+*/
+func ackHeaders(p string, m Message) Headers {
+	switch p {
+	case SPL_12:
+		return Headers{HK_ID, m.Headers.Value(HK_ACK)}
+	case SPL_11:
+		return Headers{HK_MESSAGE_ID, m.Headers.Value(HK_MESSAGE_ID),
+			HK_SUBSCRIPTION, m.Headers.Value(HK_SUBSCRIPTION)}
+	}
+	return Headers{HK_MESSAGE_ID, m.Headers.Value(HK_MESSAGE_ID)}
+}
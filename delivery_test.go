@@ -0,0 +1,116 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Test Delivery acknowledgements at each protocol level.
+*/
+func TestDeliveryAck(t *testing.T) {
+	for _, p := range []string{SPL_10, SPL_11, SPL_12} {
+		b, c := connectStomptest(t, p)
+		q := "/queue/delivery." + p
+		am := AckModeClientIndividual
+		if p == SPL_10 {
+			am = AckModeClient
+		}
+		sh := Headers{HK_DESTINATION, q, HK_ID, "s1", HK_ACK, am}
+		s, e := c.Subscribe(sh)
+		if e != nil {
+			t.Fatalf("TestDeliveryAck %s SUBSCRIBE expected nil, got %v\n", p, e)
+		}
+		for _, m := range []string{"ack", "acktx", "nack"} {
+			_ = c.Send(Headers{HK_DESTINATION, q}, m)
+		}
+		for _, m := range []string{"ack", "acktx", "nack"} {
+			var md MessageData
+			select {
+			case md = <-s:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("TestDeliveryAck %s MESSAGE timeout\n", p)
+			}
+			d, e := c.NewDelivery(md)
+			if e != nil {
+				t.Fatalf("TestDeliveryAck %s expected nil, got %v\n", p, e)
+			}
+			if d.Subscription() != "s1" || string(d.Message.Body) != m {
+				t.Fatalf("TestDeliveryAck %s bad Delivery %v\n", p, d.Message)
+			}
+			switch m {
+			case "ack":
+				e = d.Ack()
+			case "acktx":
+				_ = c.Begin(Headers{HK_TRANSACTION, "tx1"})
+				if e = d.AckInTx("tx1"); e == nil {
+					e = c.Commit(Headers{HK_TRANSACTION, "tx1"})
+				}
+			case "nack":
+				e = d.Nack()
+				if p == SPL_10 {
+					if e != EBADVERNAK {
+						t.Fatalf("TestDeliveryAck expected [%v], got [%v]\n",
+							EBADVERNAK, e)
+					}
+					e = d.Ack()
+				}
+			}
+			if e != nil {
+				t.Fatalf("TestDeliveryAck %s %s expected nil, got %v\n", p, m, e)
+			}
+		}
+		// A broker ERROR would close the connection before the receipt
+		_ = c.Unsubscribe(Headers{HK_DESTINATION, q, HK_ID, "s1"})
+		e = c.Disconnect(Headers{HK_RECEIPT, "delivery"})
+		checkDisconnectError(t, e)
+		if d := b.QueueDepth(q); d != 0 {
+			t.Fatalf("TestDeliveryAck %s expected depth 0, got %d\n", p, d)
+		}
+		b.Close()
+	}
+}
+
+/*
+	Test Delivery errors.
+*/
+func TestDeliveryErrors(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	if _, e := c.NewDelivery(MessageData{Message{RECEIPT, Headers{}, NULLBUFF}, nil}); e != EDLVMSG {
+		t.Fatalf("TestDeliveryErrors expected [%v], got [%v]\n", EDLVMSG, e)
+	}
+	s, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/auto", HK_ID, "auto"})
+	if e != nil {
+		t.Fatalf("TestDeliveryErrors SUBSCRIBE expected nil, got %v\n", e)
+	}
+	_ = c.Send(Headers{HK_DESTINATION, "/queue/auto"}, "m")
+	d, e := c.NewDelivery(<-s)
+	if e != nil {
+		t.Fatalf("TestDeliveryErrors expected nil, got %v\n", e)
+	}
+	if e = d.Ack(); e != EACKAUTO {
+		t.Fatalf("TestDeliveryErrors expected [%v], got [%v]\n", EACKAUTO, e)
+	}
+	_ = c.Unsubscribe(Headers{HK_ID, "auto"})
+	if _, e = c.NewDelivery(d.MessageData); e != EBADSID {
+		t.Fatalf("TestDeliveryErrors expected [%v], got [%v]\n", EBADSID, e)
+	}
+	_ = c.Disconnect(empty_headers)
+}
@@ -17,6 +17,7 @@
 package stompws
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
@@ -52,17 +53,32 @@ import (
 
 */
 func (c *Connection) Disconnect(h Headers) error {
+	return c.DisconnectContext(context.Background(), h)
+}
+
+/*
+	DisconnectContext is like Disconnect, but ctx can cancel the operation,
+	or set a deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) DisconnectContext(ctx context.Context, h Headers) error {
 	c.discLock.Lock()
 	defer c.discLock.Unlock()
 	//
 	if !c.isConnected() {
+		if c.isReconnecting() { // Give up on any reconnect in progress
+			c.stopReconnect()
+			c.sysAbort()
+		}
 		return ECONBAD
 	}
-	c.log(DISCONNECT, "start", h)
+	c.log(LogLifecycle, LevelDebug, "DISCONNECT start", "headers", h)
 	e := checkHeaders(h, c.Protocol())
 	if e != nil {
 		return e
 	}
+	c.stateEvent(StateDisconnecting, nil)
+	c.abortTxs() // Before DISCONNECT, not left to the broker
+	c.stopReconnect()
 	ch := h.Clone()
 	// If the caller does not want a receipt do not ask for one.  Otherwise,
 	// add a receipt request if caller did not specifically ask for one.  This is
@@ -76,60 +92,83 @@ func (c *Connection) Disconnect(h Headers) error {
 	}
 	wrid := ""
 	wrid, _ = ch.Contains(HK_RECEIPT)
-	_ = wrid
+	// The reader hands the matching RECEIPT (or ERROR) to r, not to the
+	// shared MessageData channel.
+	var r *Receipt
+	if !cwr {
+		r = c.rcpm.add(wrid)
+		defer c.rcpm.remove(wrid)
+	}
 	//
 	f := Frame{DISCONNECT, ch, NULLBUFF}
 	//
-	r := make(chan error)
-	if e = c.writeWireData(wiredata{f, r}); e != nil {
-		return e
-	}
-	e = <-r
+	e = c.transmitContext(ctx, f)
 	// Drive shutdown logic
 	// Only set DisconnectReceipt if we sucessfully received one, and it is
 	// the one we were expecting.
 	if !cwr && e == nil {
 		// Can be RECEIPT or ERROR frame
-		mds, e := c.getMessageData()
+		mds, e := c.getMessageData(ctx, r)
+		if e != nil && e == ctx.Err() {
+			c.log(LogLifecycle, LevelWarn, "DISCONNECT canceled", "error", e)
+			c.shutdown()
+			c.sysAbort()
+			c.stateEvent(StateClosed, e)
+			return e
+		}
 		//
 		// fmt.Println(DISCONNECT, "sanchek", mds)
 		//
 		switch mds.Message.Command {
 		case ERROR:
 			e = fmt.Errorf("DISBRKERR -> %q", mds.Message)
-			c.log(DISCONNECT, "errf", e)
+			c.log(LogLifecycle, LevelError, "DISCONNECT errf", "error", e)
 		case RECEIPT:
 			gr := mds.Message.Headers.Value(HK_RECEIPT_ID)
 			if wrid != gr {
 				e = fmt.Errorf("%s wanted:%s got:%s", EBADRID, wrid, gr)
-				c.log(DISCONNECT, "nadrid", e)
+				c.log(LogLifecycle, LevelError, "DISCONNECT nadrid", "error", e)
 			} else {
 				c.DisconnectReceipt = mds
-				c.log(DISCONNECT, "OK")
+				c.log(LogLifecycle, LevelInfo, "DISCONNECT OK")
 			}
 		default:
 			e = fmt.Errorf("DISBADFRM -> %q", mds.Message)
-			c.log(DISCONNECT, "badf", e)
+			c.log(LogLifecycle, LevelError, "DISCONNECT badf", "error", e)
 		}
 	}
-	c.log(DISCONNECT, "ends", ch)
+	c.log(LogLifecycle, LevelDebug, "DISCONNECT ends", "headers", ch)
 	c.shutdown()
 	c.sysAbort()
-	c.log(DISCONNECT, "system shutdown cannel closed")
+	if c.rcd != nil { // We own the transport
+		c.closeTransport()
+	}
+	c.stateEvent(StateClosed, e)
+	c.log(LogLifecycle, LevelDebug, "DISCONNECT system shutdown cannel closed")
 	return e
 }
 
-func (c *Connection) getMessageData() (MessageData, error) {
+/*
+	Wait for the DISCONNECT receipt, or any other frame the reader hands to
+	the client.
+*/
+func (c *Connection) getMessageData(ctx context.Context, r *Receipt) (MessageData, error) {
 	var md MessageData
 	var me error
 	me = nil
 	if os.Getenv("STOMP_MAXDISCTO") != "" {
 		d, e := time.ParseDuration(os.Getenv("STOMP_MAXDISCTO"))
 		if e != nil {
-			c.log("DISCGETMD PDERROR -> ", e)
-			md = <-c.input
+			c.log(LogLifecycle, LevelError, "DISCGETMD PDERROR", "error", e)
+			select {
+			case md = <-c.input:
+			case <-r.Done():
+				md = r.md
+			case <-ctx.Done():
+				me = ctx.Err()
+			}
 		} else {
-			c.log("DISCGETMD DUR -> ", d)
+			c.log(LogLifecycle, LevelDebug, "DISCGETMD DUR", "duration", d)
 			ticker := time.NewTicker(d)
 			select {
 			case _ = <-ticker.C:
@@ -137,11 +176,23 @@ func (c *Connection) getMessageData() (MessageData, error) {
 				ticker.Stop()
 			case md = <-c.input:
 				ticker.Stop()
+			case <-r.Done():
+				md = r.md
+				ticker.Stop()
+			case <-ctx.Done():
+				me = ctx.Err()
+				ticker.Stop()
 			}
 		}
 	} else {
-		c.log("DISNOMAX", me)
-		md = <-c.input
+		c.log(LogLifecycle, LevelDebug, "DISNOMAX", "error", me)
+		select {
+		case md = <-c.input:
+		case <-r.Done():
+			md = r.md
+		case <-ctx.Done():
+			me = ctx.Err()
+		}
 	}
 	//
 	return md, me
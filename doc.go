@@ -50,6 +50,140 @@
 		}
 
 
+	Reconnect
+
+	ConnectWithReconnect creates a Connection that dials its own network
+	connections using a ReconnectPolicy.  After a network failure the session
+	and all active subscriptions are re-established, and the subscription
+	MessageData channels remain valid.  Such a Connection closes its network
+	connection on Disconnect.
+
+	ConnectFailover does the same using an ordered list of broker endpoints,
+	see Failover and ParseFailover.  The broker in use is reported by the
+	Broker method.  Broker is in the BrokerMonitor interface rather than in
+	Monitor, because adding a method to Monitor would break its existing
+	implementations.  Type assert a Monitor to reach it:
+
+		if bm, ok := m.(stompngo.BrokerMonitor); ok {
+			log.Println("broker:", bm.Broker())
+		}
+
+
+	Receipts
+
+	SendWithReceipt, SubscribeWithReceipt and WithReceipt add a receipt
+	header to a frame and return a Receipt.  The reader completes the Receipt
+	when the matching RECEIPT, or an ERROR frame with the same receipt-id,
+	arrives.  Those frames are not delivered on the Connection's MessageData
+	channel.  Use Receipt.Wait to block with a timeout.
+
+	These methods are not part of STOMPConnector, which is unchanged.  A
+	Connection implements the ReceiptStomper interface, so use a type
+	assertion to reach them from a STOMPConnector.
+
+
+	Transactions
+
+	BeginTx returns a Tx with a generated transaction id.  Its Send,
+	SendBytes, Ack and Nack methods add the transaction header, and fail once
+	the Tx is committed or aborted.  Disconnect aborts any open Tx.
+
+
+	Request / Reply
+
+	A Requestor sends requests with reply-to and correlation-id headers, and
+	matches the replies arriving on its private reply destination.  A
+	Responder replies to a request's reply-to destination, copying the
+	correlation-id.
+
+
+	Codecs
+
+	SendValue marshals a value with the Codec registered for the frame's
+	content-type header, JSON by default, and Decode unmarshals a received
+	MESSAGE the same way.  JSON, gob and protobuf codecs are built in, and
+	RegisterCodec adds others.
+
+
+	Compression
+
+	SetCompression enables gzip, zstd or snappy compression of SEND bodies
+	above a size threshold, marked with a content-encoding header.  Received
+	MESSAGE bodies are decompressed transparently.  BytesRead and
+	BytesWritten count wire bytes, LogicalBytesRead and LogicalBytesWritten
+	count uncompressed bytes.  These methods are in the CompressionHandler
+	interface, so use a type assertion to reach them from a STOMPConnector.
+
+
+	Metrics
+
+	Connection counters are updated atomically, and may be read from any
+	goroutine.  Stats returns a snapshot of them all: frames by command,
+	bytes, error counts, last read and write times, and per subscription
+	delivered, acked, nacked and dropped message counts.  It also holds heart
+	beat failures, reconnects, subscription channel depths and a receipt
+	latency histogram.  Stats is in the SnapshotReader interface, type
+	assert a STOMPConnector to reach it.  The stompprom subpackage exports
+	Stats to Prometheus.
+
+	The stompotel subpackage propagates OpenTelemetry trace context in the
+	traceparent and tracestate headers, with producer spans for SEND and
+	consumer spans for MESSAGE processing.
+
+
+	Logging
+
+	SetStructuredLogger sends leveled messages with key / value fields to a
+	Logger.  SetLogger remains, for a *log.Logger.  Each message is in a
+	LogCategory: lifecycle, wire frames or heart beats, and SetLogLevel sets
+	the minimum level for each.  Values of RedactedHeaders, the login,
+	passcode and other credential headers by default, are never logged in
+	Headers.  SetStructuredLogger and SetLogLevel are in the LogHandler
+	interface, type assert a STOMPConnector to reach them.  The stomplog
+	subpackage adapts log/slog, zap and logrus loggers.
+
+
+	Events
+
+	Events returns a channel of connection lifecycle events: state changes
+	(connecting, connected, degraded, reconnecting, disconnecting and
+	closed), heart beat misses, write errors and received ERROR frames.  The
+	closed event holds the cause of the shutdown, and then the channel is
+	closed.  Events never block the connection, so a full channel drops
+	them.  Events is in the EventMonitor interface, type assert a
+	STOMPConnector to reach it.
+
+
+	Wire Format
+
+	The frame subpackage holds the STOMP wire format: a streaming Decoder and
+	Encoder handling content-length, NUL terminated bodies, heart beat EOLs,
+	CRLF line ends and per version header escaping.  Both the TCP and the
+	WebSocket transports are built on it.  Received frames may use CRLF line
+	ends, and SetCRLF sends them on a STOMP 1.2 connection.  SetCRLF is in
+	the CRLFHandler interface, type assert a STOMPConnector to reach it.
+
+	Received frames are checked against DefaultFrameLimits, or the limits
+	given to SetFrameLimits.  A frame over a limit is reported with a
+	distinct Error, and the connection is shut down.  SetFrameLimits is in
+	the LimitsHandler interface, type assert a STOMPConnector to reach it.
+
+	A well formed frame the client cannot handle, such as a MESSAGE with no
+	subscription header or an unknown command, does not end the session.  It
+	is sent on the connection MessageData channel with EMSGNOSUB or EINVBCMD,
+	or dropped if that channel is full.  SetErrorHandler registers a callback
+	for these, and for the read error that ends a session.  It is in the
+	ErrorReporter interface, type assert a STOMPConnector to reach it.
+
+
+	Server Side
+
+	WSServer is an http.Handler that terminates STOMP over WebSocket.  It
+	negotiates the protocol level and heart beats, answers CONNECT, RECEIPT
+	requests and DISCONNECT, and passes every other client frame to a
+	ServerHandler.  The handler replies with ServerSession.Send.
+
+
 	STOMP Frames
 
 	The STOMP specification defines these physical frames that can be sent from a client to a STOMP broker:
@@ -80,6 +214,17 @@
 
 	For details on Subscribe requirements and behavior, see: https://github.com/gmallard/stompngo/wiki/subscribe-and-messagedata
 
+	SubscribeFunc instead calls a handler function from a pool of worker
+	goroutines, and ACKs or NACKs each message based on the handler result.
+
+	NewDelivery wraps a received MESSAGE in a Delivery, whose Ack and Nack
+	methods build the headers required by the negotiated protocol level.
+
+	The StompPlusMaxInFlight and StompPlusOverflow SUBSCRIBE headers give a
+	subscription its own channel capacity, broker prefetch limit, and a
+	policy for a full channel, so one slow subscriber does not stall the
+	connection.
+
 
 	RECEIPTs
 
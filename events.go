@@ -0,0 +1,170 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+	EventType identifies the kind of an Event.
+*/
+type EventType int
+
+const (
+	EventState         EventType = iota // Connection state change, see State and Error
+	EventHeartBeatMiss                  // No data within the receive heart beat interval
+	EventWriteError                     // A frame write failed, see Message and Error
+	EventErrorFrame                     // ERROR frame received, see Message
+)
+
+var eventTypeNames = []string{"state", "heartbeat-miss", "write-error",
+	"error-frame"}
+
+/*
+	String makes EventType a Stringer.
+*/
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "unknown"
+	}
+	return eventTypeNames[t]
+}
+
+/*
+	Event is a connection lifecycle event.
+*/
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	State   ConnState // The new state, EventState only
+	Error   error     // Cause, if any
+	Message Message   // The frame involved, if any
+}
+
+/*
+	EventChanCap is the capacity of the Events channel of new connections.
+*/
+var EventChanCap = 64
+
+/*
+	Events returns the connection lifecycle event channel.
+
+	The channel is buffered from connection creation, so the StateConnecting
+	and StateConnected events of Connect are not missed.  Events never block
+	the connection: an event is dropped when the channel is full, and counted
+	in Stats EventsDropped.  The channel is closed after the StateClosed
+	event, whose Error is the cause of the shutdown, nil after a successful
+	Disconnect.
+
+	Example:
+		go func() {
+			for ev := range c.Events() {
+				if ev.Type == stompngo.EventState {
+					log.Printf("connection %v: %v\n", ev.State, ev.Error)
+				}
+			}
+		}()
+*/
+func (c *Connection) Events() <-chan Event {
+	return c.evc
+}
+
+// Unexported Connection methods
+
+/*
+	Queue an event if possible.  Nothing is queued after StateClosed.
+*/
+func (c *Connection) event(ev Event) {
+	c.evLock.Lock()
+	c.queueEvent(ev)
+	c.evLock.Unlock()
+}
+
+/*
+	Queue an event, with evLock held.
+*/
+func (c *Connection) queueEvent(ev Event) {
+	if c.evcd {
+		return
+	}
+	if ev.Type == EventState {
+		c.evst = ev.State
+		if ev.State == StateConnected {
+			c.evhb = 0 // New session, heart beats start clean
+		}
+	}
+	ev.Time = time.Now()
+	select {
+	case c.evc <- ev:
+	default:
+		atomic.AddInt64(&c.mets.evd, 1)
+	}
+	if ev.Type == EventState && ev.State == StateClosed {
+		c.evcd = true
+		close(c.evc)
+	}
+}
+
+/*
+	Queue a state change event.
+*/
+func (c *Connection) stateEvent(s ConnState, e error) {
+	c.event(Event{Type: EventState, State: s, Error: e})
+}
+
+/*
+	Queue StateClosed for a session ended by the reader.  A Disconnect in
+	progress reports its own result.
+*/
+func (c *Connection) readerClosed(e error) {
+	c.evLock.Lock()
+	if c.evst != StateDisconnecting {
+		c.queueEvent(Event{Type: EventState, State: StateClosed, Error: e})
+	}
+	c.evLock.Unlock()
+}
+
+/*
+	Heart beat failure flags, for degraded state tracking.
+*/
+const (
+	hbSendFailed = 1 << iota
+	hbRecvFailed
+)
+
+/*
+	Set or clear a heart beat failure flag.  The connection is degraded while
+	any flag is set.
+*/
+func (c *Connection) hbFailed(f int, on bool) {
+	c.evLock.Lock()
+	defer c.evLock.Unlock()
+	was := c.evhb
+	if on {
+		c.evhb |= f
+	} else {
+		c.evhb &^= f
+	}
+	switch {
+	case was == 0 && c.evhb != 0:
+		c.queueEvent(Event{Type: EventState, State: StateDegraded})
+	case was != 0 && c.evhb == 0:
+		c.queueEvent(Event{Type: EventState, State: StateConnected})
+	}
+}
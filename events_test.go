@@ -0,0 +1,171 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Test helper.  Get the next event.
+*/
+func nextEvent(t *testing.T, c *Connection) Event {
+	select {
+	case ev, ok := <-c.Events():
+		if !ok {
+			t.Fatalf("nextEvent channel closed\n")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("nextEvent timeout\n")
+	}
+	return Event{}
+}
+
+/*
+	Test helper.  Check the next event is a state change.
+*/
+func checkStateEvent(t *testing.T, c *Connection, s ConnState, ee bool) Event {
+	ev := nextEvent(t, c)
+	if ev.Type != EventState || ev.State != s || (ev.Error != nil) != ee {
+		t.Fatalf("checkStateEvent expected %v error %v, got %v %v %v\n",
+			s, ee, ev.Type, ev.State, ev.Error)
+	}
+	return ev
+}
+
+/*
+	Test helper.  Check the event channel is closed.
+*/
+func checkEventsClosed(t *testing.T, c *Connection) {
+	select {
+	case ev, ok := <-c.Events():
+		if ok {
+			t.Fatalf("checkEventsClosed unexpected event %v %v\n", ev.Type, ev.State)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("checkEventsClosed timeout\n")
+	}
+}
+
+/*
+	Test events from CONNECT to DISCONNECT, with an ERROR frame.
+*/
+func TestEvents(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestEvents CONNECT expected nil, got %v\n", e)
+	}
+	go s.run()
+	checkStateEvent(t, c, StateConnecting, false)
+	checkStateEvent(t, c, StateConnected, false)
+	//
+	go s.send(ERROR, Headers{HK_MESSAGE, "bad"}, "")
+	ev := nextEvent(t, c)
+	if ev.Type != EventErrorFrame || ev.Message.Headers.Value(HK_MESSAGE) != "bad" {
+		t.Fatalf("TestEvents expected ERROR frame event, got %v %v\n",
+			ev.Type, ev.Message)
+	}
+	if ev.Time.IsZero() {
+		t.Fatalf("TestEvents event time not set\n")
+	}
+	_ = <-c.MessageData // The ERROR frame
+	//
+	if e = c.Disconnect(empty_headers); e != nil {
+		t.Fatalf("TestEvents DISCONNECT expected nil, got %v\n", e)
+	}
+	checkStateEvent(t, c, StateDisconnecting, false)
+	checkStateEvent(t, c, StateClosed, false)
+	checkEventsClosed(t, c)
+	s.close()
+}
+
+/*
+	Test the shutdown cause is reported when the broker goes away.
+*/
+func TestEventsReadError(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestEventsReadError CONNECT expected nil, got %v\n", e)
+	}
+	checkStateEvent(t, c, StateConnecting, false)
+	checkStateEvent(t, c, StateConnected, false)
+	s.close()
+	checkStateEvent(t, c, StateClosed, true)
+	checkEventsClosed(t, c)
+}
+
+/*
+	Test heart beat misses, and the degraded state.
+*/
+func TestEventsHeartBeat(t *testing.T) {
+	n, s := fakePipe()
+	go func() {
+		if _, e := s.readFrame(); e == nil {
+			s.send(CONNECTED, Headers{HK_VERSION, SPL_12, HK_SESSION, Uuid(),
+				HK_HEART_BEAT, "50,0"}, "")
+		}
+	}()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost",
+		HK_HEART_BEAT, "0,50"})
+	if e != nil {
+		t.Fatalf("TestEventsHeartBeat CONNECT expected nil, got %v\n", e)
+	}
+	checkStateEvent(t, c, StateConnecting, false)
+	checkStateEvent(t, c, StateConnected, false)
+	// The broker sends no heart beats
+	ev := nextEvent(t, c)
+	if ev.Type != EventHeartBeatMiss {
+		t.Fatalf("TestEventsHeartBeat expected %v, got %v\n", EventHeartBeatMiss, ev.Type)
+	}
+	checkStateEvent(t, c, StateDegraded, false)
+	// Now it does
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				s.sendRaw("\n")
+			}
+		}
+	}()
+	for {
+		ev = nextEvent(t, c)
+		if ev.Type == EventState {
+			break
+		}
+	}
+	close(done)
+	if ev.State != StateConnected {
+		t.Fatalf("TestEventsHeartBeat expected %v, got %v\n", StateConnected, ev.State)
+	}
+	s.close()
+	for {
+		ev = nextEvent(t, c)
+		if ev.Type == EventState && ev.State == StateClosed {
+			break
+		}
+	}
+	checkEventsClosed(t, c)
+}
@@ -0,0 +1,283 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Failover is an ordered list of broker endpoints used by a reconnecting
+	connection.
+
+	Endpoints are URIs.  Supported schemes are:
+		tcp, stomp		plain TCP
+		ssl, tls, stomp+ssl	TCP with TLS, see TLSConfig
+		ws, wss			WebSocket
+
+	Endpoints are tried in list order, or in random order if Randomize is
+	set.  The last endpoint that connected successfully is always tried
+	first, and endpoints with recent failures are tried last.
+*/
+type Failover struct {
+	Endpoints []string
+	Randomize bool
+	Policy    ReconnectPolicy   // Reconnect policy, Dial and DialWS are ignored
+	Timeout   time.Duration     // Dial / handshake timeout, 0 means none
+	TLSConfig *tls.Config       // For ssl, tls, stomp+ssl and wss endpoints
+	WSDialer  *websocket.Dialer // For ws and wss endpoints, default websocket.DefaultDialer plus WSSubprotocols
+	WSHeader  http.Header       // Extra HTTP headers for the WebSocket handshake
+	//
+	lock  sync.Mutex
+	fails []int // Consecutive failures, by endpoint
+	last  int   // Last good endpoint, -1 if none
+	cur   int   // Endpoint of the attempt in progress
+}
+
+/*
+	ParseFailover parses an ActiveMQ style failover URI.
+
+	Both "failover:(uri1,uri2)?options" and "failover:uri1,uri2" forms are
+	accepted.  Supported options are: randomize, initialReconnectDelay,
+	maxReconnectDelay (both in milliseconds), and maxReconnectAttempts.  A
+	maxReconnectDelay below initialReconnectDelay is raised to it.
+
+	Example:
+		f, e := stompngo.ParseFailover(
+			"failover:(tcp://primary:61613,tcp://standby:61613)?randomize=false")
+		if e != nil {
+			// Do something sane ...
+		}
+		c, e := stompngo.ConnectFailover(h, f)
+*/
+func ParseFailover(s string) (*Failover, error) {
+	s = strings.TrimPrefix(s, "failover:")
+	q := ""
+	if strings.HasPrefix(s, "(") {
+		i := strings.Index(s, ")")
+		if i < 0 {
+			return nil, EBADENDP
+		}
+		q = strings.TrimPrefix(s[i+1:], "?")
+		s = s[1:i]
+	} else if i := strings.LastIndex(s, "?"); i >= 0 {
+		s, q = s[:i], s[i+1:]
+	}
+	f := &Failover{}
+	for _, ep := range strings.Split(s, ",") {
+		if ep = strings.TrimSpace(ep); ep != "" {
+			f.Endpoints = append(f.Endpoints, ep)
+		}
+	}
+	o, e := url.ParseQuery(q)
+	if e != nil {
+		return nil, e
+	}
+	var id, md time.Duration
+	for k := range o {
+		v := o.Get(k)
+		switch k {
+		case "randomize":
+			f.Randomize, e = strconv.ParseBool(v)
+		case "initialReconnectDelay":
+			id, e = parseMillis(v)
+		case "maxReconnectDelay":
+			md, e = parseMillis(v)
+		case "maxReconnectAttempts":
+			f.Policy.MaxAttempts, e = strconv.Atoi(v)
+		default:
+			e = Error("unknown failover option: " + k)
+		}
+		if e != nil {
+			return nil, e
+		}
+	}
+	if id > 0 || md > 0 {
+		if id <= 0 {
+			id = time.Second
+		}
+		if md < id {
+			md = id
+		}
+		f.Policy.Backoff = ExponentialBackoff(id, md)
+	}
+	return f, f.check()
+}
+
+func parseMillis(v string) (time.Duration, error) {
+	n, e := strconv.ParseInt(v, 10, 64)
+	return time.Duration(n) * time.Millisecond, e
+}
+
+/*
+	ConnectFailover creates a reconnecting Connection using a list of broker
+	endpoints.  Each endpoint is tried once for the initial connection.
+
+	The endpoint currently in use is available from the Connection's
+	Broker() method.
+*/
+func ConnectFailover(h Headers, f *Failover) (*Connection, error) {
+	if f == nil {
+		return nil, ENOENDPT
+	}
+	if e := f.check(); e != nil {
+		return nil, e
+	}
+	f.lock.Lock()
+	f.fails = make([]int, len(f.Endpoints))
+	f.last = -1
+	f.lock.Unlock()
+	return connectPolicy(h, f.Policy, f)
+}
+
+/*
+	Validate the endpoint list.
+*/
+func (f *Failover) check() error {
+	if len(f.Endpoints) == 0 {
+		return ENOENDPT
+	}
+	for _, ep := range f.Endpoints {
+		u, e := url.Parse(ep)
+		if e != nil || u.Host == "" {
+			return Error(EBADENDP.Error() + ": " + ep)
+		}
+		switch u.Scheme {
+		case "tcp", "stomp", "ssl", "tls", "stomp+ssl", "ws", "wss":
+		default:
+			return Error(EBADENDP.Error() + ": " + ep)
+		}
+	}
+	return nil
+}
+
+/*
+	Endpoint indices in the order they should be tried.
+*/
+func (f *Failover) order() []int {
+	r := make([]int, len(f.Endpoints))
+	for i := range r {
+		r[i] = i
+	}
+	if f.Randomize {
+		rand.Shuffle(len(r), func(i, j int) { r[i], r[j] = r[j], r[i] })
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		if f.fails[r[i]] != f.fails[r[j]] {
+			return f.fails[r[i]] < f.fails[r[j]]
+		}
+		return r[i] == f.last
+	})
+	return r
+}
+
+/*
+	Make one pass over the endpoints, until one accepts both a network
+	connection and the CONNECT.  That endpoint becomes the Connection's
+	transport.
+*/
+func (f *Failover) connect(c *Connection, subs []Headers) error {
+	f.lock.Lock()
+	o := f.order()
+	f.lock.Unlock()
+	var e error
+	for _, i := range o {
+		ep := f.Endpoints[i]
+		var n net.Conn
+		var w *websocket.Conn
+		n, w, e = f.dialEndpoint(ep)
+		if e != nil {
+			c.log(LogLifecycle, LevelWarn, "FAILOVER dial failed", "endpoint", ep, "error", e)
+			f.lock.Lock()
+			f.fails[i]++
+			f.lock.Unlock()
+			continue
+		}
+		c.log(LogLifecycle, LevelInfo, "FAILOVER dialed", "endpoint", ep)
+		f.lock.Lock()
+		f.cur = i
+		f.lock.Unlock()
+		c.setTransport(n, w, ep)
+		if e = c.connectTransport(subs); e == nil {
+			return nil
+		}
+	}
+	return e
+}
+
+/*
+	Dial a single endpoint.
+*/
+func (f *Failover) dialEndpoint(ep string) (net.Conn, *websocket.Conn, error) {
+	u, e := url.Parse(ep)
+	if e != nil {
+		return nil, nil, e
+	}
+	nd := &net.Dialer{Timeout: f.Timeout}
+	switch u.Scheme {
+	case "ws", "wss":
+		d := websocket.DefaultDialer
+		if f.WSDialer != nil {
+			d = f.WSDialer
+		}
+		wd := *d
+		if f.Timeout > 0 {
+			wd.HandshakeTimeout = f.Timeout
+		}
+		if f.TLSConfig != nil && wd.TLSClientConfig == nil {
+			wd.TLSClientConfig = f.TLSConfig
+		}
+		if len(wd.Subprotocols) == 0 {
+			wd.Subprotocols = WSSubprotocols
+		}
+		w, _, e := wd.Dial(ep, f.WSHeader)
+		return nil, w, e
+	case "ssl", "tls", "stomp+ssl":
+		n, e := tls.DialWithDialer(nd, NetProtoTCP, u.Host, f.TLSConfig)
+		if e != nil {
+			return nil, nil, e
+		}
+		return n, nil, nil
+	}
+	n, e := nd.Dial(NetProtoTCP, u.Host)
+	return n, nil, e
+}
+
+/*
+	Record the result of a CONNECT attempt on the current endpoint.
+*/
+func (f *Failover) report(e error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if e != nil {
+		f.fails[f.cur]++
+		return
+	}
+	f.fails[f.cur] = 0
+	f.last = f.cur
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+	Test failover URI parsing.
+*/
+func TestFailoverParse(t *testing.T) {
+	for _, fd := range failoverParseList {
+		f, e := ParseFailover(fd.uri)
+		if e != fd.exe {
+			t.Fatalf("TestFailoverParse %s expected [%v], got [%v]\n",
+				fd.uri, fd.exe, e)
+		}
+		if e != nil {
+			continue
+		}
+		if len(f.Endpoints) != fd.n || f.Randomize != fd.rnd {
+			t.Fatalf("TestFailoverParse %s bad result %v %v\n", fd.uri,
+				f.Endpoints, f.Randomize)
+		}
+	}
+	f, e := ParseFailover("failover:(tcp://a:1)?maxReconnectAttempts=3&initialReconnectDelay=10&maxReconnectDelay=40")
+	if e != nil {
+		t.Fatalf("TestFailoverParse options expected nil, got %v\n", e)
+	}
+	if f.Policy.MaxAttempts != 3 || f.Policy.Backoff(9) != 40*time.Millisecond {
+		t.Fatalf("TestFailoverParse options not applied: %v\n", f.Policy)
+	}
+	// A maximum below the initial delay is raised to it
+	f, e = ParseFailover("failover:(tcp://a:1)?initialReconnectDelay=50&maxReconnectDelay=10")
+	if e != nil {
+		t.Fatalf("TestFailoverParse options expected nil, got %v\n", e)
+	}
+	if d := f.Policy.Backoff(9); d != 50*time.Millisecond {
+		t.Fatalf("TestFailoverParse expected 50ms backoff, got %v\n", d)
+	}
+	if _, e = ParseFailover("failover:(tcp://a:1)?bogus=1"); e == nil {
+		t.Fatalf("TestFailoverParse expected unknown option error\n")
+	}
+}
+
+/*
+	Test failover skips a dead broker, and moves to another broker when the
+	current one fails.
+*/
+func TestFailoverSwitch(t *testing.T) {
+	dead := newFakeBroker(t)
+	dead.close()
+	b1 := newFakeBroker(t)
+	b2 := newFakeBroker(t)
+	defer b2.close()
+	f := &Failover{Endpoints: []string{"tcp://" + dead.addr(),
+		"tcp://" + b1.addr(), "tcp://" + b2.addr()}}
+	f.Policy.Backoff = func(n int) time.Duration { return time.Millisecond }
+	ch := Headers{HK_ACCEPT_VERSION, SPL_11, HK_HOST, "localhost"}
+	c, e := ConnectFailover(ch, f)
+	if e != nil {
+		t.Fatalf("TestFailoverSwitch CONNECT expected nil, got %v\n", e)
+	}
+	if c.Broker() != f.Endpoints[1] {
+		t.Fatalf("TestFailoverSwitch expected broker %s, got %s\n",
+			f.Endpoints[1], c.Broker())
+	}
+	s1 := b1.accept(t)
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/failover",
+		HK_ID, "fosub"})
+	if e != nil {
+		t.Fatalf("TestFailoverSwitch SUBSCRIBE expected nil, got %v\n", e)
+	}
+	_ = s1.next(t)
+	// Primary goes away
+	b1.close()
+	s1.close()
+	s2 := b2.accept(t)
+	if fr := s2.next(t); fr.Command != SUBSCRIBE {
+		t.Fatalf("TestFailoverSwitch expected SUBSCRIBE, got %s\n", fr.Command)
+	}
+	if c.Broker() != f.Endpoints[2] {
+		t.Fatalf("TestFailoverSwitch expected broker %s, got %s\n",
+			f.Endpoints[2], c.Broker())
+	}
+	s2.send(MESSAGE, Headers{HK_SUBSCRIPTION, "fosub", HK_MESSAGE_ID, "m1"},
+		"standby")
+	if md := <-sc; md.Message.BodyString() != "standby" {
+		t.Fatalf("TestFailoverSwitch expected standby, got %v\n", md)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test the initial connection dials each endpoint once.
+*/
+func TestFailoverDialOnce(t *testing.T) {
+	dials := make([]int64, 3)
+	f := &Failover{}
+	for i := range dials {
+		i := i
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(&dials[i], 1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
+		f.Endpoints = append(f.Endpoints, "ws"+strings.TrimPrefix(s.URL, "http"))
+	}
+	ch := Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"}
+	if _, e := ConnectFailover(ch, f); e == nil {
+		t.Fatalf("TestFailoverDialOnce expected error, got nil\n")
+	}
+	for i := range dials {
+		if n := atomic.LoadInt64(&dials[i]); n != 1 {
+			t.Fatalf("TestFailoverDialOnce endpoint %d expected 1 dial, got %d\n", i, n)
+		}
+	}
+}
+
+/*
+	Test endpoint ordering prefers the last good and healthy endpoints.
+*/
+func TestFailoverOrder(t *testing.T) {
+	f := &Failover{Endpoints: []string{"tcp://a:1", "tcp://b:1", "tcp://c:1"}}
+	f.fails = []int{2, 0, 0}
+	f.last = 2
+	o := f.order()
+	if o[0] != 2 || o[1] != 1 || o[2] != 0 {
+		t.Fatalf("TestFailoverOrder expected [2 1 0], got %v\n", o)
+	}
+}
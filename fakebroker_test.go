@@ -0,0 +1,226 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go/frame"
+)
+
+/*
+	A scripted, in process, STOMP broker used by tests that must not depend
+	on a real broker.  Each accepted network connection is a fakeSession.
+	CONNECT/STOMP frames are answered automatically, as are any receipt
+	requests.  All other frames are handed to the test.
+*/
+type fakeBroker struct {
+	ln         net.Listener
+	sessions   chan *fakeSession
+	lock       sync.Mutex
+	noReceipts bool // Sessions do not answer receipt requests
+}
+
+type fakeSession struct {
+	n          net.Conn
+	r          *bufio.Reader
+	dec        *frame.Decoder
+	wl         sync.Mutex
+	frames     chan Frame
+	noReceipts bool // Do not answer receipt requests
+}
+
+func newFakeSession(n net.Conn) *fakeSession {
+	s := &fakeSession{n: n, r: bufio.NewReader(n), frames: make(chan Frame, 64)}
+	s.dec = frame.NewDecoder(s.r)
+	s.dec.Version = frame.V12 // As the client, see reader.go
+	return s
+}
+
+/*
+	Test helper.  A client connection over net.Pipe.  Nothing is read from the
+	pipe until the test calls handshake or run, so client writes block.
+*/
+func fakePipe() (net.Conn, *fakeSession) {
+	cn, sn := net.Pipe()
+	return cn, newFakeSession(sn)
+}
+
+/*
+	Test helper.  Start a fake broker on a local port.
+*/
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, e := net.Listen(NetProtoTCP, "127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("newFakeBroker listen error: %v\n", e)
+	}
+	b := &fakeBroker{ln: ln, sessions: make(chan *fakeSession, 8)}
+	go func() {
+		for {
+			n, e := ln.Accept()
+			if e != nil {
+				close(b.sessions)
+				return
+			}
+			s := newFakeSession(n)
+			b.lock.Lock()
+			s.noReceipts = b.noReceipts
+			b.lock.Unlock()
+			go s.run()
+			b.sessions <- s
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return b.ln.Addr().String()
+}
+
+func (b *fakeBroker) dial() (net.Conn, error) {
+	return net.Dial(NetProtoTCP, b.addr())
+}
+
+/*
+	Test helper.  New sessions will not answer receipt requests.
+*/
+func (b *fakeBroker) withoutReceipts() {
+	b.lock.Lock()
+	b.noReceipts = true
+	b.lock.Unlock()
+}
+
+func (b *fakeBroker) close() {
+	_ = b.ln.Close()
+}
+
+/*
+	Test helper.  Wait for the next client session.
+*/
+func (b *fakeBroker) accept(t *testing.T) *fakeSession {
+	select {
+	case s := <-b.sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fakeBroker accept timeout\n")
+	}
+	return nil
+}
+
+/*
+	Session read loop.
+*/
+func (s *fakeSession) run() {
+	defer close(s.frames)
+	for {
+		f, e := s.readFrame()
+		if e != nil {
+			return
+		}
+		switch f.Command {
+		case CONNECT, STOMP:
+			s.connected(f)
+			continue
+		}
+		if r, ok := f.Headers.Contains(HK_RECEIPT); ok && !s.noReceipts {
+			s.send(RECEIPT, Headers{HK_RECEIPT_ID, r}, "")
+		}
+		s.frames <- f
+	}
+}
+
+/*
+	Test helper.  Read and answer the CONNECT frame only.
+*/
+func (s *fakeSession) handshake() error {
+	f, e := s.readFrame()
+	if e != nil {
+		return e
+	}
+	s.connected(f)
+	return nil
+}
+
+/*
+	Answer a CONNECT frame.
+*/
+func (s *fakeSession) connected(f Frame) {
+	h := Headers{HK_SESSION, Uuid()}
+	if v := f.Headers.Value(HK_ACCEPT_VERSION); v != "" {
+		p := strings.Split(v, ",")
+		h = h.Add(HK_VERSION, p[len(p)-1])
+	}
+	s.send(CONNECTED, h, "")
+}
+
+/*
+	Read the next frame.  Heartbeat EOLs are skipped.
+*/
+func (s *fakeSession) readFrame() (Frame, error) {
+	for {
+		f, e := s.dec.Decode()
+		if e != nil || !f.IsHeartBeat() {
+			return Frame{f.Command, Headers(f.Headers), f.Body}, e
+		}
+	}
+}
+
+/*
+	Test helper.  Send a frame to the client.
+*/
+func (s *fakeSession) send(cmd string, h Headers, b string) {
+	var w bytes.Buffer
+	en := frame.NewEncoder(&w)
+	en.Version = frame.V12
+	_ = en.Encode(&frame.Frame{Command: cmd, Headers: h, Body: []byte(b)})
+	s.sendRaw(w.String())
+}
+
+/*
+	Test helper.  Send raw wire data to the client.
+*/
+func (s *fakeSession) sendRaw(w string) {
+	s.wl.Lock()
+	defer s.wl.Unlock()
+	_, _ = s.n.Write([]byte(w))
+}
+
+/*
+	Test helper.  Get the next frame sent by the client.
+*/
+func (s *fakeSession) next(t *testing.T) Frame {
+	select {
+	case f, ok := <-s.frames:
+		if !ok {
+			t.Fatalf("fakeSession closed\n")
+		}
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fakeSession next frame timeout\n")
+	}
+	return Frame{}
+}
+
+func (s *fakeSession) close() {
+	_ = s.n.Close()
+}
@@ -0,0 +1,161 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+/*
+	Subscription flow control.
+
+	The SUBSCRIBE extension header StompPlusMaxInFlight sets the capacity of
+	the subscription MessageData channel, in place of SubChanCap.  The same
+	value is sent to the broker as a prefetch limit, using the
+	activemq.prefetchSize (ActiveMQ, Artemis) and prefetch-count (RabbitMQ)
+	headers, unless the caller supplies them.
+
+	The SUBSCRIBE extension header StompPlusOverflow selects what the reader
+	does when the subscription channel is full:
+
+		block		Wait for the client (the default).  A slow client
+				stalls every subscription on the connection, and heart
+				beat reads.
+		drop-oldest	Discard the oldest queued message.
+		drop-newest	Discard the arriving message.
+		error		Like drop-oldest, but the arriving message is delivered
+				with Error ESUBOVFL, to report the loss.
+
+	Dropped messages are not acknowledged, so in the client ack modes the
+	broker redelivers them after the subscription ends.
+
+	Example:
+		h := stompngo.Headers{stompngo.HK_DESTINATION, "/queue/myqueue",
+			stompngo.HK_ACK, stompngo.AckModeClientIndividual,
+			stompngo.StompPlusMaxInFlight, "100",
+			stompngo.StompPlusOverflow, stompngo.OverflowDropNewest}
+		s, e := c.Subscribe(h)
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+const (
+	OverflowBlock      = "block"
+	OverflowDropOldest = "drop-oldest"
+	OverflowDropNewest = "drop-newest"
+	OverflowError      = "error"
+)
+
+var validOverflows = map[string]bool{OverflowBlock: true,
+	OverflowDropOldest: true, OverflowDropNewest: true, OverflowError: true}
+
+/*
+	Check the flow control extension headers.
+*/
+func checkFlowHeaders(h Headers) error {
+	if v, ok := h.Contains(StompPlusMaxInFlight); ok {
+		if n, e := strconv.Atoi(v); e != nil || n < 1 {
+			return ESBADMIF
+		}
+	}
+	if v, ok := h.Contains(StompPlusOverflow); ok && !validOverflows[v] {
+		return ESBADOVF
+	}
+	return nil
+}
+
+/*
+	Apply the flow control extension headers to a new subscription.  The
+	returned Headers include any broker prefetch headers.  Called before the
+	subscription is visible to the reader.
+*/
+func (c *Connection) setFlow(sd *subscription, h Headers) Headers {
+	sd.ovf = h.Value(StompPlusOverflow)
+	if sd.ovf == "" {
+		sd.ovf = OverflowBlock
+	}
+	if v, ok := h.Contains(StompPlusMaxInFlight); ok {
+		mc, _ := strconv.Atoi(v) // Checked by checkFlowHeaders
+		sd.md = make(chan MessageData, mc)
+		for _, k := range []string{HK_AMQ_PREFETCH, HK_PREFETCH_COUNT} {
+			if _, ok := h.Contains(k); !ok {
+				h = h.Add(k, v)
+			}
+		}
+	}
+	return h
+}
+
+/*
+	Put a MESSAGE on a subscription channel, following the subscription
+	overflow policy.  Called by the reader.
+*/
+func (c *Connection) deliver(ps *subscription, md MessageData) {
+	if ps.ovf == OverflowBlock || ps.ovf == "" {
+		ps.md <- md
+		atomic.AddInt64(&ps.dlv, 1)
+		return
+	}
+	for {
+		select {
+		case ps.md <- md:
+			atomic.AddInt64(&ps.dlv, 1)
+			return
+		default:
+		}
+		if ps.ovf == OverflowDropNewest {
+			c.dropped(ps, md)
+			return
+		}
+		select {
+		case om := <-ps.md:
+			c.dropped(ps, om)
+			if ps.ovf == OverflowError {
+				md.Error = ESUBOVFL
+			}
+		default: // The client made room
+		}
+	}
+}
+
+/*
+	Put a connection error on a subscription channel without blocking.  A
+	full channel loses its oldest message to make room, whatever the
+	overflow policy.  Called with subsLock held.
+*/
+func (c *Connection) deliverError(ps *subscription, md MessageData) {
+	for i := 0; i < 2; i++ {
+		select {
+		case ps.md <- md:
+			return
+		default:
+		}
+		select {
+		case om := <-ps.md:
+			c.dropped(ps, om)
+		default: // Unbuffered, or the client made room
+		}
+	}
+	c.log(LogLifecycle, LevelWarn, "RDR_ERROR_DROP", "subscription", ps.id, "error", md.Error)
+}
+
+func (c *Connection) dropped(ps *subscription, md MessageData) {
+	atomic.AddInt64(&ps.drops, 1)
+	c.log(LogLifecycle, LevelWarn, "RDR_OVERFLOW_DROP", "subscription", ps.id, "overflow", ps.ovf,
+		"headers", md.Message.Headers)
+}
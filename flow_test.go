@@ -0,0 +1,104 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+	Test the overflow policies.
+*/
+func TestFlowOverflow(t *testing.T) {
+	for _, d := range []struct {
+		ovf   string
+		bodys string // Expected channel contents
+		err   bool   // Last message has ESUBOVFL
+		dlv   int64  // Delivered, never decreases
+	}{
+		{OverflowDropNewest, "01", false, 2},
+		{OverflowDropOldest, "23", false, 4},
+		{OverflowError, "23", true, 4},
+	} {
+		c := newConnection()
+		sd := &subscription{id: "s1"}
+		h := c.setFlow(sd, Headers{HK_DESTINATION, "/queue/q",
+			StompPlusMaxInFlight, "2", StompPlusOverflow, d.ovf})
+		if h.Value(HK_AMQ_PREFETCH) != "2" || h.Value(HK_PREFETCH_COUNT) != "2" {
+			t.Fatalf("TestFlowOverflow expected prefetch headers, got %v\n", h)
+		}
+		for i := 0; i < 4; i++ {
+			m := Message{MESSAGE, Headers{}, []byte(strconv.Itoa(i))}
+			c.deliver(sd, MessageData{m, nil}) // Never blocks
+		}
+		if sd.drops != 2 || sd.dlv != d.dlv {
+			t.Fatalf("TestFlowOverflow %s expected 2 drops %d delivered, got %d %d\n",
+				d.ovf, d.dlv, sd.drops, sd.dlv)
+		}
+		got := ""
+		var md MessageData
+		for len(sd.md) > 0 {
+			md = <-sd.md
+			got += string(md.Message.Body)
+		}
+		if got != d.bodys || (md.Error == ESUBOVFL) != d.err {
+			t.Fatalf("TestFlowOverflow %s expected %s %v, got %s %v\n",
+				d.ovf, d.bodys, d.err, got, md.Error)
+		}
+	}
+}
+
+/*
+	Test a full subscription does not stall other subscriptions.
+*/
+func TestFlowSlowSubscriber(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	for _, h := range []Headers{
+		{HK_DESTINATION, "/queue/slow", StompPlusMaxInFlight, "x"},
+		{HK_DESTINATION, "/queue/slow", StompPlusOverflow, "discard"},
+	} {
+		if _, e := c.Subscribe(h); e == nil {
+			t.Fatalf("TestFlowSlowSubscriber %v expected error, got nil\n", h)
+		}
+	}
+	_, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/slow", HK_ID, "slow",
+		StompPlusMaxInFlight, "1", StompPlusOverflow, OverflowDropNewest})
+	if e != nil {
+		t.Fatalf("TestFlowSlowSubscriber expected nil, got %v\n", e)
+	}
+	fast, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/fast", HK_ID, "fast"})
+	if e != nil {
+		t.Fatalf("TestFlowSlowSubscriber expected nil, got %v\n", e)
+	}
+	for i := 0; i < 5; i++ {
+		_ = c.Send(Headers{HK_DESTINATION, "/queue/slow"}, "never read")
+	}
+	_ = c.Send(Headers{HK_DESTINATION, "/queue/fast"}, "fast")
+	select {
+	case md := <-fast:
+		if md.Message.BodyString() != "fast" {
+			t.Fatalf("TestFlowSlowSubscriber bad MESSAGE %v\n", md.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestFlowSlowSubscriber reader stalled\n")
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
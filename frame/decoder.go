@@ -0,0 +1,182 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package frame
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+	Decoder reads frames from a byte stream.
+*/
+type Decoder struct {
+	Version string // Header unescaping, see the package comment
+	Limits  Limits // Zero value means no limits
+	r       *bufio.Reader
+}
+
+/*
+	NewDecoder returns a Decoder reading from r.  A *bufio.Reader is used
+	directly, so it may be shared with other readers of the stream.
+*/
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br}
+}
+
+/*
+	Decode reads the next frame, or heart beat.  On error the returned Frame
+	holds whatever was read.  io.EOF is returned only at a frame boundary.
+*/
+func (d *Decoder) Decode() (*Frame, error) {
+	f := &Frame{Headers: []string{}, Body: []byte{}}
+	s, e := d.line()
+	if e != nil {
+		if e == io.EOF && s != "" {
+			e = io.ErrUnexpectedEOF
+		}
+		return f, e
+	}
+	if s == "" {
+		return f, nil // Heart beat
+	}
+	f.Command = s
+	ue := escaped(d.Version, f.Command)
+	hb := 0 // Header bytes
+	for {
+		s, e = d.line()
+		if e != nil {
+			return f, unexpected(e)
+		}
+		if s == "" {
+			break
+		}
+		hb += len(s)
+		if d.Limits.MaxHeaderBytes > 0 && hb > d.Limits.MaxHeaderBytes {
+			return f, EHDRLEN
+		}
+		if d.Limits.MaxHeaders > 0 && len(f.Headers)/2 >= d.Limits.MaxHeaders {
+			return f, EHDRCNT
+		}
+		i := strings.Index(s, ":")
+		if i < 0 {
+			return f, EBADHDR
+		}
+		k, v := s[:i], s[i+1:]
+		if ue {
+			k, v = Unescape(k, d.Version), Unescape(v, d.Version)
+		}
+		f.Headers = append(f.Headers, k, v)
+	}
+	if v, ok := f.Header("content-length"); ok {
+		l, e := strconv.Atoi(strings.TrimSpace(v))
+		if e != nil || l < 0 {
+			return f, EBADCLEN
+		}
+		if d.Limits.MaxBodySize > 0 && l > d.Limits.MaxBodySize {
+			return f, EBODYLEN
+		}
+		f.Body = make([]byte, l)
+		n, e := io.ReadFull(d.r, f.Body)
+		if e != nil {
+			f.Body = f.Body[:n]
+			return f, unexpected(e)
+		}
+		b, e := d.r.ReadByte()
+		if e != nil {
+			return f, unexpected(e)
+		}
+		if b != 0 {
+			return f, ENONUL
+		}
+		return f, nil
+	}
+	max := d.Limits.MaxBodySize
+	if max > 0 {
+		max++ // NUL
+	}
+	b, e := ReadUntil(d.r, 0, max)
+	if e == ETOOLONG {
+		return f, EBODYLEN
+	}
+	if e != nil {
+		f.Body = b
+		return f, unexpected(e)
+	}
+	f.Body = b[:len(b)-1]
+	return f, nil
+}
+
+/*
+	Read one line, without the LF or CRLF.
+*/
+func (d *Decoder) line() (string, error) {
+	max := d.Limits.MaxLineLength
+	if max > 0 {
+		max += 2 // CRLF
+	}
+	b, e := ReadUntil(d.r, '\n', max)
+	if e == ETOOLONG {
+		return "", ELINELEN
+	}
+	if e != nil {
+		return string(b), e
+	}
+	s := string(b[:len(b)-1])
+	if strings.HasSuffix(s, "\r") {
+		s = s[:len(s)-1]
+	}
+	if d.Limits.MaxLineLength > 0 && len(s) > d.Limits.MaxLineLength {
+		return "", ELINELEN
+	}
+	return s, nil
+}
+
+/*
+	ReadUntil reads up to and including delim, like bufio.Reader.ReadBytes,
+	but fails with ETOOLONG as soon as more than max bytes would be needed.
+	A max of 0 means no limit.
+*/
+func ReadUntil(r *bufio.Reader, delim byte, max int) ([]byte, error) {
+	var b []byte
+	for {
+		s, e := r.ReadSlice(delim)
+		if max > 0 && len(b)+len(s) > max {
+			return nil, ETOOLONG
+		}
+		b = append(b, s...)
+		if e != bufio.ErrBufferFull {
+			return b, e
+		}
+	}
+}
+
+/*
+	EOF inside a frame is unexpected.
+*/
+func unexpected(e error) error {
+	if e == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return e
+}
@@ -0,0 +1,89 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package frame
+
+import (
+	"io"
+)
+
+/*
+	Encoder writes frames to a byte stream.
+*/
+type Encoder struct {
+	Version string // Header escaping, see the package comment
+	CRLF    bool   // Write CRLF line ends, STOMP 1.2 only
+	w       io.Writer
+}
+
+/*
+	NewEncoder returns an Encoder writing to w.  Each frame is written with
+	several calls to w, so w should be buffered.  Encode does not flush w.
+*/
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+/*
+	Encode writes one frame.  A Frame with an empty Command is written as a
+	heart beat EOL.
+*/
+func (en *Encoder) Encode(f *Frame) error {
+	eol := "\n"
+	if en.CRLF {
+		eol = "\r\n"
+	}
+	if f.IsHeartBeat() {
+		return en.write([]byte(eol))
+	}
+	b := make([]byte, 0, len(f.Command)+2+headersSize(f.Headers)+2)
+	b = append(b, f.Command...)
+	b = append(b, eol...)
+	es := escaped(en.Version, f.Command)
+	for i := 0; i+1 < len(f.Headers); i += 2 {
+		k, v := f.Headers[i], f.Headers[i+1]
+		if es {
+			k, v = Escape(k, en.Version), Escape(v, en.Version)
+		}
+		b = append(b, k...)
+		b = append(b, ':')
+		b = append(b, v...)
+		b = append(b, eol...)
+	}
+	b = append(b, eol...)
+	if e := en.write(b); e != nil {
+		return e
+	}
+	if len(f.Body) != 0 {
+		if e := en.write(f.Body); e != nil {
+			return e
+		}
+	}
+	return en.write([]byte{0})
+}
+
+func (en *Encoder) write(b []byte) error {
+	_, e := en.w.Write(b)
+	return e
+}
+
+func headersSize(h []string) int {
+	n := 0
+	for _, s := range h {
+		n += len(s) + 2
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+/*
+	Package frame reads and writes STOMP frames on a byte stream.
+
+	It is the wire format layer of the stompngo client, and is usable on its
+	own by tools, proxies and tests.
+
+	A Decoder reads frames, using content-length when present and the NUL
+	terminator otherwise.  Line ends may be LF or CRLF.  Each EOL received
+	between frames is returned as a heart beat, a Frame with an empty
+	Command.
+
+	Decoder Limits protect against oversized frames from a misbehaving
+	peer.  A violation is reported before the oversized data is buffered.
+	The stream is then not at a frame boundary, and should be closed.
+
+	An Encoder writes frames exactly as given, with LF line ends unless CRLF
+	is set.  It does not add content-length or content-type headers.
+
+	Header escaping follows the Version of the Decoder or Encoder: none for
+	STOMP 1.0, and the 1.1 or 1.2 escape sequences otherwise.  CONNECT,
+	STOMP and CONNECTED frames are never escaped.
+
+	Example:
+		d := frame.NewDecoder(conn)
+		d.Version = frame.V12
+		for {
+			f, e := d.Decode()
+			if e != nil {
+				// Do something sane ...
+			}
+			if f.IsHeartBeat() {
+				continue
+			}
+			fmt.Println(f.Command, f.Headers, len(f.Body))
+		}
+
+*/
+package frame
+
+import (
+	"strings"
+)
+
+/*
+	STOMP protocol versions.
+*/
+const (
+	V10 = "1.0"
+	V11 = "1.1"
+	V12 = "1.2"
+)
+
+/*
+	Frame is one STOMP frame.  Headers are key / value pairs in wire order,
+	duplicates included.
+*/
+type Frame struct {
+	Command string
+	Headers []string
+	Body    []byte
+}
+
+/*
+	Header returns the first value for a header key.
+*/
+func (f *Frame) Header(k string) (string, bool) {
+	for i := 0; i < len(f.Headers); i += 2 {
+		if f.Headers[i] == k {
+			return f.Headers[i+1], true
+		}
+	}
+	return "", false
+}
+
+/*
+	IsHeartBeat reports whether f is a heart beat EOL.
+*/
+func (f *Frame) IsHeartBeat() bool {
+	return f.Command == ""
+}
+
+/*
+	Error definition.
+*/
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+/*
+	Error constants.
+*/
+const (
+	EBADHDR  = Error("frame: header line has no ':'")
+	EBADCLEN = Error("frame: invalid content-length")
+	ENONUL   = Error("frame: body not terminated by NUL")
+
+	// Limits
+	ELINELEN = Error("frame: line too long")
+	EHDRCNT  = Error("frame: too many headers")
+	EHDRLEN  = Error("frame: headers too large")
+	EBODYLEN = Error("frame: body too large")
+	ETOOLONG = Error("frame: delimiter not found within limit")
+)
+
+/*
+	Limits bounds the frames a Decoder accepts.  A zero field means no limit.
+*/
+type Limits struct {
+	MaxLineLength  int // Command or header line, without the line end
+	MaxHeaders     int // Header count
+	MaxHeaderBytes int // All header lines, without line ends
+	MaxBodySize    int // Body bytes
+}
+
+/*
+	Whether headers are escaped, for a version and command.
+*/
+func escaped(v, cmd string) bool {
+	switch cmd {
+	case "CONNECT", "STOMP", "CONNECTED":
+		return false
+	}
+	return v == V11 || v == V12
+}
+
+/*
+	Escape encodes a header key or value for version v.
+*/
+func Escape(s, v string) string {
+	if v != V11 && v != V12 {
+		return s
+	}
+	if !strings.ContainsAny(s, "\\\n\r:") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			b.WriteString("\\\\")
+		case '\n':
+			b.WriteString("\\n")
+		case ':':
+			b.WriteString("\\c")
+		case '\r':
+			if v == V12 {
+				b.WriteString("\\r")
+			} else {
+				b.WriteByte('\r')
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+/*
+	Unescape decodes a header key or value for version v.  Undefined escape
+	sequences are left as is.
+*/
+func Unescape(s, v string) string {
+	if v != V11 && v != V12 {
+		return s
+	}
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch s[i+1] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'c':
+			b.WriteByte(':')
+		case 'r':
+			if v != V12 {
+				b.WriteString("\\r")
+				break
+			}
+			b.WriteByte('\r')
+		default:
+			b.WriteString(s[i : i+2])
+		}
+		i++
+	}
+	return b.String()
+}
@@ -0,0 +1,212 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package frame
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+	Test decoding a stream of frames and heart beats.
+*/
+func TestDecoderStream(t *testing.T) {
+	in := "\n\r\n" +
+		"MESSAGE\ncontent-length:5\nk:v\n\nab\x00cd\x00" +
+		"RECEIPT\r\nreceipt-id:1\r\n\r\n\x00" +
+		"\n" +
+		"MESSAGE\nk:a\\cb\n\nno length\x00"
+	d := NewDecoder(strings.NewReader(in))
+	d.Version = V12
+	want := []Frame{
+		{"", []string{}, []byte{}},
+		{"", []string{}, []byte{}},
+		{"MESSAGE", []string{"content-length", "5", "k", "v"}, []byte("ab\x00cd")},
+		{"RECEIPT", []string{"receipt-id", "1"}, []byte{}},
+		{"", []string{}, []byte{}},
+		{"MESSAGE", []string{"k", "a:b"}, []byte("no length")},
+	}
+	for i, w := range want {
+		f, e := d.Decode()
+		if e != nil {
+			t.Fatalf("TestDecoderStream %d expected nil, got %v\n", i, e)
+		}
+		if !reflect.DeepEqual(*f, w) {
+			t.Fatalf("TestDecoderStream %d expected %q, got %q\n", i, w, *f)
+		}
+	}
+	if _, e := d.Decode(); e != io.EOF {
+		t.Fatalf("TestDecoderStream expected [%v], got [%v]\n", io.EOF, e)
+	}
+}
+
+/*
+	Test decoding errors.
+*/
+func TestDecoderErrors(t *testing.T) {
+	for _, d := range []struct {
+		in  string
+		err error
+	}{
+		{"MESSAGE\nnocolon\n\n\x00", EBADHDR},
+		{"MESSAGE\ncontent-length:x\n\n\x00", EBADCLEN},
+		{"MESSAGE\ncontent-length:-1\n\n\x00", EBADCLEN},
+		{"MESSAGE\ncontent-length:1\n\nab\x00", ENONUL},
+		{"MESSAGE\ncontent-length:9\n\nab", io.ErrUnexpectedEOF},
+		{"MESSAGE\nk:v\n", io.ErrUnexpectedEOF},
+		{"MESSAGE\n\nno NUL", io.ErrUnexpectedEOF},
+		{"MESS", io.ErrUnexpectedEOF},
+	} {
+		f, e := NewDecoder(strings.NewReader(d.in)).Decode()
+		if e != d.err {
+			t.Fatalf("TestDecoderErrors %q expected [%v], got [%v]\n", d.in, d.err, e)
+		}
+		if f == nil {
+			t.Fatalf("TestDecoderErrors %q expected a Frame, got nil\n", d.in)
+		}
+	}
+}
+
+/*
+	Test header escaping for each version.
+*/
+func TestEscaping(t *testing.T) {
+	raw := "a\\b\nc:d\re"
+	for _, d := range []struct {
+		v, enc string
+	}{
+		{V10, raw},
+		{V11, "a\\\\b\\nc\\cd\re"},
+		{V12, "a\\\\b\\nc\\cd\\re"},
+	} {
+		if got := Escape(raw, d.v); got != d.enc {
+			t.Fatalf("TestEscaping %s expected %q, got %q\n", d.v, d.enc, got)
+		}
+		if got := Unescape(d.enc, d.v); got != raw {
+			t.Fatalf("TestEscaping %s expected %q, got %q\n", d.v, raw, got)
+		}
+	}
+	// Undefined sequences, and a trailing backslash, are left as is
+	if got := Unescape("\\t\\r\\", V11); got != "\\t\\r\\" {
+		t.Fatalf("TestEscaping expected %q, got %q\n", "\\t\\r\\", got)
+	}
+	if got := Unescape("\\\\n", V12); got != "\\n" {
+		t.Fatalf("TestEscaping expected %q, got %q\n", "\\n", got)
+	}
+}
+
+/*
+	Test an Encoder / Decoder round trip.
+*/
+func TestEncoderRoundTrip(t *testing.T) {
+	frames := []*Frame{
+		{"SEND", []string{"destination", "/queue/a:b", "x\ry", "1"}, []byte("body")},
+		{"", []string{}, []byte{}},
+		{"CONNECT", []string{"login", "a:b"}, []byte{}},
+		{"SEND", []string{"content-length", "3"}, []byte{1, 0, 2}},
+	}
+	for _, v := range []string{V10, V11, V12} {
+		var b bytes.Buffer
+		en := NewEncoder(&b)
+		en.Version = v
+		for _, f := range frames {
+			if e := en.Encode(f); e != nil {
+				t.Fatalf("TestEncoderRoundTrip %s expected nil, got %v\n", v, e)
+			}
+		}
+		if v == V10 && !strings.HasPrefix(b.String(), "SEND\ndestination:/queue/a:b\n") {
+			t.Fatalf("TestEncoderRoundTrip expected no escaping, got %q\n", b.String())
+		}
+		if v != V10 && !strings.Contains(b.String(), "\nlogin:a:b\n") {
+			t.Fatalf("TestEncoderRoundTrip expected CONNECT not escaped, got %q\n", b.String())
+		}
+		d := NewDecoder(&b)
+		d.Version = v
+		for i, w := range frames {
+			f, e := d.Decode()
+			if e != nil || !reflect.DeepEqual(f, w) {
+				t.Fatalf("TestEncoderRoundTrip %s %d expected %q nil, got %q %v\n",
+					v, i, *w, *f, e)
+			}
+		}
+	}
+}
+
+/*
+	Test CRLF line ends.
+*/
+func TestEncoderCRLF(t *testing.T) {
+	var b bytes.Buffer
+	en := NewEncoder(&b)
+	en.Version = V12
+	en.CRLF = true
+	f := &Frame{"SEND", []string{"destination", "/queue/a\r"}, []byte("x\r\n")}
+	for _, w := range []*Frame{f, {"", []string{}, []byte{}}} {
+		if e := en.Encode(w); e != nil {
+			t.Fatalf("TestEncoderCRLF expected nil, got %v\n", e)
+		}
+	}
+	want := "SEND\r\ndestination:/queue/a\\r\r\n\r\nx\r\n\x00\r\n"
+	if b.String() != want {
+		t.Fatalf("TestEncoderCRLF expected %q, got %q\n", want, b.String())
+	}
+	d := NewDecoder(&b)
+	d.Version = V12
+	g, e := d.Decode()
+	if e != nil || !reflect.DeepEqual(g, f) {
+		t.Fatalf("TestEncoderCRLF expected %q nil, got %q %v\n", *f, *g, e)
+	}
+	if g, e = d.Decode(); e != nil || !g.IsHeartBeat() {
+		t.Fatalf("TestEncoderCRLF expected heart beat, got %q %v\n", *g, e)
+	}
+}
+
+/*
+	Test each Decoder limit.
+*/
+func TestDecoderLimits(t *testing.T) {
+	l := Limits{MaxLineLength: 16, MaxHeaders: 2, MaxHeaderBytes: 20, MaxBodySize: 8}
+	long := strings.Repeat("x", 5000) // Longer than the bufio buffer
+	for _, d := range []struct {
+		in  string
+		err error
+	}{
+		{"MESSAGE\r\nk:0123456789abcd\r\n\r\n12345678\x00", nil},
+		{"MESSAGE\nk:0123456789abcdef\n\n\x00", ELINELEN},
+		{"MESSAGE" + long + "\n\n\x00", ELINELEN},
+		{"MESSAGE\na:1\nb:2\nc:3\n\n\x00", EHDRCNT},
+		{"MESSAGE\na:123456789\nb:123456789\n\n\x00", EHDRLEN},
+		{"MESSAGE\ncontent-length:9\n\n123456789\x00", EBODYLEN},
+		{"MESSAGE\n\n123456789\x00", EBODYLEN},
+		{"MESSAGE\n\n" + long + "\x00", EBODYLEN},
+	} {
+		dc := NewDecoder(strings.NewReader(d.in))
+		dc.Limits = l
+		if _, e := dc.Decode(); e != d.err {
+			t.Fatalf("TestDecoderLimits %.40q expected [%v], got [%v]\n", d.in, d.err, e)
+		}
+	}
+	// No limits
+	in := "MESSAGE\nk:" + long + "\n\n" + long + "\x00"
+	f, e := NewDecoder(strings.NewReader(in)).Decode()
+	if e != nil || len(f.Body) != len(long) {
+		t.Fatalf("TestDecoderLimits expected nil, got %v\n", e)
+	}
+}
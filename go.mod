@@ -2,4 +2,15 @@ module github.com/drawdy/stomp-ws-go
 
 go 1.13
 
-require github.com/gorilla/websocket v1.4.1
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/gorilla/websocket v1.4.1
+	github.com/klauspost/compress v1.11.13
+	github.com/prometheus/client_golang v1.12.2
+	github.com/sirupsen/logrus v1.8.1
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.uber.org/zap v1.21.0
+	google.golang.org/protobuf v1.31.0
+)
@@ -24,6 +24,32 @@ import (
 	"time"
 )
 
+/*
+	HB Test: negotiation, from both ends.
+*/
+func TestHBNegotiate(t *testing.T) {
+	for _, d := range []struct {
+		x, y, px, py int64
+		s, r         int64
+	}{
+		{0, 0, 100, 100, 0, 0},
+		{100, 0, 0, 200, 200, 0},
+		{0, 100, 200, 0, 0, 200},
+		{300, 100, 200, 50, 300, 200},
+		{100, 100, 0, 0, 0, 0},
+	} {
+		s, r := negotiateHeartBeats(d.x, d.y, d.px, d.py)
+		if s != d.s || r != d.r {
+			t.Fatalf("TestHBNegotiate %v expected %d,%d got %d,%d\n", d, d.s, d.r, s, r)
+		}
+		// The peer sees the same intervals, reversed
+		ps, pr := negotiateHeartBeats(d.px, d.py, d.x, d.y)
+		if ps != r || pr != s {
+			t.Fatalf("TestHBNegotiate %v peer expected %d,%d got %d,%d\n", d, r, s, ps, pr)
+		}
+	}
+}
+
 /*
 	HB Test: None.
 */
@@ -219,15 +245,15 @@ func TestHBNoSend(t *testing.T) {
 			conn.SetLogger(l)
 		}
 		//
-		conn.log("TestHBNoSend start sleep")
-		conn.log("TestHBNoSend connect response",
-			conn.ConnectResponse.Command,
-			conn.ConnectResponse.Headers,
-			string(conn.ConnectResponse.Body))
-		conn.log(1, "Send", conn.SendTickerInterval(), "Receive",
-			conn.ReceiveTickerInterval())
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoSend start sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoSend connect response",
+			"command", conn.ConnectResponse.Command,
+			"headers", conn.ConnectResponse.Headers,
+			"body", string(conn.ConnectResponse.Body))
+		conn.log(LogHeartBeat, LevelDebug, "ticker intervals", "test", 1,
+			"send", conn.SendTickerInterval(), "receive", conn.ReceiveTickerInterval())
 		time.Sleep(hbs * time.Second)
-		conn.log("TestHBNoSend end sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoSend end sleep")
 		//
 		conn.hbd.rdl.Lock()
 		if conn.Hbrf {
@@ -274,15 +300,15 @@ func TestHBNoReceive(t *testing.T) {
 			conn.SetLogger(l)
 		}
 		//
-		conn.log("TestHBNoReceive start sleep")
-		conn.log("TestHBNoReceive connect response",
-			conn.ConnectResponse.Command,
-			conn.ConnectResponse.Headers,
-			string(conn.ConnectResponse.Body))
-		conn.log(2, "Send", conn.SendTickerInterval(), "Receive",
-			conn.ReceiveTickerInterval())
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoReceive start sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoReceive connect response",
+			"command", conn.ConnectResponse.Command,
+			"headers", conn.ConnectResponse.Headers,
+			"body", string(conn.ConnectResponse.Body))
+		conn.log(LogHeartBeat, LevelDebug, "ticker intervals", "test", 2,
+			"send", conn.SendTickerInterval(), "receive", conn.ReceiveTickerInterval())
 		time.Sleep(hbs * time.Second)
-		conn.log("TestHBNoReceive end sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBNoReceive end sleep")
 		//
 		checkHBSend(t, conn, 2)
 		checkReceived(t, conn, false)
@@ -326,15 +352,15 @@ func TestHBSendReceive(t *testing.T) {
 			conn.SetLogger(l)
 		}
 		//
-		conn.log("TestHBSendReceive start sleep")
-		conn.log("TestHBSendReceive connect response",
-			conn.ConnectResponse.Command,
-			conn.ConnectResponse.Headers,
-			string(conn.ConnectResponse.Body))
-		conn.log(3, "Send", conn.SendTickerInterval(), "Receive",
-			conn.ReceiveTickerInterval())
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceive start sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceive connect response",
+			"command", conn.ConnectResponse.Command,
+			"headers", conn.ConnectResponse.Headers,
+			"body", string(conn.ConnectResponse.Body))
+		conn.log(LogHeartBeat, LevelDebug, "ticker intervals", "test", 3,
+			"send", conn.SendTickerInterval(), "receive", conn.ReceiveTickerInterval())
 		time.Sleep(hbs * time.Second)
-		conn.log("TestHBSendReceive end sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceive end sleep")
 		conn.hbd.rdl.Lock()
 		if conn.Hbrf {
 			t.Fatalf("TestHBSendReceive Error, dirty heart beat read detected")
@@ -385,15 +411,15 @@ func TestHBSendReceiveApollo(t *testing.T) {
 			conn.SetLogger(l)
 		}
 		//
-		conn.log("TestHBSendReceiveApollo start sleep")
-		conn.log("TestHBSendReceiveApollo connect response",
-			conn.ConnectResponse.Command,
-			conn.ConnectResponse.Headers,
-			string(conn.ConnectResponse.Body))
-		conn.log(4, "Send", conn.SendTickerInterval(), "Receive",
-			conn.ReceiveTickerInterval())
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveApollo start sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveApollo connect response",
+			"command", conn.ConnectResponse.Command,
+			"headers", conn.ConnectResponse.Headers,
+			"body", string(conn.ConnectResponse.Body))
+		conn.log(LogHeartBeat, LevelDebug, "ticker intervals", "test", 4,
+			"send", conn.SendTickerInterval(), "receive", conn.ReceiveTickerInterval())
 		time.Sleep(hbs * time.Second)
-		conn.log("TestHBSendReceiveApollo end sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveApollo end sleep")
 		conn.hbd.rdl.Lock()
 		if conn.Hbrf {
 			t.Fatalf("TestHBSendReceiveApollo Error, dirty heart beat read detected")
@@ -448,16 +474,16 @@ func TestHBSendReceiveRevApollo(t *testing.T) {
 			conn.SetLogger(l)
 		}
 		//
-		conn.log("TestHBSendReceiveRevApollo start sleep")
-		conn.log("TestHBSendReceiveRevApollo connect response",
-			conn.ConnectResponse.Command,
-			conn.ConnectResponse.Headers,
-			string(conn.ConnectResponse.Body))
-		conn.log(5, "Send", conn.SendTickerInterval(), "Receive",
-			conn.ReceiveTickerInterval())
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveRevApollo start sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveRevApollo connect response",
+			"command", conn.ConnectResponse.Command,
+			"headers", conn.ConnectResponse.Headers,
+			"body", string(conn.ConnectResponse.Body))
+		conn.log(LogHeartBeat, LevelDebug, "ticker intervals", "test", 5,
+			"send", conn.SendTickerInterval(), "receive", conn.ReceiveTickerInterval())
 		time.Sleep(hbs * time.Second)
 		//time.Sleep(30 * time.Second) // For experimentation
-		conn.log("TestHBSendReceiveRevApollo end sleep")
+		conn.log(LogLifecycle, LevelDebug, "TestHBSendReceiveRevApollo end sleep")
 		conn.hbd.rdl.Lock()
 		if conn.Hbrf {
 			t.Fatalf("TestHBSendReceiveRevApollo Error, dirty heart beat read detected")
@@ -17,9 +17,9 @@
 package stompws
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -74,15 +74,10 @@ func (c *Connection) initializeHeartBeats(ch Headers) (e error) {
 		return Error("non-numeric sy heartbeat value: " + sp[1])
 	}
 
-	// Check for sending needed
-	if w.cx == 0 || w.sy == 0 {
-		w.hbs = false //
-	}
-
-	// Check for receiving needed
-	if w.sx == 0 || w.cy == 0 {
-		w.hbr = false //
-	}
+	// Check for sending and receiving needed, intervals in ms
+	sm, rm := negotiateHeartBeats(w.cx, w.cy, w.sx, w.sy)
+	w.hbs = sm > 0
+	w.hbr = rm > 0
 
 	// ========================================================================
 
@@ -92,104 +87,132 @@ func (c *Connection) initializeHeartBeats(ch Headers) (e error) {
 
 	// ========================================================================
 
-	c.hbd = w                   // OK, we are doing some kind of heartbeating
+	// OK, we are doing some kind of heartbeating
 	ct := time.Now().UnixNano() // Prime current time
 
 	if w.hbs { // Finish sender parameters if required
-		sm := max(w.cx, w.sy)       // ticker interval, ms
 		w.sti = 1000000 * sm        // ticker interval, ns
 		w.ssd = make(chan struct{}) // add shutdown channel
 		w.ls = ct                   // Best guess at start
 		// fmt.Println("start send ticker")
-		go c.sendTicker()
+		go c.sendTicker(w)
 	}
 
 	if w.hbr { // Finish receiver parameters if required
-		rm := max(w.sx, w.cy)       // ticker interval, ms
 		w.rti = 1000000 * rm        // ticker interval, ns
 		w.rsd = make(chan struct{}) // add shutdown channel
 		w.lr = ct                   // Best guess at start
 		// fmt.Println("start receive ticker")
-		go c.receiveTicker()
+		go c.receiveTicker(w)
 	}
+	c.setHeartBeatData(w) // Complete before use
 	return nil
 }
 
 /*
-	The heart beat send ticker.
+	Heart beat negotiation, seen from either end of a connection.  x,y are
+	this end's heart-beat header values, and px,py the peer's.  Return the
+	send and receive intervals in ms, 0 when not required.
+*/
+func negotiateHeartBeats(x, y, px, py int64) (int64, int64) {
+	var s, r int64
+	if x > 0 && py > 0 {
+		s = max(x, py)
+	}
+	if y > 0 && px > 0 {
+		r = max(y, px)
+	}
+	return s, r
+}
+
+/*
+	The heart beat send ticker.  The heartbeat data is passed in, because
+	a reconnect replaces the connection's heartbeat data.
 */
-func (c *Connection) sendTicker() {
-	c.hbd.sc = 0
-	ticker := time.NewTicker(time.Duration(c.hbd.sti))
+func (c *Connection) sendTicker(hbd *heartBeatData) {
+	atomic.StoreInt64(&hbd.sc, 0)
+	ticker := time.NewTicker(time.Duration(hbd.sti))
 	defer ticker.Stop()
 hbSend:
 	for {
 		select {
 		case <-ticker.C:
-			c.log("HeartBeat Send data")
+			c.log(LogHeartBeat, LevelDebug, "HeartBeat Send data")
 			// Send a heartbeat
 			f := Frame{"\n", Headers{}, NULLBUFF} // Heartbeat frame
 			r := make(chan error)
 			if e := c.writeWireData(wiredata{f, r}); e != nil {
+				hbd.sdl.Lock()
 				c.Hbsf = true
+				hbd.sdl.Unlock()
+				atomic.AddInt64(&c.mets.hsf, 1)
+				c.hbFailed(hbSendFailed, true)
 				break hbSend
 			}
 			e := <-r
 			//
-			c.hbd.sdl.Lock()
+			hbd.sdl.Lock()
 			if e != nil {
-				fmt.Printf("Heartbeat Send Failure: %v\n", e)
+				c.log(LogHeartBeat, LevelWarn, "Heartbeat Send Failure", "error", e)
 				c.Hbsf = true
+				atomic.AddInt64(&c.mets.hsf, 1)
 			} else {
 				c.Hbsf = false
-				c.hbd.sc++
+				atomic.AddInt64(&hbd.sc, 1)
 			}
-			c.hbd.sdl.Unlock()
+			hbd.sdl.Unlock()
+			c.hbFailed(hbSendFailed, e != nil)
 			//
-		case _ = <-c.hbd.ssd:
+		case _ = <-hbd.ssd:
 			break hbSend
 		case _ = <-c.ssdc:
 			break hbSend
 		} // End of select
 	} // End of for
-	c.log("Heartbeat Send Ends", time.Now())
+	c.log(LogHeartBeat, LevelDebug, "Heartbeat Send Ends")
 	return
 }
 
 /*
 	The heart beat receive ticker.
 */
-func (c *Connection) receiveTicker() {
-	c.hbd.rc = 0
+func (c *Connection) receiveTicker(hbd *heartBeatData) {
+	atomic.StoreInt64(&hbd.rc, 0)
 	var first, last, nd int64
 hbGet:
 	for {
-		nd = c.hbd.rti - (last - first)
+		nd = hbd.rti - (last - first)
 		// Check if receives are supposed to be "fast" *and* we spent a
 		// lot of time in the previous loop.
 		if nd <= 0 {
-			nd = c.hbd.rti
+			nd = hbd.rti
 		}
 		ticker := time.NewTicker(time.Duration(nd))
 		select {
 		case ct := <-ticker.C:
 			first = time.Now().UnixNano()
 			ticker.Stop()
-			c.hbd.rdl.Lock()
-			flr := c.hbd.lr
+			hbd.rdl.Lock()
+			flr := hbd.lr
 			ld := ct.UnixNano() - flr
-			c.log("HeartBeat Receive TIC", "TickerVal", ct.UnixNano(),
-				"LastReceive", flr, "Diff", ld)
-			if ld > (c.hbd.rti + (c.hbd.rti / 5)) { // swag plus to be tolerant
-				c.log("HeartBeat Receive Read is dirty")
+			c.log(LogHeartBeat, LevelDebug, "HeartBeat Receive TIC", "ticker", ct.UnixNano(),
+				"last_receive", flr, "diff", ld)
+			if ld > (hbd.rti + (hbd.rti / 5)) { // swag plus to be tolerant
+				c.log(LogHeartBeat, LevelWarn, "HeartBeat Receive Read is dirty")
 				c.Hbrf = true // Flag possible dirty connection
+				atomic.AddInt64(&c.mets.hrf, 1)
 			} else {
 				c.Hbrf = false // Reset
-				c.hbd.rc++
+				atomic.AddInt64(&hbd.rc, 1)
+			}
+			dirty := c.Hbrf
+			hbd.rdl.Unlock()
+			if dirty {
+				c.event(Event{Type: EventHeartBeatMiss})
 			}
-			c.hbd.rdl.Unlock()
+			c.hbFailed(hbRecvFailed, dirty)
 			last = time.Now().UnixNano()
-		case _ = <-c.hbd.rsd:
+		case _ = <-hbd.rsd:
 			ticker.Stop()
 			break hbGet
 		case _ = <-c.ssdc:
@@ -197,6 +220,6 @@ hbGet:
 			break hbGet
 		} // End of select
 	} // End of for
-	c.log("Heartbeat Receive Ends", time.Now())
+	c.log(LogHeartBeat, LevelDebug, "Heartbeat Receive Ends")
 	return
 }
@@ -18,8 +18,12 @@ package stompws
 
 import (
 	"flag"
+	"log"
+	"net"
 	"os"
 	"testing"
+
+	"github.com/drawdy/stomp-ws-go/stomptest"
 )
 
 func TestMain(m *testing.M) {
@@ -30,6 +34,24 @@ func TestMain(m *testing.M) {
 
 //
 func packageInit() {
+	startEmbeddedBroker()
 	_ = setTestBroker()
 	setHeartBeatFlags()
 }
+
+/*
+	Run the tests against an in process broker if STOMP_EMBEDDED is set.
+*/
+func startEmbeddedBroker() {
+	if os.Getenv("STOMP_EMBEDDED") == "" {
+		return
+	}
+	b := stomptest.NewBroker(nil)
+	a, e := b.Listen("127.0.0.1:0")
+	if e != nil {
+		log.Fatalf("embedded broker listen error: %v\n", e)
+	}
+	h, p, _ := net.SplitHostPort(a)
+	os.Setenv("STOMP_HOST", h)
+	os.Setenv("STOMP_PORT", p)
+}
@@ -0,0 +1,93 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"github.com/drawdy/stomp-ws-go/frame"
+)
+
+/*
+	DefaultFrameLimits are the limits on received frames for new
+	connections, and for WSServer sessions.  A frame that exceeds a limit
+	is reported with ELIMLINE, ELIMHDRS, ELIMHDRB or ELIMBODY, and the
+	connection is shut down.  A zero field means no limit.
+
+	MaxBodySize also applies to MESSAGE bodies after decompression.  Such a
+	MESSAGE has been read in full, so it is delivered with ELIMBODY, still
+	compressed, and the connection continues.
+*/
+var DefaultFrameLimits = frame.Limits{
+	MaxLineLength:  64 * 1024,
+	MaxHeaders:     1000,
+	MaxHeaderBytes: 1024 * 1024,
+	MaxBodySize:    64 * 1024 * 1024,
+}
+
+/*
+	SetFrameLimits sets the limits on frames received after the call.
+
+	Example:
+		l := stompngo.DefaultFrameLimits
+		l.MaxBodySize = 1024 * 1024
+		c.SetFrameLimits(l)
+*/
+func (c *Connection) SetFrameLimits(l frame.Limits) {
+	c.limLock.Lock()
+	c.lim = l
+	c.limLock.Unlock()
+}
+
+func (c *Connection) frameLimits() frame.Limits {
+	c.limLock.Lock()
+	defer c.limLock.Unlock()
+	return c.lim
+}
+
+var frameErrors = map[error]error{
+	frame.EBADHDR:  EUNKHDR,
+	frame.ELINELEN: ELIMLINE,
+	frame.EHDRCNT:  ELIMHDRS,
+	frame.EHDRLEN:  ELIMHDRB,
+	frame.EBODYLEN: ELIMBODY,
+}
+
+/*
+	The package Error for a frame package error.
+*/
+func frameError(e error) error {
+	if fe, ok := frameErrors[e]; ok {
+		return fe
+	}
+	return e
+}
+
+/*
+	Read the CONNECT response, up to the NUL.  A response larger than the
+	header and body limits together is rejected.
+*/
+func (c *Connection) readResponse() ([]uint8, error) {
+	l := c.frameLimits()
+	max := 0
+	if l.MaxHeaderBytes > 0 && l.MaxBodySize > 0 {
+		max = l.MaxLineLength + l.MaxHeaderBytes + l.MaxBodySize + 1
+	}
+	b, e := frame.ReadUntil(c.rdr, 0, max)
+	if e == frame.ETOOLONG {
+		return b, ELIMHDRB
+	}
+	return b, e
+}
@@ -0,0 +1,153 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go/frame"
+)
+
+/*
+	Test each frame limit shuts the connection down with its own Error.
+*/
+func TestFrameLimits(t *testing.T) {
+	l := frame.Limits{MaxLineLength: 64, MaxHeaders: 8, MaxHeaderBytes: 256,
+		MaxBodySize: 1024}
+	sh := "MESSAGE\nsubscription:s1\nmessage-id:m1\ndestination:/queue/lim\n"
+	for _, d := range []struct {
+		raw string
+		err error
+	}{
+		{sh + "k:" + strings.Repeat("v", 100) + "\n\n\x00", ELIMLINE},
+		{sh + strings.Repeat("k:v\n", 10) + "\n\x00", ELIMHDRS},
+		{sh + strings.Repeat("k:"+strings.Repeat("v", 60)+"\n", 4) + "\n\x00", ELIMHDRB},
+		{sh + "content-length:100000000\n\n\x00", ELIMBODY},
+		{sh + "\n" + strings.Repeat("b", 2000) + "\x00", ELIMBODY},
+	} {
+		n, s := fakePipe()
+		go func() { _ = s.handshake() }()
+		c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+		if e != nil {
+			t.Fatalf("TestFrameLimits CONNECT expected nil, got %v\n", e)
+		}
+		c.SetFrameLimits(l)
+		go s.run()
+		sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/lim", HK_ID, "s1"})
+		if e != nil {
+			t.Fatalf("TestFrameLimits Subscribe expected nil, got %v\n", e)
+		}
+		_ = s.next(t) // SUBSCRIBE
+		s.sendRaw(d.raw)
+		select {
+		case md := <-sc:
+			if md.Error != d.err {
+				t.Fatalf("TestFrameLimits expected [%v], got [%v]\n", d.err, md.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestFrameLimits %v not reported\n", d.err)
+		}
+		select {
+		case _, ok := <-c.MessageData:
+			for ok {
+				_, ok = <-c.MessageData
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestFrameLimits %v no shutdown\n", d.err)
+		}
+		if c.Connected() {
+			t.Fatalf("TestFrameLimits %v expected disconnected\n", d.err)
+		}
+		s.close()
+	}
+}
+
+/*
+	Test an oversized CONNECT response.
+*/
+func TestFrameLimitsConnect(t *testing.T) {
+	dl := DefaultFrameLimits
+	defer func() { DefaultFrameLimits = dl }()
+	DefaultFrameLimits = frame.Limits{MaxLineLength: 64, MaxHeaderBytes: 64,
+		MaxBodySize: 64}
+	n, s := fakePipe()
+	go func() {
+		if _, e := s.readFrame(); e == nil {
+			s.sendRaw("CONNECTED\nk:" + strings.Repeat("v", 300) + "\n\n\x00")
+		}
+	}()
+	if _, e := Connect(n, Headers{}); e != ELIMHDRB {
+		t.Fatalf("TestFrameLimitsConnect expected [%v], got [%v]\n", ELIMHDRB, e)
+	}
+	s.close()
+}
+
+/*
+	Test the body limit applies after decompression, and the session
+	continues.
+*/
+func TestFrameLimitsDecompressed(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, _ = w.Write(make([]byte, 1024*1024))
+	_ = w.Close()
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestFrameLimitsDecompressed CONNECT expected nil, got %v\n", e)
+	}
+	c.SetFrameLimits(frame.Limits{MaxBodySize: 64 * 1024})
+	go s.run()
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/lim", HK_ID, "s1"})
+	if e != nil {
+		t.Fatalf("TestFrameLimitsDecompressed Subscribe expected nil, got %v\n", e)
+	}
+	_ = s.next(t) // SUBSCRIBE
+	mh := Headers{HK_SUBSCRIPTION, "s1", HK_MESSAGE_ID, "m1",
+		HK_DESTINATION, "/queue/lim", HK_CONTENT_ENCODING, CE_GZIP,
+		HK_CONTENT_LENGTH, strconv.Itoa(gz.Len())}
+	go func() {
+		s.send(MESSAGE, mh, gz.String())
+		s.send(MESSAGE, Headers{HK_SUBSCRIPTION, "s1", HK_MESSAGE_ID, "m2",
+			HK_DESTINATION, "/queue/lim"}, "ok")
+	}()
+	for _, w := range []error{ELIMBODY, nil} {
+		select {
+		case md := <-sc:
+			if md.Error != w {
+				t.Fatalf("TestFrameLimitsDecompressed expected [%v], got [%v]\n", w, md.Error)
+			}
+			if w != nil && len(md.Message.Body) != gz.Len() {
+				t.Fatalf("TestFrameLimitsDecompressed bad body length %d\n",
+					len(md.Message.Body))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestFrameLimitsDecompressed no MESSAGE\n")
+		}
+	}
+	if !c.Connected() {
+		t.Fatalf("TestFrameLimitsDecompressed expected connected\n")
+	}
+	_ = c.Disconnect(empty_headers)
+	s.close()
+}
@@ -0,0 +1,242 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+	Level is the severity of a log message.  The values match log/slog.
+*/
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+	LevelOff   Level = 1 << 20 // For SetLogLevel only, log nothing
+)
+
+func (l Level) String() string {
+	switch {
+	case l <= LevelDebug:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+/*
+	Logger is a structured, leveled logger.  kv holds alternating keys and
+	values.  Each message includes the "session" and "category" keys.
+
+	Adapters for log/slog, zap and logrus are in the stomplog subpackage.
+*/
+type Logger interface {
+	Log(level Level, msg string, kv ...interface{})
+}
+
+/*
+	LogCategory groups log messages, so each group can have its own level.
+*/
+type LogCategory int
+
+const (
+	LogLifecycle LogCategory = iota // Commands, errors, shutdown and reconnect
+	LogWire                         // Frames read and written
+	LogHeartBeat                    // Heart beat sends and checks
+	logCategories
+)
+
+var logCategoryNames = [logCategories]string{"lifecycle", "wire", "heartbeat"}
+
+func (lc LogCategory) String() string {
+	if lc < 0 || lc >= logCategories {
+		return "unknown"
+	}
+	return logCategoryNames[lc]
+}
+
+/*
+	RedactedHeaders are header keys whose values are never logged, compared
+	without regard to case.  Change it before creating connections.
+
+	Only values of type Headers are redacted.  Header data logged in any
+	other form, such as a []string or text formatted by the caller, is
+	logged as is.
+*/
+var RedactedHeaders = []string{HK_LOGIN, HK_PASSCODE, "authorization"}
+
+const redacted = "[REDACTED]"
+
+/*
+	NewStdLogger returns a Logger that writes one line per message to l,
+	with the level, the message, and key=value pairs.
+*/
+func NewStdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Log(level Level, msg string, kv ...interface{}) {
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", kv[i])
+		}
+	}
+	_ = s.l.Output(3, b.String())
+}
+
+/*
+	SetLogger enables a client defined logger for this connection.  Messages
+	are written with NewStdLogger.
+
+	Set to "nil" to disable logging.
+
+	Example:
+		// Start logging
+		l := log.New(os.Stdout, "", log.Ldate|log.Lmicroseconds)
+		c.SetLogger(l)
+*/
+func (c *Connection) SetLogger(l *log.Logger) {
+	logLock.Lock()
+	c.stdl = l
+	c.logger = nil
+	if l != nil {
+		c.logger = NewStdLogger(l)
+	}
+	logLock.Unlock()
+}
+
+/*
+	GetLogger - returns the current connection logger, if set by SetLogger.
+*/
+func (c *Connection) GetLogger() *log.Logger {
+	logLock.Lock()
+	defer logLock.Unlock()
+	return c.stdl
+}
+
+/*
+	SetStructuredLogger enables a Logger for this connection.  Set to nil to
+	disable logging.
+
+	Example:
+		c.SetStructuredLogger(stomplog.Slog(slog.Default()))
+		c.SetLogLevel(stompngo.LogWire, stompngo.LevelOff)
+*/
+func (c *Connection) SetStructuredLogger(l Logger) {
+	logLock.Lock()
+	c.stdl = nil
+	c.logger = l
+	logLock.Unlock()
+}
+
+/*
+	SetLogLevel sets the minimum level logged for a category.  All categories
+	start at LevelDebug.  LevelOff disables a category.
+*/
+func (c *Connection) SetLogLevel(lc LogCategory, l Level) {
+	if lc < 0 || lc >= logCategories {
+		return
+	}
+	logLock.Lock()
+	c.logLevels[lc] = l
+	logLock.Unlock()
+}
+
+// Unexported Connection methods
+
+/*
+	The Logger for a message, nil if it is not logged.
+*/
+func (c *Connection) loggerFor(lc LogCategory, l Level) Logger {
+	logLock.Lock()
+	defer logLock.Unlock()
+	if c.logger == nil || l < c.logLevels[lc] {
+		return nil
+	}
+	return c.logger
+}
+
+/*
+	Whether a message would be logged.  Use it to avoid formatting frame
+	data that is not wanted.
+*/
+func (c *Connection) logEnabled(lc LogCategory, l Level) bool {
+	return c.loggerFor(lc, l) != nil
+}
+
+/*
+	Log a message if possible.
+*/
+func (c *Connection) log(lc LogCategory, l Level, msg string, kv ...interface{}) {
+	lg := c.loggerFor(lc, l)
+	if lg == nil {
+		return
+	}
+	c.sessLock.Lock()
+	s := c.session
+	c.sessLock.Unlock()
+	f := make([]interface{}, 0, len(kv)+4)
+	f = append(f, "session", s, "category", lc.String())
+	for i, v := range kv {
+		if h, ok := v.(Headers); ok && i%2 == 1 {
+			v = redact(h)
+		}
+		f = append(f, v)
+	}
+	lg.Log(l, msg, f...)
+}
+
+/*
+	A copy of h with RedactedHeaders values hidden.
+*/
+func redact(h Headers) Headers {
+	var r Headers
+	for _, k := range RedactedHeaders {
+		for i := 0; i < len(h)-1; i += 2 {
+			if !strings.EqualFold(h[i], k) {
+				continue
+			}
+			if r == nil {
+				r = h.Clone()
+			}
+			r[i+1] = redacted
+		}
+	}
+	if r == nil {
+		return h
+	}
+	return r
+}
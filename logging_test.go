@@ -0,0 +1,137 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level Level
+	msg   string
+	kv    []interface{}
+}
+
+type captureLogger struct {
+	sync.Mutex
+	l []logEntry
+}
+
+func (c *captureLogger) Log(level Level, msg string, kv ...interface{}) {
+	c.Lock()
+	c.l = append(c.l, logEntry{level, msg, kv})
+	c.Unlock()
+}
+
+func (c *captureLogger) entries() []logEntry {
+	c.Lock()
+	defer c.Unlock()
+	return append([]logEntry(nil), c.l...)
+}
+
+/*
+	Test redaction, and per category levels.
+*/
+func TestStructuredLogger(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestStructuredLogger CONNECT expected nil, got %v\n", e)
+	}
+	cl := &captureLogger{}
+	c.SetStructuredLogger(cl)
+	go s.run()
+	sh := Headers{HK_DESTINATION, "/queue/log.wire", HK_PASSCODE, "secret"}
+	if e = c.Send(sh, "wire"); e != nil {
+		t.Fatalf("TestStructuredLogger Send expected nil, got %v\n", e)
+	}
+	if f := s.next(t); f.Headers.Value(HK_PASSCODE) != "secret" {
+		t.Fatalf("TestStructuredLogger wire headers changed: %v\n", f.Headers)
+	}
+	c.SetLogLevel(LogWire, LevelOff)
+	sh = Headers{HK_DESTINATION, "/queue/log.off"}
+	if e = c.Send(sh, "off"); e != nil {
+		t.Fatalf("TestStructuredLogger Send expected nil, got %v\n", e)
+	}
+	_ = s.next(t)
+	_ = c.Disconnect(empty_headers)
+	s.close()
+	//
+	wire := 0
+	for _, le := range cl.entries() {
+		m := fmt.Sprint(le.msg, le.kv)
+		if strings.Contains(m, "secret") {
+			t.Fatalf("TestStructuredLogger passcode logged: %s\n", m)
+		}
+		if len(le.kv) < 4 || le.kv[0] != "session" || le.kv[2] != "category" {
+			t.Fatalf("TestStructuredLogger bad fields: %v\n", le.kv)
+		}
+		if le.kv[3] == LogWire.String() && strings.Contains(m, "/queue/log.off") {
+			t.Fatalf("TestStructuredLogger LogWire is off, got: %s\n", m)
+		}
+		if le.kv[3] == LogWire.String() && strings.Contains(m, "/queue/log.wire") {
+			wire++
+			if !strings.Contains(m, redacted) {
+				t.Fatalf("TestStructuredLogger expected %s, got: %s\n", redacted, m)
+			}
+		}
+	}
+	if wire == 0 {
+		t.Fatalf("TestStructuredLogger no wire log for SEND\n")
+	}
+}
+
+/*
+	Test the default redacted headers.
+*/
+func TestRedact(t *testing.T) {
+	h := Headers{HK_LOGIN, "user", HK_PASSCODE, "secret", "Authorization",
+		"Bearer tok", HK_DESTINATION, "/queue/redact"}
+	r := redact(h)
+	for i := 0; i < 6; i += 2 {
+		if r[i+1] != redacted {
+			t.Fatalf("TestRedact %s expected %s, got %s\n", r[i], redacted, r[i+1])
+		}
+	}
+	if r.Value(HK_DESTINATION) != "/queue/redact" || h.Value(HK_LOGIN) != "user" {
+		t.Fatalf("TestRedact bad headers %v %v\n", r, h)
+	}
+}
+
+/*
+	Test the *log.Logger format.
+*/
+func TestStdLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := NewStdLogger(log.New(&b, "", 0))
+	l.Log(LevelWarn, "msg", "k1", 1, "k2", "v2", "odd")
+	if w := "WARN msg k1=1 k2=v2 odd\n"; b.String() != w {
+		t.Fatalf("TestStdLogger expected %q, got %q\n", w, b.String())
+	}
+	for l, w := range map[Level]string{LevelDebug: "DEBUG", LevelInfo: "INFO",
+		LevelWarn + 1: "WARN", LevelError: "ERROR"} {
+		if l.String() != w {
+			t.Fatalf("TestStdLogger expected %s, got %s\n", w, l)
+		}
+	}
+}
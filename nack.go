@@ -17,6 +17,7 @@
 package stompws
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -47,7 +48,22 @@ var _ = fmt.Println
 
 */
 func (c *Connection) Nack(h Headers) error {
-	c.log(NACK, "start", h, c.Protocol())
+	return c.NackContext(context.Background(), h)
+}
+
+/*
+	NackContext is like Nack, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) NackContext(ctx context.Context, h Headers) error {
+	return c.nackContext(ctx, h, h.Value(HK_SUBSCRIPTION))
+}
+
+/*
+	Nack, counted for subscription sid when sid is known.
+*/
+func (c *Connection) nackContext(ctx context.Context, h Headers, sid string) error {
+	c.log(LogLifecycle, LevelDebug, "NACK start", "headers", h, "protocol", c.Protocol())
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -73,7 +89,10 @@ func (c *Connection) Nack(h Headers) error {
 		}
 	}
 
-	e = c.transmitCommon(NACK, h) // transmitCommon Clones() the headers
-	c.log(NACK, "end", h, c.Protocol())
+	e = c.transmitCommonContext(ctx, NACK, h) // transmitCommon Clones() the headers
+	if e == nil {
+		c.subAcked(sid, NACK)
+	}
+	c.log(LogLifecycle, LevelDebug, "NACK end", "headers", h, "protocol", c.Protocol())
 	return e
 }
@@ -18,12 +18,12 @@ package stompws
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"net"
-	"strconv"
-	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/drawdy/stomp-ws-go/frame"
 )
 
 /*
@@ -36,21 +36,25 @@ func (c *Connection) reader() {
 readLoop:
 	for {
 		f, e := c.readFrame()
-		logLock.Lock()
-		if c.logger != nil {
-			c.logx("RDR_RECEIVE_FRAME", f.Command, f.Headers, HexData(f.Body),
-				"RDR_RECEIVE_ERR", e)
+		if c.logEnabled(LogWire, LevelDebug) {
+			c.log(LogWire, LevelDebug, "RDR_RECEIVE_FRAME", "command", f.Command,
+				"headers", f.Headers, "body", HexData(f.Body), "error", e)
 		}
-		logLock.Unlock()
 		if e != nil {
 			//debug.PrintStack()
 			f.Headers = append(f.Headers, "connection_read_error", e.Error())
 			md := MessageData{Message(f), e}
+			atomic.AddInt64(&c.mets.rer, 1)
+			c.rcpm.fail(md) // Receipts never arrive on a broken transport
+			if c.reconnect(md) {
+				continue readLoop
+			}
 			c.handleReadError(md)
+			c.notifyError(md)
 			if e == io.EOF && !c.isConnected() {
-				c.log("RDR_SHUTDOWN_EOF", e)
+				c.log(LogLifecycle, LevelInfo, "RDR_SHUTDOWN_EOF", "error", e)
 			} else {
-				c.log("RDR_CONN_GENL_ERR", e)
+				c.log(LogLifecycle, LevelError, "RDR_CONN_GENL_ERR", "error", e)
 			}
 			break readLoop
 		}
@@ -60,20 +64,22 @@ readLoop:
 		}
 
 		m := Message(f)
-		c.mets.tfr += 1 // Total frames read
-		// Headers already decoded
-		c.mets.tbr += m.Size(false) // Total bytes read
+		c.mets.read(m.Command, m.Size(false)) // Headers already decoded
 
 		//*************************************************************************
 		// Replacement START
 		md := MessageData{m, e}
+		if f.Command == MESSAGE { // Held to the body limit, see limits.go
+			md.Error = c.decompress(&md.Message)
+		}
 		switch f.Command {
 		//
 		case MESSAGE:
 			sid, ok := f.Headers.Contains(HK_SUBSCRIPTION)
-			if !ok { // This should *NEVER* happen
-				panic(fmt.Sprintf("stompngo INTERNAL ERROR: command:<%s> headers:<%v>",
-					f.Command, f.Headers))
+			if !ok { // Broker error, there is no subscriber to deliver to
+				c.log(LogLifecycle, LevelError, "RDR_NOSUBHDR", "command", m.Command, "headers", m.Headers)
+				c.protocolError(MessageData{m, EMSGNOSUB})
+				break
 			}
 			c.subsLock.RLock()
 			ps, sok := c.subs[sid] // This is a map of pointers .....
@@ -81,360 +87,170 @@ readLoop:
 			if !sok {
 				// The sub can be gone under some timing conditions.  In that case
 				// we log it of possible, and continue (hope for the best).
-				c.log("RDR_NOSUB", sid, m.Command, m.Headers)
+				c.log(LogLifecycle, LevelWarn, "RDR_NOSUB", "subscription", sid, "command", m.Command,
+					"headers", m.Headers)
 				goto csRUnlock
 			}
 			if ps.cs {
 				// The sub can also already be closed under some conditions.
 				// Again, we log that if possible, and continue
-				c.log("RDR_CLSUB", sid, m.Command, m.Headers)
+				c.log(LogLifecycle, LevelWarn, "RDR_CLSUB", "subscription", sid, "command", m.Command,
+					"headers", m.Headers)
 				goto csRUnlock
 			}
 			// Handle subscription draining
 			switch ps.drav {
 			case false:
-				ps.md <- md
+				c.deliver(ps, md)
 			default:
 				ps.drmc++
 				if ps.drmc > ps.dra {
-					logLock.Lock()
-					if c.logger != nil {
-						c.logx("RDR_DROPM", ps.drmc, sid, m.Command,
-							m.Headers, HexData(m.Body))
+					if c.logEnabled(LogLifecycle, LevelDebug) {
+						c.log(LogLifecycle, LevelDebug, "RDR_DROPM", "count", ps.drmc,
+							"subscription", sid, "command", m.Command,
+							"headers", m.Headers, "body", HexData(m.Body))
 					}
-					logLock.Unlock()
 				} else {
-					ps.md <- md
+					c.deliver(ps, md)
 				}
 			}
 		csRUnlock:
 			c.subsLock.RUnlock()
 		//
 		case ERROR:
+			c.event(Event{Type: EventErrorFrame, Message: m})
 			fallthrough
 		//
 		case RECEIPT:
-			c.input <- md
+			if !c.rcpm.complete(md) {
+				c.input <- md
+			}
 		//
 		default:
-			panic(fmt.Sprintf("Broker SEVERE ERROR, not STOMP? command:<%s> headers:<%v>",
-				f.Command, f.Headers))
+			c.log(LogLifecycle, LevelError, "RDR_BADCMD", "command", HexData([]byte(m.Command)),
+				"headers", m.Headers)
+			c.protocolError(MessageData{m, EINVBCMD})
 		}
 		// Replacement END
 		//*************************************************************************
 
 		select {
 		case _ = <-c.ssdc:
-			c.log("RDR_SHUTDOWN detected")
+			c.log(LogLifecycle, LevelDebug, "RDR_SHUTDOWN detected")
 			break readLoop
 		default:
 		}
-		c.log("RDR_RELOOP")
+		c.log(LogWire, LevelDebug, "RDR_RELOOP")
 	}
+	c.rcpm.fail(MessageData{Message{}, ECONBAD})
 	close(c.input)
 	c.setConnected(false)
 	c.sysAbort()
-	c.log("RDR_SHUTDOWN", time.Now())
+	c.readerClosed(nil) // If not already reported
+	c.log(LogLifecycle, LevelInfo, "RDR_SHUTDOWN")
 }
 
-func (c *Connection) readerOverWS() {
-readLoop:
-	for {
-		f, e := c.readFrameOverWS()
-		logLock.Lock()
-		if c.logger != nil {
-			c.logx("RDR_RECEIVE_FRAME", f.Command, f.Headers, HexData(f.Body),
-				"RDR_RECEIVE_ERR", e)
-		}
-		logLock.Unlock()
-		if e != nil {
-			//debug.PrintStack()
-			f.Headers = append(f.Headers, "connection_read_error", e.Error())
-			md := MessageData{Message(f), e}
-			c.handleReadError(md)
-			if e == io.EOF && !c.isConnected() {
-				c.log("RDR_SHUTDOWN_EOF", e)
-			} else {
-				c.log("RDR_CONN_GENL_ERR", e)
-			}
-			break readLoop
-		}
-
-		if f.Command == "" {
-			continue readLoop
-		}
-
-		m := Message(f)
-		c.mets.tfr += 1 // Total frames read
-		// Headers already decoded
-		c.mets.tbr += m.Size(false) // Total bytes read
-
-		//*************************************************************************
-		// Replacement START
-		md := MessageData{m, e}
-		switch f.Command {
-		//
-		case MESSAGE:
-			sid, ok := f.Headers.Contains(HK_SUBSCRIPTION)
-			if !ok { // This should *NEVER* happen
-				panic(fmt.Sprintf("stompngo INTERNAL ERROR: command:<%s> headers:<%v>",
-					f.Command, f.Headers))
-			}
-			c.subsLock.RLock()
-			ps, sok := c.subs[sid] // This is a map of pointers .....
-			//
-			if !sok {
-				// The sub can be gone under some timing conditions.  In that case
-				// we log it of possible, and continue (hope for the best).
-				c.log("RDR_NOSUB", sid, m.Command, m.Headers)
-				goto csRUnlock
-			}
-			if ps.cs {
-				// The sub can also already be closed under some conditions.
-				// Again, we log that if possible, and continue
-				c.log("RDR_CLSUB", sid, m.Command, m.Headers)
-				goto csRUnlock
-			}
-			// Handle subscription draining
-			switch ps.drav {
-			case false:
-				ps.md <- md
-			default:
-				ps.drmc++
-				if ps.drmc > ps.dra {
-					logLock.Lock()
-					if c.logger != nil {
-						c.logx("RDR_DROPM", ps.drmc, sid, m.Command,
-							m.Headers, HexData(m.Body))
-					}
-					logLock.Unlock()
-				} else {
-					ps.md <- md
-				}
-			}
-		csRUnlock:
-			c.subsLock.RUnlock()
-		//
-		case ERROR:
-			fallthrough
-		//
-		case RECEIPT:
-			c.input <- md
-		//
-		default:
-			panic(fmt.Sprintf("Broker SEVERE ERROR, not STOMP? command:<%s> headers:<%v>",
-				f.Command, f.Headers))
-		}
-		// Replacement END
-		//*************************************************************************
-
-		select {
-		case _ = <-c.ssdc:
-			c.log("RDR_SHUTDOWN detected")
-			break readLoop
-		default:
-		}
-		c.log("RDR_RELOOP")
+/*
+	Report a well formed frame the reader cannot handle.  The frame has been
+	consumed in full, so the session continues.  The reader never blocks on
+	the connection MessageData channel: if it is full the frame is dropped
+	there, and is still counted and passed to the error handler.
+*/
+func (c *Connection) protocolError(md MessageData) {
+	atomic.AddInt64(&c.mets.per, 1)
+	select {
+	case c.input <- md:
+	default:
+		c.log(LogLifecycle, LevelWarn, "RDR_PROTOCOL_ERROR_DROP", "command", md.Message.Command,
+			"error", md.Error)
 	}
-	close(c.input)
-	c.setConnected(false)
-	c.sysAbort()
-	c.log("RDR_SHUTDOWN", time.Now())
+	c.notifyError(md)
 }
 
 /*
 	Physical frame reader.
 
-	This parses a single STOMP frame from data off of the wire, and
-	returns a Frame, with a possible error.
+	This parses a single STOMP frame from the current transport, and
+	returns a Frame, with a possible error.  A heart beat is returned as a
+	Frame with an empty Command.
 
 	Note: this functionality could hang or exhibit other erroneous behavior
 	if running against a non-compliant STOMP server.
 */
 func (c *Connection) readFrame() (f Frame, e error) {
-	f = Frame{"", Headers{}, NULLBUFF}
-
-	// Read f.Command or line ends (maybe heartbeats)
-	c.setReadDeadline()
-	s, e := c.rdr.ReadString('\n')
-	if c.checkReadError(e) != nil {
-		return f, e
-	}
-	if s == "" {
-		return f, e
-	}
-	if c.hbd != nil {
-		c.updateHBReads()
-	}
-	f.Command = s[0 : len(s)-1]
-	if s == "\n" {
-		return f, e
+	c.dec.Limits = c.frameLimits()
+	ff, e := c.dec.Decode()
+	f = Frame{ff.Command, Headers(ff.Headers), ff.Body}
+	if e != nil {
+		return f, c.checkReadError(frameError(e))
 	}
-
-	// Validate the command
-	if _, ok := validCmds[f.Command]; !ok {
-		ev := fmt.Errorf("%s\n%s", EINVBCMD, HexData([]byte(f.Command)))
-		return f, ev
+	if f.Command == "" {
+		return f, nil
 	}
-	// Read f.Headers
-	for {
-		c.setReadDeadline()
-		s, e := c.rdr.ReadString('\n')
-		if c.checkReadError(e) != nil {
-			return f, e
-		}
-		if c.hbd != nil {
-			c.updateHBReads()
-		}
-		if s == "\n" {
-			break
-		}
-		s = s[0 : len(s)-1]
-		p := strings.SplitN(s, ":", 2)
-		if len(p) != 2 {
-			return f, EUNKHDR
-		}
-		// Always decode regardless of protocol level. See issue #47.
-		p[0] = decode(p[0])
-		p[1] = decode(p[1])
-		f.Headers = append(f.Headers, p[0], p[1])
-	}
-	//
 	e = checkHeaders(f.Headers, c.Protocol())
 	if e != nil {
 		return f, e
 	}
-	// Read f.Body
-	if v, ok := f.Headers.Contains(HK_CONTENT_LENGTH); ok {
-		l, e := strconv.Atoi(strings.TrimSpace(v))
-		if e != nil {
-			return f, e
-		}
-		if l == 0 {
-			f.Body, e = readUntilNul(c)
-		} else {
-			f.Body, e = readBody(c, l)
-		}
-	} else {
-		// content-length not present
-		f.Body, e = readUntilNul(c)
-	}
-	if c.checkReadError(e) != nil {
-		return f, e
-	}
-	if c.hbd != nil {
-		c.updateHBReads()
-	}
 	// End of read loop - set no deadline
 	if c.dld.rde {
-		_ = c.netconn.SetReadDeadline(c.dld.t0)
+		c.clearReadDeadline()
 	}
-	return f, e
+	return f, nil
 }
 
-func (c *Connection) readFrameOverWS() (f Frame, e error) {
-	f = Frame{"", Headers{}, NULLBUFF}
-
-	// Read f.Command or line ends (maybe heartbeats)
-	c.setReadDeadlineOverWS()
-	_, r, e := c.wsConn.NextReader()
-	if e != nil {
-		return f, e
-	}
-	c.rdr = bufio.NewReader(r)
-	s, e := c.rdr.ReadString('\n')
-	if c.checkReadError(e) != nil {
-		return f, e
-	}
-	if s == "" {
-		return f, e
-	}
-	if c.hbd != nil {
-		c.updateHBReads()
-	}
-	f.Command = s[0 : len(s)-1]
-	if s == "\n" {
-		return f, e
-	}
+/*
+	The transport byte stream.  Each network read sets any read deadline
+	first, and counts as heart beat data.
+*/
+type wireReader struct {
+	c *Connection
+	r io.Reader // net.Conn or wsStream
+}
 
-	// Validate the command
-	if _, ok := validCmds[f.Command]; !ok {
-		ev := fmt.Errorf("%s\n%s", EINVBCMD, HexData([]byte(f.Command)))
-		return f, ev
-	}
-	// Read f.Headers
-	for {
-		//c.setReadDeadlineOverWS()
-		s, e := c.rdr.ReadString('\n')
-		if c.checkReadError(e) != nil {
-			return f, e
-		}
-		if c.hbd != nil {
-			c.updateHBReads()
-		}
-		if s == "\n" {
-			break
-		}
-		s = s[0 : len(s)-1]
-		p := strings.SplitN(s, ":", 2)
-		if len(p) != 2 {
-			return f, EUNKHDR
-		}
-		// Always decode regardless of protocol level. See issue #47.
-		p[0] = decode(p[0])
-		p[1] = decode(p[1])
-		f.Headers = append(f.Headers, p[0], p[1])
-	}
-	//
-	e = checkHeaders(f.Headers, c.Protocol())
-	if e != nil {
-		return f, e
-	}
-	// Read f.Body
-	if v, ok := f.Headers.Contains(HK_CONTENT_LENGTH); ok {
-		l, e := strconv.Atoi(strings.TrimSpace(v))
-		if e != nil {
-			return f, e
-		}
-		if l == 0 {
-			f.Body, e = readUntilNulOverWS(c)
-		} else {
-			f.Body, e = readBodyOverWS(c, l)
-		}
-	} else {
-		// content-length not present
-		f.Body, e = readUntilNulOverWS(c)
-	}
-	if c.checkReadError(e) != nil {
-		return f, e
-	}
-	if c.hbd != nil {
-		c.updateHBReads()
-	}
-	// End of read loop - set no deadline
-	if c.dld.rde {
-		_ = c.wsConn.SetReadDeadline(c.dld.t0)
+func (w wireReader) Read(p []byte) (int, error) {
+	w.c.setReadDeadline()
+	n, e := w.r.Read(p)
+	if n > 0 {
+		w.c.updateHBReads()
 	}
+	return n, e
+}
 
-	return f, e
+/*
+	Set up the frame reader for a new transport.
+*/
+func (c *Connection) newReader(r io.Reader) {
+	c.rdr = bufio.NewReader(wireReader{c, r})
+	c.dec = frame.NewDecoder(c.rdr)
+	c.dec.Version = frame.V12 // Always decode regardless of protocol level. See issue #47.
 }
 
 func (c *Connection) updateHBReads() {
-	c.hbd.rdl.Lock()
-	c.hbd.lr = time.Now().UnixNano() // Latest good read
-	c.hbd.rdl.Unlock()
+	hbd := c.heartBeatData()
+	if hbd == nil {
+		return
+	}
+	hbd.rdl.Lock()
+	hbd.lr = time.Now().UnixNano() // Latest good read
+	hbd.rdl.Unlock()
 }
 
 func (c *Connection) setReadDeadline() {
 	if c.dld.rde && c.dld.rds {
-		_ = c.netconn.SetReadDeadline(time.Now().Add(c.dld.rdld))
+		c.readDeadline(time.Now().Add(c.dld.rdld))
 	}
 }
 
-func (c *Connection) setReadDeadlineOverWS() {
-	if c.dld.rde && c.dld.rds {
-		_ = c.wsConn.SetReadDeadline(time.Now().Add(c.dld.rdld))
+func (c *Connection) clearReadDeadline() {
+	c.readDeadline(c.dld.t0)
+}
+
+func (c *Connection) readDeadline(t time.Time) {
+	if c.wsConn != nil {
+		_ = c.wsConn.SetReadDeadline(t)
+		return
 	}
+	_ = c.netconn.SetReadDeadline(t)
 }
 
 func (c *Connection) checkReadError(e error) error {
@@ -449,7 +265,7 @@ func (c *Connection) checkReadError(e error) error {
 	if ne.Timeout() {
 		//c.log("is a timeout")
 		if c.dld.dns {
-			c.log("invoking read deadline callback")
+			c.log(LogLifecycle, LevelWarn, "invoking read deadline callback")
 			c.dld.dlnotify(e, false)
 		}
 	}
@@ -0,0 +1,168 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Test frames the reader cannot handle are reported, and the session
+	continues.
+*/
+func TestReaderProtocolErrors(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestReaderProtocolErrors CONNECT expected nil, got %v\n", e)
+	}
+	cb := make(chan MessageData, 8)
+	c.SetErrorHandler(func(md MessageData) { cb <- md })
+	go s.run()
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/rdrerr", HK_ID, "s1"})
+	if e != nil {
+		t.Fatalf("TestReaderProtocolErrors Subscribe expected nil, got %v\n", e)
+	}
+	_ = s.next(t) // SUBSCRIBE
+	for _, d := range []struct {
+		raw string
+		cmd string
+		err error
+	}{
+		{"MESSAGE\nmessage-id:m1\ndestination:/queue/rdrerr\n\nnosub\x00", MESSAGE, EMSGNOSUB},
+		{"BOGUS\nk:v\n\n\x00", "BOGUS", EINVBCMD},
+	} {
+		s.sendRaw(d.raw)
+		for _, ch := range []<-chan MessageData{c.MessageData, cb} {
+			select {
+			case md := <-ch:
+				if md.Error != d.err || md.Message.Command != d.cmd {
+					t.Fatalf("TestReaderProtocolErrors expected [%v] [%s], got [%v] [%s]\n",
+						d.err, d.cmd, md.Error, md.Message.Command)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("TestReaderProtocolErrors %v not reported\n", d.err)
+			}
+		}
+	}
+	s.sendRaw("MESSAGE\nsubscription:s1\nmessage-id:m2\ndestination:/queue/rdrerr\n\nok\x00")
+	select {
+	case md := <-sc:
+		if md.Error != nil || md.Message.BodyString() != "ok" {
+			t.Fatalf("TestReaderProtocolErrors bad MESSAGE %q %v\n", md.Message, md.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestReaderProtocolErrors no MESSAGE\n")
+	}
+	if !c.Connected() {
+		t.Fatalf("TestReaderProtocolErrors expected connected\n")
+	}
+	// The error that ends the session is passed to the callback as well
+	s.close()
+	select {
+	case md := <-cb:
+		if md.Error == nil {
+			t.Fatalf("TestReaderProtocolErrors expected read error, got nil\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestReaderProtocolErrors read error not reported\n")
+	}
+}
+
+/*
+	Test the reader does not block on protocol errors no client reads.
+*/
+func TestReaderProtocolErrorsUnread(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestReaderProtocolErrorsUnread CONNECT expected nil, got %v\n", e)
+	}
+	cb := make(chan MessageData, 8)
+	c.SetErrorHandler(func(md MessageData) { cb <- md })
+	go s.run()
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/rdrunread", HK_ID, "s1"})
+	if e != nil {
+		t.Fatalf("TestReaderProtocolErrorsUnread Subscribe expected nil, got %v\n", e)
+	}
+	_ = s.next(t) // SUBSCRIBE
+	// Nothing reads c.MessageData
+	s.sendRaw("BOGUS\nk:v\n\n\x00" +
+		"BOGUS\nk:v\n\n\x00" +
+		"MESSAGE\nsubscription:s1\nmessage-id:m1\ndestination:/queue/rdrunread\n\nok\x00")
+	for i := 0; i < 2; i++ {
+		select {
+		case md := <-cb:
+			if md.Error != EINVBCMD {
+				t.Fatalf("TestReaderProtocolErrorsUnread expected [%v], got [%v]\n",
+					EINVBCMD, md.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestReaderProtocolErrorsUnread error %d not reported\n", i+1)
+		}
+	}
+	select {
+	case md := <-sc:
+		if md.Error != nil || md.Message.BodyString() != "ok" {
+			t.Fatalf("TestReaderProtocolErrorsUnread bad MESSAGE %q %v\n", md.Message, md.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestReaderProtocolErrorsUnread reader blocked\n")
+	}
+	if pe := c.Stats().ProtocolErrors; pe != 2 {
+		t.Fatalf("TestReaderProtocolErrorsUnread expected 2 ProtocolErrors, got %d\n", pe)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test an unsupported protocol level is an error, not a crash.
+*/
+func TestProtocolLevelErrors(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestProtocolLevelErrors CONNECT expected nil, got %v\n", e)
+	}
+	go s.run()
+	c.protoLock.Lock()
+	c.protocol = "9.9"
+	c.protoLock.Unlock()
+	for _, h := range []Headers{
+		{HK_DESTINATION, "/queue/proto"},
+		{HK_DESTINATION, "/queue/proto", HK_ACK, AckModeClient},
+	} {
+		if _, e = c.Subscribe(h); e != EBADPROTO {
+			t.Fatalf("TestProtocolLevelErrors Subscribe expected [%v], got [%v]\n",
+				EBADPROTO, e)
+		}
+	}
+	if e = c.Unsubscribe(Headers{HK_ID, "s1"}); e != EBADPROTO {
+		t.Fatalf("TestProtocolLevelErrors Unsubscribe expected [%v], got [%v]\n",
+			EBADPROTO, e)
+	}
+	c.protoLock.Lock()
+	c.protocol = SPL_12
+	c.protoLock.Unlock()
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -0,0 +1,286 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+	Receipt is a pending broker receipt for a single frame.
+
+	A Receipt completes when the broker sends a RECEIPT frame with a matching
+	receipt-id, when the broker sends an ERROR frame with a matching receipt-id,
+	or when the connection fails.
+*/
+type Receipt struct {
+	id   string
+	rm   *receiptManager
+	done chan struct{}
+	md   MessageData // RECEIPT or ERROR frame, or read error
+	e    error
+	t0   time.Time // Registered
+}
+
+/*
+	Pending receipts, keyed by receipt-id.  Completed by the reader.
+*/
+type receiptManager struct {
+	lock sync.Mutex
+	pend map[string]*Receipt
+	lat  *latencies // Broker response times
+}
+
+func newReceiptManager() *receiptManager {
+	return &receiptManager{pend: make(map[string]*Receipt),
+		lat: newLatencies(ReceiptLatencyBuckets)}
+}
+
+/*
+	Id returns the receipt-id sent to the broker.
+*/
+func (r *Receipt) Id() string {
+	return r.id
+}
+
+/*
+	Done returns a channel that is closed when the Receipt completes.
+*/
+func (r *Receipt) Done() <-chan struct{} {
+	return r.done
+}
+
+/*
+	Wait blocks until the Receipt completes, or until d has elapsed.  A d <= 0
+	means wait with no time limit.
+
+	On success the RECEIPT frame is returned.  If the broker responds with an
+	ERROR frame, that frame is returned with ERCPTERR.  On timeout ERCPTTO is
+	returned, and any later RECEIPT frame for this id is handled as an
+	unsolicited frame.
+
+	Example:
+		r, e := c.SendWithReceipt(h, "My message")
+		if e != nil {
+			// Do something sane ...
+		}
+		md, e := r.Wait(5 * time.Second)
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+func (r *Receipt) Wait(d time.Duration) (MessageData, error) {
+	if d <= 0 {
+		return r.WaitContext(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	md, e := r.WaitContext(ctx)
+	if e == context.DeadlineExceeded {
+		e = ERCPTTO
+	}
+	return md, e
+}
+
+/*
+	WaitContext is like Wait, but ctx controls how long to wait.  If ctx is
+	done first, ctx.Err() is returned.
+*/
+func (r *Receipt) WaitContext(ctx context.Context) (MessageData, error) {
+	select {
+	case <-r.done:
+		return r.md, r.e
+	case <-ctx.Done():
+	}
+	r.rm.remove(r.id)
+	select {
+	case <-r.done: // Completed while giving up
+		return r.md, r.e
+	default:
+	}
+	return MessageData{}, ctx.Err()
+}
+
+/*
+	Register a new pending receipt.
+*/
+func (rm *receiptManager) add(id string) *Receipt {
+	r := &Receipt{id: id, rm: rm, done: make(chan struct{}), t0: time.Now()}
+	rm.lock.Lock()
+	rm.pend[id] = r
+	rm.lock.Unlock()
+	return r
+}
+
+/*
+	Forget a pending receipt.
+*/
+func (rm *receiptManager) remove(id string) {
+	rm.lock.Lock()
+	delete(rm.pend, id)
+	rm.lock.Unlock()
+}
+
+/*
+	Complete a pending receipt from a RECEIPT or ERROR frame.  Returns false
+	if no receipt is waiting for the frame.
+*/
+func (rm *receiptManager) complete(md MessageData) bool {
+	id, ok := md.Message.Headers.Contains(HK_RECEIPT_ID)
+	if !ok {
+		return false
+	}
+	rm.lock.Lock()
+	r, ok := rm.pend[id]
+	delete(rm.pend, id)
+	rm.lock.Unlock()
+	if !ok {
+		return false
+	}
+	rm.lat.observe(time.Since(r.t0))
+	r.md = md
+	if md.Message.Command == ERROR {
+		r.e = ERCPTERR
+	}
+	close(r.done)
+	return true
+}
+
+/*
+	Complete every pending receipt with a read error.
+*/
+func (rm *receiptManager) fail(md MessageData) {
+	rm.lock.Lock()
+	p := rm.pend
+	rm.pend = make(map[string]*Receipt)
+	rm.lock.Unlock()
+	for _, r := range p {
+		r.md = md
+		r.e = md.Error
+		if r.e == nil {
+			r.e = ECONBAD
+		}
+		close(r.done)
+	}
+}
+
+/*
+	Clone the headers, and add a receipt header unless the caller supplied
+	one.  The receipt is registered before the frame can be sent.
+*/
+func (c *Connection) expectReceipt(h Headers) (Headers, *Receipt) {
+	ch := h.Clone()
+	id, ok := ch.Contains(HK_RECEIPT)
+	if !ok {
+		id = Uuid()
+		ch = append(ch, HK_RECEIPT, id)
+	}
+	return ch, c.rcpm.add(id)
+}
+
+/*
+	SendWithReceipt sends a STOMP MESSAGE and requests a broker receipt.
+
+	A receipt header is generated unless the caller supplies one.  The
+	returned Receipt completes when the RECEIPT, or a correlated ERROR, is
+	received.  Such RECEIPT frames are not delivered to the Connection's
+	MessageData channel.
+
+	Example:
+		h := stompngo.Headers{stompngo.HK_DESTINATION, "/queue/mymessages"}
+		r, e := c.SendWithReceipt(h, "My message")
+		if e != nil {
+			// Do something sane ...
+		}
+		if _, e = r.Wait(5 * time.Second); e != nil {
+			// Do something sane ...
+		}
+*/
+func (c *Connection) SendWithReceipt(h Headers, b string) (*Receipt, error) {
+	return c.SendBytesWithReceipt(h, []byte(b))
+}
+
+/*
+	SendBytesWithReceipt is like SendWithReceipt, for a body that is a slice
+	of bytes.
+*/
+func (c *Connection) SendBytesWithReceipt(h Headers, b []byte) (*Receipt, error) {
+	ch, r := c.expectReceipt(h)
+	if e := c.SendBytes(ch, b); e != nil {
+		c.rcpm.remove(r.id)
+		return nil, e
+	}
+	return r, nil
+}
+
+/*
+	SubscribeWithReceipt is like Subscribe, and also requests a broker
+	receipt for the SUBSCRIBE frame.  The receipt header is not replayed if
+	the connection reconnects.
+*/
+func (c *Connection) SubscribeWithReceipt(h Headers) (<-chan MessageData, *Receipt, error) {
+	ch, r := c.expectReceipt(h)
+	s, e := c.Subscribe(ch)
+	if e != nil {
+		c.rcpm.remove(r.id)
+		return nil, nil, e
+	}
+	return s, r, nil
+}
+
+/*
+	WithReceipt sends an ACK, NACK, BEGIN, COMMIT, ABORT or UNSUBSCRIBE frame
+	with a broker receipt request.  The headers are those required by the
+	corresponding Connection method.
+
+	Example:
+		h := stompngo.Headers{stompngo.HK_TRANSACTION, "tx1"}
+		r, e := c.WithReceipt(stompngo.COMMIT, h)
+		if e != nil {
+			// Do something sane ...
+		}
+		if _, e = r.Wait(5 * time.Second); e != nil {
+			// Do something sane ...
+		}
+*/
+func (c *Connection) WithReceipt(cmd string, h Headers) (*Receipt, error) {
+	var f func(Headers) error
+	switch cmd {
+	case ACK:
+		f = c.Ack
+	case NACK:
+		f = c.Nack
+	case BEGIN:
+		f = c.Begin
+	case COMMIT:
+		f = c.Commit
+	case ABORT:
+		f = c.Abort
+	case UNSUBSCRIBE:
+		f = c.Unsubscribe
+	default:
+		return nil, ERCPTCMD
+	}
+	ch, r := c.expectReceipt(h)
+	if e := f(ch); e != nil {
+		c.rcpm.remove(r.id)
+		return nil, e
+	}
+	return r, nil
+}
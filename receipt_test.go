@@ -0,0 +1,120 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Test receipts for SEND and other frames.
+*/
+func TestReceiptSend(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	n, _ := b.dial()
+	defer n.Close()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestReceiptSend CONNECT expected nil, got %v\n", e)
+	}
+	s := b.accept(t)
+	r, e := c.SendWithReceipt(Headers{HK_DESTINATION, "/queue/receipt.send"},
+		"payload")
+	if e != nil {
+		t.Fatalf("TestReceiptSend SEND expected nil, got %v\n", e)
+	}
+	md, e := r.Wait(5 * time.Second)
+	if e != nil {
+		t.Fatalf("TestReceiptSend Wait expected nil, got %v\n", e)
+	}
+	if md.Message.Headers.Value(HK_RECEIPT_ID) != r.Id() {
+		t.Fatalf("TestReceiptSend expected receipt-id %s, got %v\n", r.Id(), md)
+	}
+	if f := s.next(t); f.Headers.Value(HK_RECEIPT) != r.Id() {
+		t.Fatalf("TestReceiptSend expected receipt header %s, got %v\n",
+			r.Id(), f.Headers)
+	}
+	// Caller supplied receipt id
+	r, e = c.WithReceipt(BEGIN, Headers{HK_TRANSACTION, "tx1",
+		HK_RECEIPT, "begin-tx1"})
+	if e != nil {
+		t.Fatalf("TestReceiptSend BEGIN expected nil, got %v\n", e)
+	}
+	if _, e = r.Wait(5 * time.Second); e != nil || r.Id() != "begin-tx1" {
+		t.Fatalf("TestReceiptSend BEGIN Wait expected nil, got %v %s\n", e, r.Id())
+	}
+	if _, e = c.WithReceipt(SEND, Headers{}); e != ERCPTCMD {
+		t.Fatalf("TestReceiptSend expected [%v], got [%v]\n", ERCPTCMD, e)
+	}
+	// Receipts must not be seen on the general channel
+	select {
+	case md = <-c.MessageData:
+		t.Fatalf("TestReceiptSend unexpected MessageData %v\n", md)
+	default:
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test a receipt completed by a correlated ERROR frame, and a receipt
+	timeout.
+*/
+func TestReceiptErrorTimeout(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	b.withoutReceipts()
+	n, _ := b.dial()
+	defer n.Close()
+	c, e := Connect(n, Headers{})
+	if e != nil {
+		t.Fatalf("TestReceiptErrorTimeout CONNECT expected nil, got %v\n", e)
+	}
+	s := b.accept(t)
+	sh := Headers{HK_DESTINATION, "/queue/receipt.error"}
+	r, e := c.SendWithReceipt(sh, "bad")
+	if e != nil {
+		t.Fatalf("TestReceiptErrorTimeout SEND expected nil, got %v\n", e)
+	}
+	_ = s.next(t)
+	s.send(ERROR, Headers{HK_RECEIPT_ID, r.Id(), "message", "rejected"}, "")
+	md, e := r.Wait(5 * time.Second)
+	if e != ERCPTERR || md.Message.Command != ERROR {
+		t.Fatalf("TestReceiptErrorTimeout expected [%v], got [%v] %v\n",
+			ERCPTERR, e, md)
+	}
+	r, e = c.SendWithReceipt(sh, "lost")
+	if e != nil {
+		t.Fatalf("TestReceiptErrorTimeout SEND expected nil, got %v\n", e)
+	}
+	if _, e = r.Wait(50 * time.Millisecond); e != ERCPTTO {
+		t.Fatalf("TestReceiptErrorTimeout expected [%v], got [%v]\n", ERCPTTO, e)
+	}
+	// Pending receipts fail when the connection is lost
+	r, e = c.SendWithReceipt(sh, "closed")
+	if e != nil {
+		t.Fatalf("TestReceiptErrorTimeout SEND expected nil, got %v\n", e)
+	}
+	_ = s.next(t)
+	_ = s.next(t)
+	s.close()
+	if _, e = r.Wait(5 * time.Second); e == nil || e == ERCPTTO {
+		t.Fatalf("TestReceiptErrorTimeout expected read error, got %v\n", e)
+	}
+}
@@ -0,0 +1,462 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	ConnState describes the state of a connection.
+*/
+type ConnState int
+
+/*
+	Connection states.  A ReconnectPolicy OnStateChange callback is given
+	StateConnected, StateReconnecting and StateClosed.  All states are
+	reported as Events.
+*/
+const (
+	StateUnknown       ConnState = iota // Zero value, never reported
+	StateConnected                      // CONNECTED received, subscriptions replayed
+	StateReconnecting                   // Connection lost, reconnect attempt starting
+	StateClosed                         // Connection shut down
+	StateConnecting                     // CONNECT about to be sent
+	StateDegraded                       // Heart beat send or receive failing
+	StateDisconnecting                  // DISCONNECT started
+)
+
+/*
+	String makes ConnState a Stringer.
+*/
+func (s ConnState) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	case StateConnecting:
+		return "connecting"
+	case StateDegraded:
+		return "degraded"
+	case StateDisconnecting:
+		return "disconnecting"
+	}
+	return "unknown"
+}
+
+/*
+	Backoff returns the wait time before reconnect attempt number n.  The
+	first attempt is number 1.
+*/
+type Backoff func(n int) time.Duration
+
+/*
+	ExponentialBackoff returns a Backoff that starts at min, doubles on each
+	attempt, and never exceeds max.
+*/
+func ExponentialBackoff(min, max time.Duration) Backoff {
+	return func(n int) time.Duration {
+		d := min
+		for i := 1; i < n && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+/*
+	ReconnectPolicy controls automatic reconnection.
+
+	Exactly one of Dial and DialWS should be supplied.  The function is
+	called once for the initial connection and once for each reconnect
+	attempt.
+*/
+type ReconnectPolicy struct {
+	Dial          func() (net.Conn, error)        // TCP transport
+	DialWS        func() (*websocket.Conn, error) // WebSocket transport
+	Backoff       Backoff                         // Wait before each attempt, default 1s doubling to 30s
+	MaxAttempts   int                             // Attempts per outage, <= 0 means no limit
+	OnStateChange func(s ConnState, e error)      // Optional state change callback
+}
+
+/*
+	Reconnect control data.
+*/
+type reconnectData struct {
+	p      ReconnectPolicy
+	lock   sync.Mutex
+	fo     *Failover
+	active bool  // Reconnect in progress
+	stop   bool  // Never reconnect again (DISCONNECT started)
+	count  int64 // Successful reconnects
+}
+
+/*
+	ConnectWithReconnect creates a Connection that reconnects automatically.
+
+	When the reader detects a network error the connection is re-dialed using
+	the policy, CONNECT is sent again with the original headers, and every
+	active subscription is re-established with its original SUBSCRIBE headers
+	(including the subscription id).  MessageData channels returned by
+	Subscribe remain valid across reconnects.
+
+	The Connection owns the network connections it dials, and closes them
+	on Disconnect.
+
+	Example:
+		p := &stompngo.ReconnectPolicy{
+			Dial: func() (net.Conn, error) {
+				return net.Dial(stompngo.NetProtoTCP, "localhost:61613")
+			},
+			MaxAttempts: 10,
+		}
+		h := stompngo.Headers{stompngo.HK_ACCEPT_VERSION, "1.2",
+			stompngo.HK_HOST, "localhost"}
+		c, e := stompngo.ConnectWithReconnect(h, p)
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+func ConnectWithReconnect(h Headers, p *ReconnectPolicy) (*Connection, error) {
+	if p == nil || (p.Dial == nil && p.DialWS == nil) {
+		return nil, ENODIAL
+	}
+	return connectPolicy(h, *p, nil)
+}
+
+/*
+	Common connect logic for reconnecting connections.  With a Failover, each
+	endpoint is tried once before giving up.
+*/
+func connectPolicy(h Headers, p ReconnectPolicy, fo *Failover) (*Connection, error) {
+	ch, e := checkConnectHeaders(h)
+	if e != nil {
+		return nil, e
+	}
+	c := newConnection()
+	c.rcd = &reconnectData{p: p, fo: fo}
+	if c.rcd.p.Backoff == nil {
+		c.rcd.p.Backoff = ExponentialBackoff(time.Second, 30*time.Second)
+	}
+	if e := c.checkClientVersions(h); e != nil {
+		c.stateEvent(StateClosed, e)
+		return c, e
+	}
+	c.connectHeaders = ch
+	c.rcd.lock.Lock()
+	c.rcd.active = true // A failed attempt must not shut the system down
+	c.rcd.lock.Unlock()
+	e = c.attemptConnect(nil)
+	c.rcd.lock.Lock()
+	c.rcd.active = false
+	c.rcd.lock.Unlock()
+	if e != nil {
+		c.sysAbort()
+		c.stateEvent(StateClosed, e)
+		return c, e
+	}
+	c.stateEvent(StateConnected, nil)
+	go c.reader()
+	return c, nil
+}
+
+/*
+	Reconnects returns the number of successful reconnects performed.
+*/
+func (c *Connection) Reconnects() int64 {
+	if c.rcd == nil {
+		return 0
+	}
+	c.rcd.lock.Lock()
+	defer c.rcd.lock.Unlock()
+	return c.rcd.count
+}
+
+/*
+	Reconnect logic, run by the reader after a read error.
+
+	Returns true if a new session is established, and the reader should
+	continue.
+*/
+func (c *Connection) reconnect(md MessageData) bool {
+	if !c.wantReconnect() {
+		return false
+	}
+	c.rcd.lock.Lock()
+	c.rcd.active = true
+	c.rcd.lock.Unlock()
+	c.log(LogLifecycle, LevelWarn, "RECONNECT starts", "error", md.Error)
+	c.stopTransport()
+	c.stateChange(StateReconnecting, md.Error)
+	//
+	subs := c.replaySubs()
+	p := &c.rcd.p
+	for n := 1; p.MaxAttempts <= 0 || n <= p.MaxAttempts; n++ {
+		t := time.NewTimer(p.Backoff(n))
+		select {
+		case <-t.C:
+		case <-c.ssdc:
+			t.Stop()
+			c.log(LogLifecycle, LevelInfo, "RECONNECT aborted")
+			return c.reconnectEnd(md, false)
+		}
+		if !c.wantReconnect() {
+			return c.reconnectEnd(md, false)
+		}
+		e := c.attemptConnect(subs)
+		if e == nil {
+			c.rcd.lock.Lock()
+			c.rcd.active = false
+			c.rcd.count++
+			c.rcd.lock.Unlock()
+			c.log(LogLifecycle, LevelInfo, "RECONNECT complete", "attempt", n)
+			c.stateChange(StateConnected, nil)
+			return true
+		}
+		c.log(LogLifecycle, LevelWarn, "RECONNECT attempt failed", "attempt", n, "error", e)
+		c.stateChange(StateReconnecting, e)
+	}
+	c.log(LogLifecycle, LevelError, "RECONNECT attempts exhausted")
+	return c.reconnectEnd(md, true)
+}
+
+/*
+	Finish an unsuccessful reconnect.  Subscribers are told about the
+	original error unless the client asked for the shutdown.
+*/
+func (c *Connection) reconnectEnd(md MessageData, ns bool) bool {
+	if ns {
+		c.subsLock.RLock()
+		for _, ps := range c.subs {
+			c.deliverError(ps, md)
+		}
+		c.subsLock.RUnlock()
+	}
+	c.rcd.lock.Lock()
+	c.rcd.active = false
+	c.rcd.stop = true
+	c.rcd.lock.Unlock()
+	c.stateChange(StateClosed, md.Error)
+	return false
+}
+
+/*
+	Check if a reconnect should be attempted.
+*/
+func (c *Connection) wantReconnect() bool {
+	if c.rcd == nil {
+		return false
+	}
+	select {
+	case <-c.ssdc:
+		return false
+	default:
+	}
+	c.rcd.lock.Lock()
+	defer c.rcd.lock.Unlock()
+	return !c.rcd.stop
+}
+
+/*
+	Check if a reconnect is in progress.
+*/
+func (c *Connection) isReconnecting() bool {
+	if c.rcd == nil {
+		return false
+	}
+	c.rcd.lock.Lock()
+	defer c.rcd.lock.Unlock()
+	return c.rcd.active
+}
+
+/*
+	Prevent any future reconnect.  Used when DISCONNECT starts.
+*/
+func (c *Connection) stopReconnect() {
+	if c.rcd == nil {
+		return
+	}
+	c.rcd.lock.Lock()
+	c.rcd.stop = true
+	c.rcd.lock.Unlock()
+}
+
+/*
+	Stop the writer and heartbeats for the current transport, and close it.
+	Fresh writer channels are made ready for the next transport.
+*/
+func (c *Connection) stopTransport() {
+	c.setConnected(false)
+	c.shutdownHeartBeats()
+	close(c.wtrsdc)
+	<-c.wtrdc
+	c.dropTransport()
+	c.wtrsdc = make(chan struct{})
+	c.wtrdc = make(chan struct{})
+}
+
+/*
+	Stop heartbeats for a transport whose writer was never started, and
+	close it.
+*/
+func (c *Connection) dropTransport() {
+	c.shutdownHeartBeats()
+	c.closeTransport()
+	c.setHeartBeatData(nil)
+}
+
+/*
+	Dial, CONNECT, and replay any subscriptions, then start the writer.
+	Client frames queued during the outage follow the replayed SUBSCRIBE
+	frames.  On failure the transport is dropped.  With a Failover, each
+	endpoint is tried once, in order.
+*/
+func (c *Connection) attemptConnect(subs []Headers) error {
+	c.stateEvent(StateConnecting, nil)
+	if c.rcd.fo != nil {
+		return c.rcd.fo.connect(c, subs)
+	}
+	e := c.dialTransport()
+	if e != nil {
+		return e
+	}
+	return c.connectTransport(subs)
+}
+
+/*
+	CONNECT and replay any subscriptions on a new transport, then start the
+	writer.
+*/
+func (c *Connection) connectTransport(subs []Headers) error {
+	e := c.connectWire(c.connectHeaders)
+	if e == nil {
+		e = c.replaySubscribes(subs)
+	}
+	if c.rcd.fo != nil {
+		c.rcd.fo.report(e)
+	}
+	if e != nil {
+		c.dropTransport()
+		return e
+	}
+	c.startSession()
+	return nil
+}
+
+/*
+	Dial a new transport using the reconnect policy.
+*/
+func (c *Connection) dialTransport() error {
+	if c.rcd.p.DialWS != nil {
+		w, e := c.rcd.p.DialWS()
+		if e != nil {
+			return e
+		}
+		c.setTransport(nil, w, "")
+		return nil
+	}
+	n, e := c.rcd.p.Dial()
+	if e != nil {
+		return e
+	}
+	c.setTransport(n, nil, "")
+	return nil
+}
+
+/*
+	Set the current transport, and the broker description reported by
+	Broker().  The remote address is used if no description is given.
+*/
+func (c *Connection) setTransport(n net.Conn, w *websocket.Conn, b string) {
+	if b == "" {
+		if n != nil && n.RemoteAddr() != nil {
+			b = n.RemoteAddr().String()
+		} else if w != nil {
+			b = w.RemoteAddr().String()
+		}
+	}
+	c.netconn = n
+	c.wsConn = w
+	c.connLock.Lock()
+	c.broker = b
+	c.connLock.Unlock()
+}
+
+/*
+	Close the current transport.
+*/
+func (c *Connection) closeTransport() {
+	if c.wsConn != nil {
+		_ = c.wsConn.Close()
+		return
+	}
+	if c.netconn != nil {
+		_ = c.netconn.Close()
+	}
+}
+
+/*
+	Snapshot the SUBSCRIBE headers of all active subscriptions.
+*/
+func (c *Connection) replaySubs() []Headers {
+	c.subsLock.RLock()
+	defer c.subsLock.RUnlock()
+	r := make([]Headers, 0, len(c.subs))
+	for _, s := range c.subs {
+		if s.cs || s.hdrs == nil {
+			continue
+		}
+		r = append(r, s.hdrs.Delete(HK_RECEIPT))
+	}
+	return r
+}
+
+/*
+	Send SUBSCRIBE frames for a set of previously active subscriptions,
+	before the writer is started.
+*/
+func (c *Connection) replaySubscribes(subs []Headers) error {
+	for _, h := range subs {
+		c.log(LogLifecycle, LevelDebug, "RECONNECT resubscribe", "headers", h)
+		if e := c.writeDirect(Frame{SUBSCRIBE, h.Clone(), NULLBUFF}); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+/*
+	Queue a state change event, and call the state change callback if one
+	is set.
+*/
+func (c *Connection) stateChange(s ConnState, e error) {
+	c.stateEvent(s, e)
+	if c.rcd == nil || c.rcd.p.OnStateChange == nil {
+		return
+	}
+	c.rcd.p.OnStateChange(s, e)
+}
@@ -0,0 +1,243 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+	Test reconnect with subscription replay.
+*/
+func TestReconnectReplay(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	states := make(chan ConnState, 16)
+	p := &ReconnectPolicy{Dial: b.dial,
+		Backoff:       ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond),
+		OnStateChange: func(s ConnState, e error) { states <- s },
+	}
+	ch := Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"}
+	c, e := ConnectWithReconnect(ch, p)
+	if e != nil {
+		t.Fatalf("TestReconnectReplay CONNECT expected nil, got %v\n", e)
+	}
+	s1 := b.accept(t)
+	sh := Headers{HK_DESTINATION, "/queue/reconnect.replay", HK_ID, "rcsub1"}
+	sc, e := c.Subscribe(sh)
+	if e != nil {
+		t.Fatalf("TestReconnectReplay SUBSCRIBE expected nil, got %v\n", e)
+	}
+	if f := s1.next(t); f.Command != SUBSCRIBE {
+		t.Fatalf("TestReconnectReplay expected SUBSCRIBE, got %s\n", f.Command)
+	}
+	s1.send(MESSAGE, Headers{HK_SUBSCRIPTION, "rcsub1", HK_MESSAGE_ID, "m1"},
+		"first")
+	if md := <-sc; md.Error != nil || md.Message.BodyString() != "first" {
+		t.Fatalf("TestReconnectReplay expected first, got %v\n", md)
+	}
+	// Lose the connection
+	s1.close()
+	s2 := b.accept(t)
+	f := s2.next(t)
+	if f.Command != SUBSCRIBE || f.Headers.Value(HK_ID) != "rcsub1" {
+		t.Fatalf("TestReconnectReplay expected replayed SUBSCRIBE, got %v\n", f)
+	}
+	s2.send(MESSAGE, Headers{HK_SUBSCRIPTION, "rcsub1", HK_MESSAGE_ID, "m2"},
+		"second")
+	if md := <-sc; md.Error != nil || md.Message.BodyString() != "second" {
+		t.Fatalf("TestReconnectReplay expected second, got %v\n", md)
+	}
+	if c.Reconnects() != 1 {
+		t.Fatalf("TestReconnectReplay expected 1 reconnect, got %d\n",
+			c.Reconnects())
+	}
+	for _, w := range []ConnState{StateReconnecting, StateConnected} {
+		if s := <-states; s != w {
+			t.Fatalf("TestReconnectReplay expected state %v, got %v\n", w, s)
+		}
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+	for _, w := range []ConnState{StateConnecting, StateConnected,
+		StateReconnecting, StateConnecting, StateConnected, StateDisconnecting,
+		StateClosed} {
+		checkStateEvent(t, c, w, w == StateReconnecting)
+	}
+	checkEventsClosed(t, c)
+}
+
+/*
+	Test a frame blocked across a reconnect follows CONNECT and the replayed
+	SUBSCRIBE frames.
+*/
+func TestReconnectBlockedSend(t *testing.T) {
+	b := newFakeBroker(t)
+	defer b.close()
+	states := make(chan ConnState, 16)
+	p := &ReconnectPolicy{Dial: b.dial,
+		Backoff:       func(n int) time.Duration { return 100 * time.Millisecond },
+		OnStateChange: func(s ConnState, e error) { states <- s },
+	}
+	c, e := ConnectWithReconnect(Headers{HK_ACCEPT_VERSION, SPL_12,
+		HK_HOST, "localhost"}, p)
+	if e != nil {
+		t.Fatalf("TestReconnectBlockedSend CONNECT expected nil, got %v\n", e)
+	}
+	s1 := b.accept(t)
+	sh := Headers{HK_DESTINATION, "/queue/reconnect.blocked", HK_ID, "rbsub1"}
+	if _, e = c.Subscribe(sh); e != nil {
+		t.Fatalf("TestReconnectBlockedSend SUBSCRIBE expected nil, got %v\n", e)
+	}
+	_ = s1.next(t) // SUBSCRIBE
+	s1.close()
+	if s := <-states; s != StateReconnecting {
+		t.Fatalf("TestReconnectBlockedSend expected %v, got %v\n", StateReconnecting, s)
+	}
+	// As a Send that passed its connected check before the outage
+	r := make(chan error, 1)
+	go func() {
+		r <- c.transmitContext(context.Background(), Frame{SEND,
+			Headers{HK_DESTINATION, "/queue/reconnect.blocked"}, []byte("blocked")})
+	}()
+	s2 := b.accept(t)
+	for _, w := range []string{SUBSCRIBE, SEND} {
+		if f := s2.next(t); f.Command != w {
+			t.Fatalf("TestReconnectBlockedSend expected %s, got %s\n", w, f.Command)
+		}
+	}
+	if e = <-r; e != nil {
+		t.Fatalf("TestReconnectBlockedSend SEND expected nil, got %v\n", e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test reconnect gives up after MaxAttempts.
+*/
+func TestReconnectMaxAttempts(t *testing.T) {
+	b := newFakeBroker(t)
+	dials := 0
+	p := &ReconnectPolicy{
+		Dial: func() (net.Conn, error) {
+			dials++
+			return b.dial()
+		},
+		Backoff:     func(n int) time.Duration { return time.Millisecond },
+		MaxAttempts: 2,
+	}
+	c, e := ConnectWithReconnect(Headers{}, p)
+	if e != nil {
+		t.Fatalf("TestReconnectMaxAttempts CONNECT expected nil, got %v\n", e)
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/reconnect.max"})
+	if e != nil {
+		t.Fatalf("TestReconnectMaxAttempts SUBSCRIBE expected nil, got %v\n", e)
+	}
+	s1 := b.accept(t)
+	_ = s1.next(t)
+	b.close() // No more brokers
+	s1.close()
+	select {
+	case md := <-sc:
+		if md.Error == nil {
+			t.Fatalf("TestReconnectMaxAttempts expected error, got nil\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestReconnectMaxAttempts no error delivered\n")
+	}
+	if dials != 3 {
+		t.Fatalf("TestReconnectMaxAttempts expected 3 dials, got %d\n", dials)
+	}
+	if c.Connected() {
+		t.Fatalf("TestReconnectMaxAttempts expected not connected\n")
+	}
+}
+
+/*
+	Test giving up with a full subscription channel does not hang the
+	reader.
+*/
+func TestReconnectEndFullChannel(t *testing.T) {
+	b := newFakeBroker(t)
+	p := &ReconnectPolicy{Dial: b.dial,
+		Backoff:     func(n int) time.Duration { return time.Millisecond },
+		MaxAttempts: 1,
+	}
+	c, e := ConnectWithReconnect(Headers{}, p)
+	if e != nil {
+		t.Fatalf("TestReconnectEndFullChannel CONNECT expected nil, got %v\n", e)
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/reconnect.full",
+		HK_ID, "rfsub1"})
+	if e != nil {
+		t.Fatalf("TestReconnectEndFullChannel SUBSCRIBE expected nil, got %v\n", e)
+	}
+	s1 := b.accept(t)
+	_ = s1.next(t)
+	s1.send(MESSAGE, Headers{HK_SUBSCRIPTION, "rfsub1", HK_MESSAGE_ID, "m1"}, "full")
+	b.close() // No more brokers
+	s1.close()
+	select {
+	case _, ok := <-c.MessageData:
+		for ok {
+			_, ok = <-c.MessageData
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestReconnectEndFullChannel reader did not finish\n")
+	}
+	if md := <-sc; md.Error == nil {
+		t.Fatalf("TestReconnectEndFullChannel expected error, got %v\n", md.Message)
+	}
+	if s := c.Stats().Subscriptions["rfsub1"]; s.Dropped != 1 {
+		t.Fatalf("TestReconnectEndFullChannel expected 1 drop, got %d\n", s.Dropped)
+	}
+	if e = c.Disconnect(empty_headers); e != ECONBAD {
+		t.Fatalf("TestReconnectEndFullChannel DISCONNECT expected %v, got %v\n", ECONBAD, e)
+	}
+}
+
+/*
+	Test the default backoff policy.
+*/
+func TestReconnectBackoff(t *testing.T) {
+	bo := ExponentialBackoff(time.Second, 5*time.Second)
+	for n, w := range []time.Duration{time.Second, 2 * time.Second,
+		4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if d := bo(n + 1); d != w {
+			t.Fatalf("TestReconnectBackoff attempt %d expected %v, got %v\n",
+				n+1, w, d)
+		}
+	}
+}
+
+/*
+	Test a zero ConnState is not a real state.
+*/
+func TestReconnectStateZero(t *testing.T) {
+	var s ConnState
+	if s != StateUnknown || s == StateConnected || s.String() != "unknown" {
+		t.Fatalf("TestReconnectStateZero expected unknown, got %v\n", s)
+	}
+	if StateConnected.String() != "connected" {
+		t.Fatalf("TestReconnectStateZero expected connected, got %v\n", StateConnected)
+	}
+}
@@ -0,0 +1,252 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+	Requestor sends request messages and waits for the correlated replies.
+	All replies arrive on one subscription to a private reply destination.
+	A Requestor is safe for concurrent use.
+*/
+type Requestor struct {
+	c    STOMPConnector
+	dest string // Reply destination
+	sid  string // Reply subscription id
+	lock sync.Mutex
+	pend map[string]chan MessageData // By correlation-id
+	err  error                       // Set when replies can no longer arrive
+	quit chan struct{}
+	once sync.Once
+}
+
+/*
+	NewRequestor subscribes to the reply destination replyTo.  An empty
+	replyTo means a generated "/temp-queue/" destination, which ActiveMQ,
+	Artemis and RabbitMQ all treat as a private temporary queue.
+
+	Example:
+		r, e := stompngo.NewRequestor(c, "")
+		if e != nil {
+			// Do something sane ...
+		}
+		defer r.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m, e := r.Request(ctx, stompngo.Headers{stompngo.HK_DESTINATION,
+			"/queue/service"}, []byte("request"))
+		if e != nil {
+			// Do something sane ...
+		}
+		fmt.Printf("Reply: %s\n", m.BodyString())
+*/
+func NewRequestor(c STOMPConnector, replyTo string) (*Requestor, error) {
+	if replyTo == "" {
+		replyTo = "/temp-queue/" + Uuid()
+	}
+	r := &Requestor{c: c, dest: replyTo, sid: Uuid(),
+		pend: make(map[string]chan MessageData), quit: make(chan struct{})}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, r.dest, HK_ID, r.sid})
+	if e != nil {
+		return nil, e
+	}
+	go r.dispatch(sc)
+	return r, nil
+}
+
+/*
+	ReplyTo returns the reply destination.
+*/
+func (r *Requestor) ReplyTo() string {
+	return r.dest
+}
+
+/*
+	Request sends a message with reply-to and correlation-id headers, and
+	waits for the reply MESSAGE with the same correlation-id.  Any caller
+	reply-to or correlation-id header is replaced.  ctx limits the wait.  A
+	reply that arrives after Request returns is discarded.  A reply received
+	with an error, such as ELIMBODY, fails only its own request.
+*/
+func (r *Requestor) Request(ctx context.Context, h Headers, b []byte) (Message, error) {
+	id := Uuid()
+	rc := make(chan MessageData, 1)
+	r.lock.Lock()
+	if r.err != nil {
+		r.lock.Unlock()
+		return Message{}, r.err
+	}
+	r.pend[id] = rc
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		delete(r.pend, id)
+		r.lock.Unlock()
+	}()
+	sh := h.Delete(HK_REPLY_TO).Delete(HK_CORRELATION_ID)
+	sh = sh.Add(HK_REPLY_TO, r.dest).Add(HK_CORRELATION_ID, id)
+	var e error
+	if cs, ok := r.c.(ContextStomper); ok {
+		e = cs.SendBytesContext(ctx, sh, b)
+	} else {
+		e = r.c.SendBytes(sh, b)
+	}
+	if e != nil {
+		return Message{}, e
+	}
+	select {
+	case md := <-rc:
+		return md.Message, md.Error
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+/*
+	Close unsubscribes from the reply destination.  Pending and later
+	requests fail with ECONBAD.
+*/
+func (r *Requestor) Close() error {
+	r.fail(ECONBAD)
+	// Keep dispatch reading until the subscription is deleted, or a full
+	// channel blocks the reader
+	e := r.c.Unsubscribe(Headers{HK_DESTINATION, r.dest, HK_ID, r.sid})
+	r.once.Do(func() { close(r.quit) })
+	return e
+}
+
+/*
+	Hand replies to the waiting requests.
+*/
+func (r *Requestor) dispatch(sc <-chan MessageData) {
+	for {
+		select {
+		case md, ok := <-sc:
+			if !ok {
+				r.fail(ECONBAD) // Connection shutdown
+				return
+			}
+			if connectionError(md) {
+				r.fail(md.Error)
+				continue
+			}
+			// A MESSAGE error, such as a bad compressed body, goes to its
+			// request only
+			id := md.Message.Headers.Value(HK_CORRELATION_ID)
+			r.lock.Lock()
+			rc, ok := r.pend[id]
+			delete(r.pend, id)
+			r.lock.Unlock()
+			if ok {
+				rc <- md
+			}
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+/*
+	Whether md reports an error for the connection, rather than for one
+	received MESSAGE.
+*/
+func connectionError(md MessageData) bool {
+	if md.Error == nil {
+		return false
+	}
+	if _, ok := md.Message.Headers.Contains("connection_read_error"); ok {
+		return true
+	}
+	return md.Message.Command != MESSAGE
+}
+
+/*
+	Fail all pending requests, and any later ones.
+*/
+func (r *Requestor) fail(e error) {
+	r.lock.Lock()
+	if r.err == nil {
+		r.err = e
+	}
+	for id, rc := range r.pend {
+		rc <- MessageData{Message{}, e}
+		delete(r.pend, id)
+	}
+	r.lock.Unlock()
+}
+
+/*
+	Responder sends replies to request messages.
+*/
+type Responder struct {
+	c STOMPConnector
+}
+
+/*
+	NewResponder returns a Responder that replies on c.
+
+	Example:
+		rs := stompngo.NewResponder(c)
+		hf := rs.Handler(func(md stompngo.MessageData) (stompngo.Headers, []byte, error) {
+			return stompngo.Headers{}, process(md.Message.Body), nil
+		})
+		s, e := c.SubscribeFunc(stompngo.Headers{stompngo.HK_DESTINATION,
+			"/queue/service"}, hf, nil)
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+func NewResponder(c STOMPConnector) *Responder {
+	return &Responder{c: c}
+}
+
+/*
+	Reply sends a reply to the request's reply-to destination.  The request
+	correlation-id, if any, is copied to the reply.
+*/
+func (rs *Responder) Reply(req MessageData, h Headers, b []byte) error {
+	d, ok := req.Message.Headers.Contains(HK_REPLY_TO)
+	if !ok || d == "" {
+		return ENOREPLY
+	}
+	rh := h.Delete(HK_DESTINATION).Delete(HK_CORRELATION_ID).Add(HK_DESTINATION, d)
+	if id, ok := req.Message.Headers.Contains(HK_CORRELATION_ID); ok {
+		rh = rh.Add(HK_CORRELATION_ID, id)
+	}
+	return rs.c.SendBytes(rh, b)
+}
+
+/*
+	Handler adapts a reply function for SubscribeFunc.  The reply returned
+	by f is sent with Reply.  An error from f, or from Reply, is returned to
+	SubscribeFunc, and no reply is sent.
+*/
+func (rs *Responder) Handler(f func(MessageData) (Headers, []byte, error)) func(MessageData) error {
+	return func(md MessageData) error {
+		if md.Error != nil {
+			return md.Error
+		}
+		h, b, e := f(md)
+		if e != nil {
+			return e
+		}
+		return rs.Reply(md, h, b)
+	}
+}
@@ -0,0 +1,164 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+	Test concurrent requests through a Responder.
+*/
+func TestRPCRequestReply(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	rs := NewResponder(c)
+	hf := rs.Handler(func(md MessageData) (Headers, []byte, error) {
+		return Headers{"x-reply", "true"},
+			[]byte(strings.ToUpper(md.Message.BodyString())), nil
+	})
+	s, e := c.SubscribeFunc(Headers{HK_DESTINATION, "/queue/rpc"}, hf,
+		&SubscribeOptions{Workers: 3})
+	if e != nil {
+		t.Fatalf("TestRPCRequestReply SubscribeFunc expected nil, got %v\n", e)
+	}
+	r, e := NewRequestor(c, "")
+	if e != nil {
+		t.Fatalf("TestRPCRequestReply NewRequestor expected nil, got %v\n", e)
+	}
+	if !strings.HasPrefix(r.ReplyTo(), "/temp-queue/") {
+		t.Fatalf("TestRPCRequestReply bad reply-to %s\n", r.ReplyTo())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := "request " + strconv.Itoa(i)
+			m, e := r.Request(ctx, Headers{HK_DESTINATION, "/queue/rpc"}, []byte(q))
+			if e != nil {
+				t.Errorf("TestRPCRequestReply Request expected nil, got %v\n", e)
+				return
+			}
+			if m.BodyString() != strings.ToUpper(q) ||
+				m.Headers.Value("x-reply") != "true" {
+				t.Errorf("TestRPCRequestReply bad reply %v\n", m)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if e = s.Unsubscribe(); e != nil {
+		t.Fatalf("TestRPCRequestReply Unsubscribe expected nil, got %v\n", e)
+	}
+	if e = r.Close(); e != nil {
+		t.Fatalf("TestRPCRequestReply Close expected nil, got %v\n", e)
+	}
+	if _, e = r.Request(ctx, Headers{HK_DESTINATION, "/queue/rpc"}, nil); e != ECONBAD {
+		t.Fatalf("TestRPCRequestReply expected [%v], got [%v]\n", ECONBAD, e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test request timeout, and Reply without reply-to.
+*/
+func TestRPCErrors(t *testing.T) {
+	b, c := connectStomptest(t, SPL_11)
+	defer b.Close()
+	r, e := NewRequestor(c, "/queue/replies")
+	if e != nil {
+		t.Fatalf("TestRPCErrors NewRequestor expected nil, got %v\n", e)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, e = r.Request(ctx, Headers{HK_DESTINATION, "/queue/nobody"}, []byte("q"))
+	if e != context.DeadlineExceeded {
+		t.Fatalf("TestRPCErrors expected [%v], got [%v]\n", context.DeadlineExceeded, e)
+	}
+	md := MessageData{Message{MESSAGE, Headers{}, NULLBUFF}, nil}
+	if e = NewResponder(c).Reply(md, Headers{}, nil); e != ENOREPLY {
+		t.Fatalf("TestRPCErrors expected [%v], got [%v]\n", ENOREPLY, e)
+	}
+	// Shutdown fails later requests
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+	if _, e = r.Request(context.Background(), Headers{HK_DESTINATION, "/queue/x"}, nil); e != ECONBAD {
+		t.Fatalf("TestRPCErrors expected [%v], got [%v]\n", ECONBAD, e)
+	}
+}
+
+/*
+	Test a bad reply fails only its own request.
+*/
+func TestRPCMessageError(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestRPCMessageError CONNECT expected nil, got %v\n", e)
+	}
+	go s.run()
+	rc := make(chan error, 1)
+	go func() {
+		r, e := NewRequestor(c, "/queue/rpc.replies")
+		if e != nil {
+			rc <- e
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, e = r.Request(ctx, Headers{HK_DESTINATION, "/queue/rpc"}, nil); e == nil {
+			rc <- EBADFRM
+			return
+		}
+		m, e := r.Request(ctx, Headers{HK_DESTINATION, "/queue/rpc"}, nil)
+		if e == nil && m.BodyString() != "ok" {
+			e = EBADFRM
+		}
+		rc <- e
+	}()
+	sid := s.next(t).Headers.Value(HK_ID) // SUBSCRIBE
+	reply := func(id string, h Headers, b string) {
+		h = append(Headers{HK_SUBSCRIPTION, sid, HK_MESSAGE_ID, Uuid(),
+			HK_DESTINATION, "/queue/rpc.replies", HK_CORRELATION_ID, id}, h...)
+		s.send(MESSAGE, h, b)
+	}
+	id := s.next(t).Headers.Value(HK_CORRELATION_ID)
+	// Not a request of this Requestor, dropped
+	go reply("unknown", Headers{HK_CONTENT_ENCODING, "gzip"}, "not gzip")
+	go reply(id, Headers{HK_CONTENT_ENCODING, "gzip"}, "not gzip")
+	id = s.next(t).Headers.Value(HK_CORRELATION_ID)
+	go reply(id, Headers{}, "ok")
+	select {
+	case e = <-rc:
+		if e != nil {
+			t.Fatalf("TestRPCMessageError expected nil, got %v\n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestRPCMessageError Request timeout\n")
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
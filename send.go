@@ -16,6 +16,11 @@
 
 package stompws
 
+import (
+	"context"
+	"sync/atomic"
+)
+
 /*
 	Send a STOMP MESSAGE.
 
@@ -33,7 +38,15 @@ package stompws
 
 */
 func (c *Connection) Send(h Headers, b string) error {
-	c.log(SEND, "start", h)
+	return c.SendContext(context.Background(), h, b)
+}
+
+/*
+	SendContext is like Send, but ctx can cancel the operation, or set a
+	deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) SendContext(ctx context.Context, h Headers, b string) error {
+	c.log(LogLifecycle, LevelDebug, "SEND start", "headers", h)
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -45,12 +58,15 @@ func (c *Connection) Send(h Headers, b string) error {
 		return EREQDSTSND
 	}
 	ch := h.Clone()
-	f := Frame{SEND, ch, []uint8(b)}
-	r := make(chan error)
-	if e = c.writeWireData(wiredata{f, r}); e != nil {
+	ch, cb, x, e := c.compress(ch, []uint8(b))
+	if e != nil {
 		return e
 	}
-	e = <-r
-	c.log(SEND, "end", ch)
+	f := Frame{SEND, ch, cb}
+	e = c.transmitContext(ctx, f)
+	if e == nil {
+		atomic.AddInt64(&c.mets.xbw, x)
+	}
+	c.log(LogLifecycle, LevelDebug, "SEND end", "headers", ch)
 	return e // nil or not
 }
@@ -16,6 +16,11 @@
 
 package stompws
 
+import (
+	"context"
+	"sync/atomic"
+)
+
 /*
 	Send a STOMP MESSAGE.
 
@@ -33,7 +38,15 @@ package stompws
 
 */
 func (c *Connection) SendBytes(h Headers, b []byte) error {
-	c.log(SEND, "start", h)
+	return c.SendBytesContext(context.Background(), h, b)
+}
+
+/*
+	SendBytesContext is like SendBytes, but ctx can cancel the operation, or
+	set a deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) SendBytesContext(ctx context.Context, h Headers, b []byte) error {
+	c.log(LogLifecycle, LevelDebug, "SEND start", "headers", h)
 	if !c.isConnected() {
 		return ECONBAD
 	}
@@ -45,12 +58,15 @@ func (c *Connection) SendBytes(h Headers, b []byte) error {
 		return EREQDSTSND
 	}
 	ch := h.Clone()
-	f := Frame{SEND, ch, b}
-	r := make(chan error)
-	if e = c.writeWireData(wiredata{f, r}); e != nil {
+	ch, cb, x, e := c.compress(ch, b)
+	if e != nil {
 		return e
 	}
-	e = <-r
-	c.log(SEND, "end", ch)
+	f := Frame{SEND, ch, cb}
+	e = c.transmitContext(ctx, f)
+	if e == nil {
+		atomic.AddInt64(&c.mets.xbw, x)
+	}
+	c.log(LogLifecycle, LevelDebug, "SEND end", "headers", ch)
 	return e // nil or not
 }
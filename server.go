@@ -0,0 +1,461 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go/frame"
+	"github.com/gorilla/websocket"
+)
+
+/*
+	ServerHandler receives the frames of server side sessions.  The methods
+	for one session are called from that session's goroutine, one at a time.
+*/
+type ServerHandler interface {
+	/*
+		Connect is called with the client CONNECT or STOMP frame after the
+		protocol level is negotiated.  It returns extra CONNECTED headers, or
+		an error to refuse the client.  A refused client is sent an ERROR
+		frame.
+	*/
+	Connect(s *ServerSession, f Frame) (Headers, error)
+	/*
+		Frame is called for each later client frame, except DISCONNECT.
+		After a nil return any requested RECEIPT is sent.  An error is sent
+		to the client as an ERROR frame, and the session is closed.
+	*/
+	Frame(s *ServerSession, f Frame) error
+	/*
+		Closed is called once when a connected session ends.  The error is
+		nil after a client DISCONNECT.
+	*/
+	Closed(s *ServerSession, e error)
+}
+
+/*
+	ServerOptions configures a WSServer.  A nil *ServerOptions, or any zero
+	field, means use the default.
+*/
+type ServerOptions struct {
+	HeartBeat   string              // CONNECTED heart-beat value, default "0,0"
+	Server      string              // CONNECTED server value, default none
+	Upgrader    *websocket.Upgrader // Default Subprotocols are WSSubprotocols
+	MessageMode WSMessageMode       // WebSocket message type for sent frames
+	Limits      frame.Limits        // Received frame limits, default DefaultFrameLimits
+}
+
+/*
+	WSServer is an http.Handler that terminates STOMP over WebSocket.  Each
+	request is upgraded, and served as one STOMP session.
+*/
+type WSServer struct {
+	h      ServerHandler
+	o      ServerOptions
+	up     websocket.Upgrader
+	sx, sy int64 // Server heart-beat values, ms
+}
+
+/*
+	ServerSession is one client session of a WSServer.  Send and Close are
+	safe to call from any goroutine.
+*/
+type ServerSession struct {
+	srv      *WSServer
+	ws       *websocket.Conn
+	r        *http.Request
+	dec      *frame.Decoder
+	id       string
+	protocol string  // "" until CONNECT is handled
+	ch       Headers // CONNECT headers
+	hbs      time.Duration
+	hbr      time.Duration
+	//
+	wlock sync.Mutex
+	lw    time.Time // Last write, under wlock
+	//
+	done  chan struct{}
+	once  sync.Once
+}
+
+/*
+	NewWSServer creates a WSServer that hands client frames to h.
+
+	Example:
+		s, e := stompngo.NewWSServer(h, &stompngo.ServerOptions{
+			HeartBeat: "10000,10000",
+			Server:    "gateway/1.0",
+		})
+		if e != nil {
+			// Do something sane ...
+		}
+		http.Handle("/stomp", s)
+*/
+func NewWSServer(h ServerHandler, o *ServerOptions) (*WSServer, error) {
+	s := &WSServer{h: h}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.HeartBeat == "" {
+		s.o.HeartBeat = "0,0"
+	}
+	var e error
+	s.sx, s.sy, e = parseHeartBeat(s.o.HeartBeat)
+	if e != nil {
+		return nil, Error("invalid server heart-beat option: " + s.o.HeartBeat)
+	}
+	if s.o.Upgrader != nil {
+		s.up = *s.o.Upgrader
+	}
+	if len(s.up.Subprotocols) == 0 {
+		s.up.Subprotocols = WSSubprotocols
+	}
+	if s.o.Limits == (frame.Limits{}) {
+		s.o.Limits = DefaultFrameLimits
+	}
+	return s, nil
+}
+
+/*
+	ServeHTTP upgrades the request to a WebSocket connection, and serves one
+	STOMP session on it.  It returns when the session ends.
+*/
+func (s *WSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, e := s.up.Upgrade(w, r, nil)
+	if e != nil {
+		return // The Upgrader has replied
+	}
+	ss := &ServerSession{srv: s, ws: ws, r: r, id: Uuid(),
+		done: make(chan struct{})}
+	ss.dec = frame.NewDecoder(serverReader{ss, newWSStream(ws)})
+	ss.dec.Limits = s.o.Limits
+	ss.run()
+}
+
+/*
+	Id returns the session id sent in the CONNECTED frame.
+*/
+func (ss *ServerSession) Id() string {
+	return ss.id
+}
+
+/*
+	Protocol returns the negotiated protocol level.
+*/
+func (ss *ServerSession) Protocol() string {
+	return ss.protocol
+}
+
+/*
+	Request returns the HTTP request that was upgraded.
+*/
+func (ss *ServerSession) Request() *http.Request {
+	return ss.r
+}
+
+/*
+	ConnectHeaders returns the headers of the client CONNECT frame.
+*/
+func (ss *ServerSession) ConnectHeaders() Headers {
+	return ss.ch
+}
+
+/*
+	Send writes a MESSAGE, RECEIPT or ERROR frame to the client.  Headers
+	are encoded for the session protocol level, and a content-length header
+	is added if the frame does not have one.
+*/
+func (ss *ServerSession) Send(f Frame) error {
+	if !validCmds[f.Command] {
+		return EINVBCMD
+	}
+	if e := checkHeaders(f.Headers, ss.protocol); e != nil {
+		return e
+	}
+	return ss.write(&f)
+}
+
+/*
+	Close ends the session without an ERROR frame.
+*/
+func (ss *ServerSession) Close() error {
+	ss.once.Do(func() {
+		close(ss.done)
+		_ = ss.ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second))
+	})
+	return ss.ws.Close()
+}
+
+/*
+	Session main loop.
+*/
+func (ss *ServerSession) run() {
+	f, e := ss.readFrame()
+	if e != nil {
+		_ = ss.Close()
+		return
+	}
+	if e = ss.connect(f); e != nil {
+		ss.sendError(f, e)
+		_ = ss.Close()
+		return
+	}
+	if ss.hbs > 0 {
+		go ss.heartBeats()
+	}
+	for {
+		f, e = ss.readFrame()
+		if e != nil {
+			break
+		}
+		if f.Command == DISCONNECT {
+			ss.receipt(f)
+			break
+		}
+		if e = ss.srv.h.Frame(ss, f); e != nil {
+			ss.sendError(f, e)
+			break
+		}
+		ss.receipt(f)
+	}
+	_ = ss.Close()
+	ss.srv.h.Closed(ss, e)
+}
+
+/*
+	Handle the CONNECT or STOMP frame: negotiate the protocol level and heart
+	beats, consult the handler, and send CONNECTED.
+*/
+func (ss *ServerSession) connect(f Frame) error {
+	if f.Command != CONNECT && f.Command != STOMP {
+		return ESVRCON
+	}
+	av, hasav := f.Headers.Contains(HK_ACCEPT_VERSION)
+	p, e := ss.negotiate(av)
+	if e != nil {
+		return e
+	}
+	if p != SPL_10 && f.Headers.Value(HK_HOST) == "" {
+		return EREQHOST
+	}
+	ss.protocol, ss.ch = p, f.Headers
+	if p != SPL_10 {
+		cx, cy, e := parseHeartBeat(f.Headers.Value(HK_HEART_BEAT))
+		if e != nil {
+			return e
+		}
+		sm, rm := negotiateHeartBeats(ss.srv.sx, ss.srv.sy, cx, cy)
+		ss.hbs = time.Duration(sm) * time.Millisecond
+		ss.hbr = time.Duration(rm) * time.Millisecond
+	}
+	xh, e := ss.srv.h.Connect(ss, f)
+	if e != nil {
+		return e
+	}
+	r := Frame{CONNECTED, Headers{}, NULLBUFF}
+	if hasav {
+		r.Headers = r.Headers.Add(HK_VERSION, p)
+	}
+	r.Headers = r.Headers.Add(HK_SESSION, ss.id)
+	if ss.srv.o.Server != "" {
+		r.Headers = r.Headers.Add(HK_SERVER, ss.srv.o.Server)
+	}
+	if p != SPL_10 {
+		r.Headers = r.Headers.Add(HK_HEART_BEAT, ss.srv.o.HeartBeat)
+	}
+	r.Headers = r.Headers.AddHeaders(xh)
+	return ss.write(&r)
+}
+
+/*
+	Choose the protocol level from the accept-version header.  The level of
+	a STOMP WebSocket subprotocol, if one was selected, is preferred.
+*/
+func (ss *ServerSession) negotiate(av string) (string, error) {
+	if av == "" {
+		return SPL_10, nil
+	}
+	cv := strings.Split(av, ",")
+	for i := range cv {
+		cv[i] = strings.TrimSpace(cv[i])
+	}
+	if v, ok := wsProtocolLevels[ss.ws.Subprotocol()]; ok && hasValue(cv, v) {
+		return v, nil
+	}
+	for i := len(supported) - 1; i >= 0; i-- {
+		if hasValue(cv, supported[i]) {
+			return supported[i], nil
+		}
+	}
+	return "", EBADVERCLI
+}
+
+/*
+	Parse a heart-beat header value.  An empty value means "0,0".
+*/
+func parseHeartBeat(v string) (int64, int64, error) {
+	if v == "" {
+		return 0, 0, nil
+	}
+	p := strings.Split(v, ",")
+	if len(p) != 2 {
+		return 0, 0, EBADHBCLI
+	}
+	x, ex := strconv.ParseInt(strings.TrimSpace(p[0]), 10, 64)
+	y, ey := strconv.ParseInt(strings.TrimSpace(p[1]), 10, 64)
+	if ex != nil || ey != nil || x < 0 || y < 0 {
+		return 0, 0, EBADHBCLI
+	}
+	return x, y, nil
+}
+
+/*
+	Server side frame reader.  Heart beats are skipped.  Headers are decoded
+	for STOMP 1.1+, except on CONNECT and STOMP frames.  Any line may end
+	with CRLF.
+*/
+func (ss *ServerSession) readFrame() (f Frame, e error) {
+	ss.dec.Version = ss.protocol
+	ff, e := ss.dec.Decode()
+	for e == nil && ff.IsHeartBeat() {
+		ff, e = ss.dec.Decode()
+	}
+	f = Frame{ff.Command, Headers(ff.Headers), ff.Body}
+	if e != nil {
+		return f, frameError(e)
+	}
+	if !validClientCmds[f.Command] {
+		return f, fmt.Errorf("%s\n%s", EINVCCMD, HexData([]byte(f.Command)))
+	}
+	hp := ss.protocol
+	if hp == "" {
+		hp = SPL_10
+	}
+	if e = checkHeaders(f.Headers, hp); e != nil {
+		return f, e
+	}
+	return f, nil
+}
+
+/*
+	Each network read refreshes the heart beat read deadline.
+*/
+type serverReader struct {
+	ss *ServerSession
+	r  io.Reader
+}
+
+func (r serverReader) Read(p []byte) (int, error) {
+	r.ss.setReadDeadline()
+	return r.r.Read(p)
+}
+
+/*
+	Client heart beats are checked with a read deadline of twice the
+	negotiated interval.
+*/
+func (ss *ServerSession) setReadDeadline() {
+	if ss.hbr > 0 {
+		_ = ss.ws.SetReadDeadline(time.Now().Add(2 * ss.hbr))
+	}
+}
+
+/*
+	Physical frame write.  The caller's Headers are not modified.
+*/
+func (ss *ServerSession) write(f *Frame) error {
+	w := &frame.Frame{} // Heart beat
+	if f.Command != "\n" {
+		w = &frame.Frame{Command: f.Command, Headers: f.Headers, Body: f.Body}
+		if _, ok := f.Headers.Contains(HK_CONTENT_LENGTH); !ok && len(f.Body) > 0 {
+			w.Headers = f.Headers.Add(HK_CONTENT_LENGTH, strconv.Itoa(len(f.Body)))
+		}
+	}
+	var b bytes.Buffer
+	en := frame.NewEncoder(&b)
+	en.Version = ss.protocol
+	_ = en.Encode(w) // A bytes.Buffer write never fails
+	ss.wlock.Lock()
+	defer ss.wlock.Unlock()
+	select {
+	case <-ss.done:
+		return ECONBAD
+	default:
+	}
+	e := ss.ws.WriteMessage(wsFrameMessageType(ss.srv.o.MessageMode, f), b.Bytes())
+	ss.lw = time.Now()
+	return e
+}
+
+/*
+	Send a RECEIPT if the frame asked for one.
+*/
+func (ss *ServerSession) receipt(f Frame) {
+	if r, ok := f.Headers.Contains(HK_RECEIPT); ok {
+		_ = ss.write(&Frame{RECEIPT, Headers{HK_RECEIPT_ID, r}, NULLBUFF})
+	}
+}
+
+/*
+	Send an ERROR frame describing e.  The message header is the first line
+	of the error text, and the body is the full text.
+*/
+func (ss *ServerSession) sendError(f Frame, e error) {
+	t := e.Error()
+	m := strings.SplitN(t, "\n", 2)[0]
+	r := Frame{ERROR, Headers{HK_MESSAGE, m}, []byte(t + "\n")}
+	if rid, ok := f.Headers.Contains(HK_RECEIPT); ok {
+		r.Headers = r.Headers.Add(HK_RECEIPT_ID, rid)
+	}
+	if e == EBADVERCLI {
+		r.Headers = r.Headers.Add(HK_VERSION, strings.Join(supported, ","))
+	}
+	r.Headers = r.Headers.Add(HK_CONTENT_TYPE, "text/plain")
+	_ = ss.write(&r)
+}
+
+/*
+	The heart beat sender.  A heart beat is only sent if nothing else was
+	written recently.
+*/
+func (ss *ServerSession) heartBeats() {
+	t := time.NewTicker(ss.hbs / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-ss.done:
+			return
+		case <-t.C:
+			ss.wlock.Lock()
+			idle := time.Since(ss.lw) >= ss.hbs/2
+			ss.wlock.Unlock()
+			if idle && ss.write(&Frame{"\n", Headers{}, NULLBUFF}) != nil {
+				return
+			}
+		}
+	}
+}
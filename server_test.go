@@ -0,0 +1,186 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	A test ServerHandler.  SEND is echoed as MESSAGE to the subscription for
+	the destination.  SEND to /queue/bad fails.
+*/
+type echoHandler struct {
+	subs   map[string]string // destination to subscription id
+	closed chan error
+}
+
+func (h *echoHandler) Connect(s *ServerSession, f Frame) (Headers, error) {
+	if f.Headers.Value(HK_LOGIN) == "bad" {
+		return nil, Error("Access refused")
+	}
+	return Headers{"x-echo", "true"}, nil
+}
+
+func (h *echoHandler) Frame(s *ServerSession, f Frame) error {
+	d := f.Headers.Value(HK_DESTINATION)
+	switch f.Command {
+	case SUBSCRIBE:
+		h.subs[d] = f.Headers.Value(HK_ID)
+	case SEND:
+		if d == "/queue/bad" {
+			return Error("rejected: " + d)
+		}
+		return s.Send(Frame{MESSAGE, Headers{HK_DESTINATION, d,
+			HK_SUBSCRIPTION, h.subs[d], HK_MESSAGE_ID, Uuid(),
+			"app", f.Headers.Value("app")}, f.Body})
+	}
+	return nil
+}
+
+func (h *echoHandler) Closed(s *ServerSession, e error) {
+	h.closed <- e
+}
+
+func newEchoServer(t *testing.T, o *ServerOptions) (*httptest.Server, *echoHandler, string) {
+	h := &echoHandler{subs: map[string]string{}, closed: make(chan error, 1)}
+	s, e := NewWSServer(h, o)
+	if e != nil {
+		t.Fatalf("NewWSServer expected nil, got %v\n", e)
+	}
+	srv := httptest.NewServer(s)
+	return srv, h, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+/*
+	Test a client session through the server handler.
+*/
+func TestServerSession(t *testing.T) {
+	srv, h, u := newEchoServer(t, &ServerOptions{Server: "echo/1.0",
+		HeartBeat: "50,0"})
+	defer srv.Close()
+	ws, e := DialWS(u, nil)
+	if e != nil {
+		t.Fatalf("TestServerSession DialWS expected nil, got %v\n", e)
+	}
+	ch := Headers{HK_ACCEPT_VERSION, "1.1,1.2", HK_HOST, "localhost",
+		HK_HEART_BEAT, "0,50"}
+	c, e := ConnectOverWS(ws, ch)
+	if e != nil {
+		t.Fatalf("TestServerSession CONNECT expected nil, got %v\n", e)
+	}
+	conn := c.(*Connection)
+	if conn.Protocol() != SPL_12 {
+		t.Fatalf("TestServerSession expected %s, got %s\n", SPL_12, conn.Protocol())
+	}
+	rh := conn.ConnectResponse.Headers
+	if rh.Value(HK_SERVER) != "echo/1.0" || rh.Value("x-echo") != "true" ||
+		rh.Value(HK_HEART_BEAT) != "50,0" {
+		t.Fatalf("TestServerSession bad CONNECTED headers %v\n", rh)
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/echo", HK_ID, "s1"})
+	if e != nil {
+		t.Fatalf("TestServerSession SUBSCRIBE expected nil, got %v\n", e)
+	}
+	// Header values need encoding, and the body is binary
+	e = c.SendBytes(Headers{HK_DESTINATION, "/queue/echo", "app", "a:b\nc"},
+		[]byte{0, 0xff})
+	if e != nil {
+		t.Fatalf("TestServerSession SEND expected nil, got %v\n", e)
+	}
+	select {
+	case md := <-sc:
+		if md.Error != nil || md.Message.Command != MESSAGE ||
+			md.Message.Headers.Value("app") != "a:b\nc" ||
+			string(md.Message.Body) != "\x00\xff" {
+			t.Fatalf("TestServerSession bad MESSAGE %v %v\n", md.Message, md.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestServerSession MESSAGE timeout\n")
+	}
+	if conn.hbd == nil || !conn.hbd.hbr || conn.hbd.hbs {
+		t.Fatalf("TestServerSession expected receive only heart beats\n")
+	}
+	time.Sleep(200 * time.Millisecond) // Idle, with server heart beats
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+	select {
+	case e = <-h.closed:
+		if e != nil {
+			t.Fatalf("TestServerSession Closed expected nil, got %v\n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestServerSession Closed timeout\n")
+	}
+}
+
+/*
+	Test ERROR frames from the server.
+*/
+func TestServerErrors(t *testing.T) {
+	srv, h, u := newEchoServer(t, nil)
+	defer srv.Close()
+	for _, ch := range []Headers{
+		{HK_ACCEPT_VERSION, "2.0", HK_HOST, "localhost"},
+		{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost", HK_LOGIN, "bad"},
+		{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost", HK_HEART_BEAT, "x"},
+	} {
+		ws, e := DialWS(u, nil)
+		if e != nil {
+			t.Fatalf("TestServerErrors DialWS expected nil, got %v\n", e)
+		}
+		if _, e = ConnectOverWS(ws, ch); e == nil {
+			t.Fatalf("TestServerErrors %v expected error, got nil\n", ch)
+		}
+		ws.Close()
+	}
+	ws, e := DialWS(u, &WSOptions{Subprotocols: []string{WSP_11}})
+	if e != nil {
+		t.Fatalf("TestServerErrors DialWS expected nil, got %v\n", e)
+	}
+	c, e := ConnectOverWS(ws, Headers{HK_ACCEPT_VERSION, SPL_11,
+		HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestServerErrors CONNECT expected nil, got %v\n", e)
+	}
+	e = c.Send(Headers{HK_DESTINATION, "/queue/bad", HK_RECEIPT, "r1"}, "m")
+	if e != nil {
+		t.Fatalf("TestServerErrors SEND expected nil, got %v\n", e)
+	}
+	select {
+	case md := <-c.(*Connection).MessageData:
+		if md.Message.Command != ERROR ||
+			md.Message.Headers.Value(HK_RECEIPT_ID) != "r1" ||
+			md.Message.Headers.Value(HK_MESSAGE) != "rejected: /queue/bad" {
+			t.Fatalf("TestServerErrors bad ERROR %v\n", md.Message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestServerErrors ERROR timeout\n")
+	}
+	select {
+	case e = <-h.closed:
+		if e == nil {
+			t.Fatalf("TestServerErrors Closed expected error, got nil\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestServerErrors Closed timeout\n")
+	}
+	_ = c.Disconnect(NoDiscReceipt)
+}
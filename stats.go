@@ -0,0 +1,282 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	Stats is a point in time copy of the connection metrics.  It is not
+	updated after Stats returns, and may be kept or passed between
+	goroutines freely.
+*/
+type Stats struct {
+	Started                  time.Time // Connection start
+	FramesRead               int64
+	BytesRead                int64
+	FramesWritten            int64 // Heart beats included
+	BytesWritten             int64
+	LogicalBytesRead         int64 // Received bodies after decompression
+	LogicalBytesWritten      int64 // Sent bodies before compression
+	HeartBeatsSent           int64 // Send ticker count
+	HeartBeatsReceived       int64 // Receive ticker count
+	HeartBeatSendFailures    int64
+	HeartBeatReceiveFailures int64 // Receive ticks with no broker data
+	HeartBeatSendFailed      bool  // Hbsf
+	HeartBeatReceiveFailed   bool  // Hbrf
+	ReadErrors               int64 // Transport or frame errors, each ends a session
+	WriteErrors              int64
+	ProtocolErrors           int64     // Frames reported with EMSGNOSUB or EINVBCMD
+	Reconnects               int64     // Successful reconnects
+	EventsDropped            int64     // Events channel full
+	SubChanCap               int       // Default subscription channel capacity
+	LastRead                 time.Time // Zero if no frame has been read
+	LastWrite                time.Time // Zero if no frame has been written
+	ReceiptLatency           Histogram // Time to the broker RECEIPT or ERROR
+	//
+	FramesReadByCommand    map[string]int64    // Broker commands, CONNECTED included
+	BytesReadByCommand     map[string]int64    // Broker commands, CONNECTED included
+	FramesWrittenByCommand map[string]int64    // Client commands
+	BytesWrittenByCommand  map[string]int64    // Client commands
+	Subscriptions          map[string]SubStats // Current subscriptions, by id
+}
+
+/*
+	SubStats holds the metrics for one subscription.
+
+	Delivered counts messages put on the subscription channel, and never
+	decreases.  A message later taken back by the drop-oldest or error
+	overflow policies is also counted in Dropped.  Acked and
+	Nacked count frames sent through a Delivery, or with headers that name
+	the subscription, as STOMP 1.1 ACK and NACK frames do.
+*/
+type SubStats struct {
+	Delivered int64
+	Acked     int64
+	Nacked    int64
+	Dropped   int64 // Overflow drops, see flow.go
+	Depth     int   // Messages waiting on the subscription channel
+	Capacity  int   // Subscription channel capacity
+}
+
+/*
+	Histogram is a snapshot of observed durations, in seconds.  Counts are
+	cumulative: Counts[i] is the number of observations <= Buckets[i].
+*/
+type Histogram struct {
+	Buckets []float64 // Upper bounds
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+/*
+	ReceiptLatencyBuckets are the Histogram upper bounds, in seconds, used
+	for receipt latency by connections created after a change.
+*/
+var ReceiptLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1,
+	.25, .5, 1, 2.5, 5, 10}
+
+/*
+	Broker and client commands counted by command.
+*/
+var (
+	statsReadCmds  = []string{CONNECTED, MESSAGE, RECEIPT, ERROR}
+	statsWriteCmds = []string{CONNECT, STOMP, SEND, SUBSCRIBE, UNSUBSCRIBE,
+		ACK, NACK, BEGIN, COMMIT, ABORT, DISCONNECT}
+)
+
+func newMetrics() *metrics {
+	m := &metrics{cfr: make(map[string]*cmdCount),
+		cfw: make(map[string]*cmdCount), st: time.Now()}
+	for _, k := range statsReadCmds {
+		m.cfr[k] = new(cmdCount)
+	}
+	for _, k := range statsWriteCmds {
+		m.cfw[k] = new(cmdCount)
+	}
+	return m
+}
+
+/*
+	Count a frame read.
+*/
+func (m *metrics) read(cmd string, n int64) {
+	atomic.AddInt64(&m.tfr, 1)
+	atomic.AddInt64(&m.tbr, n)
+	if p, ok := m.cfr[cmd]; ok {
+		atomic.AddInt64(&p.f, 1)
+		atomic.AddInt64(&p.b, n)
+	}
+	atomic.StoreInt64(&m.lrt, time.Now().UnixNano())
+}
+
+/*
+	Count a frame written.
+*/
+func (m *metrics) write(cmd string, n int64) {
+	atomic.AddInt64(&m.tfw, 1)
+	atomic.AddInt64(&m.tbw, n)
+	if p, ok := m.cfw[cmd]; ok {
+		atomic.AddInt64(&p.f, 1)
+		atomic.AddInt64(&p.b, n)
+	}
+	atomic.StoreInt64(&m.lwt, time.Now().UnixNano())
+}
+
+/*
+	Duration observations for a Histogram.
+*/
+type latencies struct {
+	lock  sync.Mutex
+	b     []float64 // Upper bounds, seconds
+	n     []uint64  // Per bucket, not cumulative
+	count uint64
+	sum   float64
+}
+
+func newLatencies(b []float64) *latencies {
+	l := &latencies{b: append([]float64(nil), b...)}
+	sort.Float64s(l.b)
+	l.n = make([]uint64, len(l.b))
+	return l
+}
+
+func (l *latencies) observe(d time.Duration) {
+	s := d.Seconds()
+	i := sort.SearchFloat64s(l.b, s) // First bound >= s
+	l.lock.Lock()
+	if i < len(l.n) {
+		l.n[i]++
+	}
+	l.count++
+	l.sum += s
+	l.lock.Unlock()
+}
+
+func (l *latencies) snapshot() Histogram {
+	h := Histogram{Buckets: append([]float64(nil), l.b...),
+		Counts: make([]uint64, len(l.n))}
+	l.lock.Lock()
+	var t uint64
+	for i, n := range l.n {
+		t += n
+		h.Counts[i] = t
+	}
+	h.Count, h.Sum = l.count, l.sum
+	l.lock.Unlock()
+	return h
+}
+
+/*
+	Count an ACK or NACK for a subscription.
+*/
+func (c *Connection) subAcked(sid, cmd string) {
+	if sid == "" {
+		return
+	}
+	c.subsLock.RLock()
+	ps, ok := c.subs[sid]
+	c.subsLock.RUnlock()
+	if !ok {
+		return
+	}
+	if cmd == ACK {
+		atomic.AddInt64(&ps.acks, 1)
+	} else {
+		atomic.AddInt64(&ps.nacks, 1)
+	}
+}
+
+/*
+	Stats returns a snapshot of the connection metrics.
+
+	Example:
+		s := c.Stats()
+		fmt.Println(s.FramesReadByCommand[stompngo.MESSAGE],
+			s.Subscriptions["sub1"].Acked)
+*/
+func (c *Connection) Stats() Stats {
+	m := c.mets
+	s := Stats{
+		Started:                  m.st,
+		FramesRead:               atomic.LoadInt64(&m.tfr),
+		BytesRead:                atomic.LoadInt64(&m.tbr),
+		FramesWritten:            atomic.LoadInt64(&m.tfw),
+		BytesWritten:             atomic.LoadInt64(&m.tbw),
+		HeartBeatsSent:           c.SendTickerCount(),
+		HeartBeatsReceived:       c.ReceiveTickerCount(),
+		HeartBeatSendFailures:    atomic.LoadInt64(&m.hsf),
+		HeartBeatReceiveFailures: atomic.LoadInt64(&m.hrf),
+		ReadErrors:               atomic.LoadInt64(&m.rer),
+		WriteErrors:              atomic.LoadInt64(&m.wer),
+		ProtocolErrors:           atomic.LoadInt64(&m.per),
+		Reconnects:               c.Reconnects(),
+		EventsDropped:            atomic.LoadInt64(&m.evd),
+		SubChanCap:               c.SubChanCap(),
+		LastRead:                 statsTime(atomic.LoadInt64(&m.lrt)),
+		LastWrite:                statsTime(atomic.LoadInt64(&m.lwt)),
+		ReceiptLatency:           c.rcpm.lat.snapshot(),
+		FramesReadByCommand:      make(map[string]int64),
+		BytesReadByCommand:       make(map[string]int64),
+		FramesWrittenByCommand:   make(map[string]int64),
+		BytesWrittenByCommand:    make(map[string]int64),
+		Subscriptions:            make(map[string]SubStats),
+	}
+	s.LogicalBytesRead = s.BytesRead + atomic.LoadInt64(&m.xbr)
+	s.LogicalBytesWritten = s.BytesWritten + atomic.LoadInt64(&m.xbw)
+	if hbd := c.heartBeatData(); hbd != nil {
+		hbd.sdl.Lock()
+		s.HeartBeatSendFailed = c.Hbsf
+		hbd.sdl.Unlock()
+		hbd.rdl.Lock()
+		s.HeartBeatReceiveFailed = c.Hbrf
+		hbd.rdl.Unlock()
+	}
+	for k, p := range m.cfr {
+		s.FramesReadByCommand[k] = atomic.LoadInt64(&p.f)
+		s.BytesReadByCommand[k] = atomic.LoadInt64(&p.b)
+	}
+	for k, p := range m.cfw {
+		s.FramesWrittenByCommand[k] = atomic.LoadInt64(&p.f)
+		s.BytesWrittenByCommand[k] = atomic.LoadInt64(&p.b)
+	}
+	c.subsLock.RLock()
+	for k, ps := range c.subs {
+		s.Subscriptions[k] = SubStats{
+			Delivered: atomic.LoadInt64(&ps.dlv),
+			Acked:     atomic.LoadInt64(&ps.acks),
+			Nacked:    atomic.LoadInt64(&ps.nacks),
+			Dropped:   atomic.LoadInt64(&ps.drops),
+			Depth:     len(ps.md),
+			Capacity:  cap(ps.md),
+		}
+	}
+	c.subsLock.RUnlock()
+	return s
+}
+
+func statsTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,196 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go/stomptest"
+)
+
+/*
+	Test the Stats snapshot against a scripted session.
+*/
+func TestStats(t *testing.T) {
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestStats CONNECT expected nil, got %v\n", e)
+	}
+	go s.run()
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/stats", HK_ID, "s1",
+		HK_ACK, AckModeClientIndividual})
+	if e != nil {
+		t.Fatalf("TestStats Subscribe expected nil, got %v\n", e)
+	}
+	_ = s.next(t) // SUBSCRIBE
+	go func() {
+		for _, id := range []string{"m1", "m2", "m3"} {
+			s.send(MESSAGE, Headers{HK_SUBSCRIPTION, "s1", HK_MESSAGE_ID, id,
+				HK_ACK, id, HK_DESTINATION, "/queue/stats"}, id)
+		}
+	}()
+	s.sendRaw("MESSAGE\nmessage-id:m4\n\n\x00")
+	<-c.MessageData // EMSGNOSUB
+	for i := 0; i < 3; i++ {
+		var md MessageData
+		select {
+		case md = <-sc:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestStats no MESSAGE\n")
+		}
+		d, e := c.NewDelivery(md)
+		if e != nil {
+			t.Fatalf("TestStats NewDelivery expected nil, got %v\n", e)
+		}
+		if i == 2 {
+			e = d.Nack()
+		} else {
+			e = d.Ack()
+		}
+		if e != nil {
+			t.Fatalf("TestStats ACK/NACK expected nil, got %v\n", e)
+		}
+		_ = s.next(t)
+	}
+	st := c.Stats()
+	if st.FramesReadByCommand[CONNECTED] != 1 || st.FramesReadByCommand[MESSAGE] != 4 ||
+		st.FramesRead != 5 || st.ProtocolErrors != 1 {
+		t.Fatalf("TestStats bad read counts %+v\n", st)
+	}
+	if st.FramesWrittenByCommand[CONNECT] != 1 || st.FramesWrittenByCommand[SUBSCRIBE] != 1 ||
+		st.FramesWrittenByCommand[ACK] != 2 || st.FramesWrittenByCommand[NACK] != 1 ||
+		st.FramesWritten != 5 {
+		t.Fatalf("TestStats bad write counts %+v\n", st)
+	}
+	if st.BytesRead <= 0 || st.BytesWritten <= 0 || st.LastRead.IsZero() ||
+		st.LastWrite.IsZero() || st.ReadErrors != 0 || st.WriteErrors != 0 {
+		t.Fatalf("TestStats bad totals %+v\n", st)
+	}
+	if st.BytesReadByCommand[MESSAGE] <= 0 || st.BytesWrittenByCommand[ACK] <= 0 ||
+		st.BytesReadByCommand[RECEIPT] != 0 {
+		t.Fatalf("TestStats bad command bytes %+v\n", st)
+	}
+	if w := (SubStats{Delivered: 3, Acked: 2, Nacked: 1, Capacity: 1}); st.Subscriptions["s1"] != w {
+		t.Fatalf("TestStats expected %+v, got %+v\n", w, st.Subscriptions["s1"])
+	}
+	// A snapshot does not change
+	st.FramesReadByCommand[MESSAGE] = 0
+	if c.Stats().FramesReadByCommand[MESSAGE] != 4 {
+		t.Fatalf("TestStats snapshot shares state\n")
+	}
+	s.close()
+	for range c.MessageData {
+	}
+	if st = c.Stats(); st.ReadErrors != 1 {
+		t.Fatalf("TestStats expected 1 read error, got %d\n", st.ReadErrors)
+	}
+}
+
+/*
+	Test receipt latency observations.
+*/
+func TestStatsReceiptLatency(t *testing.T) {
+	l := newLatencies([]float64{1, .1, .01})
+	for _, d := range []time.Duration{5 * time.Millisecond, 50 * time.Millisecond,
+		100 * time.Millisecond, 2 * time.Second} {
+		l.observe(d)
+	}
+	h := l.snapshot()
+	if h.Count != 4 || h.Counts[0] != 1 || h.Counts[1] != 3 || h.Counts[2] != 3 ||
+		h.Buckets[0] != .01 || h.Sum < 2.15 || h.Sum > 2.16 {
+		t.Fatalf("TestStatsReceiptLatency bad histogram %+v\n", h)
+	}
+	//
+	n, s := fakePipe()
+	go func() { _ = s.handshake() }()
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestStatsReceiptLatency CONNECT expected nil, got %v\n", e)
+	}
+	go s.run()
+	r, e := c.SendWithReceipt(Headers{HK_DESTINATION, "/queue/stats"}, "rcpt")
+	if e != nil {
+		t.Fatalf("TestStatsReceiptLatency SEND expected nil, got %v\n", e)
+	}
+	if _, e = r.Wait(5 * time.Second); e != nil {
+		t.Fatalf("TestStatsReceiptLatency Wait expected nil, got %v\n", e)
+	}
+	h = c.Stats().ReceiptLatency
+	if h.Count != 1 || len(h.Counts) != len(ReceiptLatencyBuckets) ||
+		h.Counts[len(h.Counts)-1] != 1 {
+		t.Fatalf("TestStatsReceiptLatency bad histogram %+v\n", h)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test Stats may be read while reconnects replace the heart beat data.  Run
+	with -race.
+*/
+func TestStatsReconnect(t *testing.T) {
+	b := stomptest.NewBroker(&stomptest.Options{HeartBeat: "100,100"})
+	defer b.Close()
+	a, e := b.Listen("127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("TestStatsReconnect Listen expected nil, got %v\n", e)
+	}
+	ns := make(chan net.Conn, 8)
+	p := &ReconnectPolicy{
+		Dial: func() (net.Conn, error) {
+			n, e := net.Dial("tcp", a)
+			if e == nil {
+				ns <- n
+			}
+			return n, e
+		},
+		Backoff: func(n int) time.Duration { return time.Millisecond },
+	}
+	c, e := ConnectWithReconnect(Headers{HK_ACCEPT_VERSION, SPL_12,
+		HK_HOST, "localhost", HK_HEART_BEAT, "100,100"}, p)
+	if e != nil {
+		t.Fatalf("TestStatsReconnect CONNECT expected nil, got %v\n", e)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for c.Reconnects() < 3 {
+			_ = c.Stats()
+			_ = c.SendTickerInterval() + c.ReceiveTickerInterval() +
+				c.SendTickerCount() + c.ReceiveTickerCount()
+		}
+	}()
+	for i := int64(1); i <= 3; i++ {
+		_ = (<-ns).Close()
+		for c.Reconnects() < i {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	<-done
+	for w := time.Now().Add(5 * time.Second); c.SendTickerInterval() == 0; {
+		if time.Now().After(w) {
+			t.Fatalf("TestStatsReconnect expected heart beats\n")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -0,0 +1,145 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+/*
+	Package stomplog adapts common structured loggers to the stompngo Logger
+	interface.
+
+	Levels map directly, and key / value pairs become fields of the
+	target logger.
+
+	Example:
+		c.SetStructuredLogger(stomplog.Slog(slog.Default()))
+		c.SetStructuredLogger(stomplog.Zap(zap.NewExample()))
+		c.SetStructuredLogger(stomplog.Logrus(logrus.StandardLogger()))
+*/
+package stomplog
+
+import (
+	"fmt"
+
+	"github.com/drawdy/stomp-ws-go"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+)
+
+/*
+	SlogLogger is the part of *slog.Logger used by Slog.  Any logger taking
+	alternating keys and values may be used.
+*/
+type SlogLogger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+/*
+	Slog returns a Logger writing to a log/slog style logger.
+*/
+func Slog(l SlogLogger) stompws.Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l SlogLogger
+}
+
+func (s slogLogger) Log(level stompws.Level, msg string, kv ...interface{}) {
+	switch {
+	case level < stompws.LevelInfo:
+		s.l.Debug(msg, kv...)
+	case level < stompws.LevelWarn:
+		s.l.Info(msg, kv...)
+	case level < stompws.LevelError:
+		s.l.Warn(msg, kv...)
+	default:
+		s.l.Error(msg, kv...)
+	}
+}
+
+/*
+	Zap returns a Logger writing to a zap logger.
+*/
+func Zap(l *zap.Logger) stompws.Logger {
+	return zapLogger{l.WithOptions(zap.AddCallerSkip(2))}
+}
+
+type zapLogger struct {
+	l *zap.Logger
+}
+
+func (z zapLogger) Log(level stompws.Level, msg string, kv ...interface{}) {
+	f := make([]zap.Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, v := key(kv, i)
+		f = append(f, zap.Any(k, v))
+	}
+	switch {
+	case level < stompws.LevelInfo:
+		z.l.Debug(msg, f...)
+	case level < stompws.LevelWarn:
+		z.l.Info(msg, f...)
+	case level < stompws.LevelError:
+		z.l.Warn(msg, f...)
+	default:
+		z.l.Error(msg, f...)
+	}
+}
+
+/*
+	Logrus returns a Logger writing to a logrus logger or entry.
+*/
+func Logrus(l logrus.FieldLogger) stompws.Logger {
+	return logrusLogger{l}
+}
+
+type logrusLogger struct {
+	l logrus.FieldLogger
+}
+
+func (r logrusLogger) Log(level stompws.Level, msg string, kv ...interface{}) {
+	f := make(logrus.Fields, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, v := key(kv, i)
+		f[k] = v
+	}
+	e := r.l.WithFields(f)
+	switch {
+	case level < stompws.LevelInfo:
+		e.Debug(msg)
+	case level < stompws.LevelWarn:
+		e.Info(msg)
+	case level < stompws.LevelError:
+		e.Warn(msg)
+	default:
+		e.Error(msg)
+	}
+}
+
+/*
+	The key and value at kv[i].  A final key without a value is logged
+	under "!BADKEY", as log/slog does.
+*/
+func key(kv []interface{}, i int) (string, interface{}) {
+	if i+1 >= len(kv) {
+		return "!BADKEY", kv[i]
+	}
+	if s, ok := kv[i].(string); ok {
+		return s, kv[i+1]
+	}
+	return fmt.Sprint(kv[i]), kv[i+1]
+}
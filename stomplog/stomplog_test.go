@@ -0,0 +1,94 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomplog
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/drawdy/stomp-ws-go"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var levels = []stompws.Level{stompws.LevelDebug, stompws.LevelInfo,
+	stompws.LevelWarn, stompws.LevelError}
+
+/*
+	Test the log/slog adapter.
+*/
+func TestSlog(t *testing.T) {
+	var b bytes.Buffer
+	l := Slog(slog.New(slog.NewJSONHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	for _, lv := range levels {
+		b.Reset()
+		l.Log(lv, "msg", "session", "s1", "n", 1)
+		var r map[string]interface{}
+		if e := json.Unmarshal(b.Bytes(), &r); e != nil {
+			t.Fatalf("TestSlog bad output %q: %v\n", b.String(), e)
+		}
+		if r["level"] != lv.String() || r["msg"] != "msg" || r["session"] != "s1" ||
+			r["n"] != float64(1) {
+			t.Fatalf("TestSlog unexpected record %v\n", r)
+		}
+	}
+}
+
+/*
+	Test the zap adapter.
+*/
+func TestZap(t *testing.T) {
+	zc, o := observer.New(zapcore.DebugLevel)
+	l := Zap(zap.New(zc))
+	zl := []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel,
+		zapcore.WarnLevel, zapcore.ErrorLevel}
+	for i, lv := range levels {
+		l.Log(lv, "msg", "session", "s1", "n", 1, "odd")
+		le := o.TakeAll()
+		if len(le) != 1 || le[0].Level != zl[i] || le[0].Message != "msg" {
+			t.Fatalf("TestZap unexpected entries %v\n", le)
+		}
+		m := le[0].ContextMap()
+		if m["session"] != "s1" || m["n"] != int64(1) || m["!BADKEY"] != "odd" {
+			t.Fatalf("TestZap unexpected fields %v\n", m)
+		}
+	}
+}
+
+/*
+	Test the logrus adapter.
+*/
+func TestLogrus(t *testing.T) {
+	lr, h := test.NewNullLogger()
+	lr.SetLevel(logrus.DebugLevel)
+	l := Logrus(lr)
+	rl := []logrus.Level{logrus.DebugLevel, logrus.InfoLevel,
+		logrus.WarnLevel, logrus.ErrorLevel}
+	for i, lv := range levels {
+		l.Log(lv, "msg", "session", "s1", "n", 1)
+		le := h.LastEntry()
+		if le == nil || le.Level != rl[i] || le.Message != "msg" ||
+			le.Data["session"] != "s1" || le.Data["n"] != 1 {
+			t.Fatalf("TestLogrus unexpected entry %v\n", le)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+/*
+	Package stompotel adds OpenTelemetry tracing to stompngo connections.
+
+	Trace context travels in the W3C traceparent and tracestate headers.
+	Inject adds them to SEND headers, and Extract reads them from MESSAGE
+	headers into a context.Context.  Header values are added as is: the
+	connection escapes them on the wire for STOMP 1.1+, and received MESSAGE
+	headers are already unescaped.
+
+	A Tracer wraps a connection.  Its Send and SendBytes methods create a
+	producer span, and inject the span context.  Handler and Start create a
+	consumer span for each delivered MESSAGE, a child of the producer span
+	when the sender was traced.  Spans carry the messaging semantic
+	convention attributes: destination, message-id and subscription.
+
+	Example:
+		t := stompotel.New(c)
+		e := t.Send(ctx, stompngo.Headers{stompngo.HK_DESTINATION,
+			"/queue/orders"}, "order 1")
+		if e != nil {
+			// Do something sane ...
+		}
+		s, e := c.SubscribeFunc(h, t.Handler(func(ctx context.Context,
+			md stompngo.MessageData) error {
+			return process(ctx, md.Message.Body)
+		}), nil)
+*/
+package stompotel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/drawdy/stomp-ws-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+	Instrumentation name, for the Tracer.
+*/
+const Name = "github.com/drawdy/stomp-ws-go/stompotel"
+
+/*
+	SubscriptionKey is the span attribute for the STOMP subscription id.
+*/
+const SubscriptionKey = attribute.Key("messaging.stomp.subscription")
+
+/*
+	HeaderCarrier adapts Headers to a propagation.TextMapCarrier.
+*/
+type HeaderCarrier struct {
+	Headers stompws.Headers
+}
+
+/*
+	Get returns the first value for a key.
+*/
+func (hc *HeaderCarrier) Get(k string) string {
+	return hc.Headers.Value(k)
+}
+
+/*
+	Set replaces the first value for a key, or adds the key.
+*/
+func (hc *HeaderCarrier) Set(k, v string) {
+	if i := hc.Headers.Index(k); i >= 0 {
+		hc.Headers[i+1] = v
+		return
+	}
+	hc.Headers = hc.Headers.Add(k, v)
+}
+
+/*
+	Keys returns the header keys.
+*/
+func (hc *HeaderCarrier) Keys() []string {
+	k := make([]string, 0, len(hc.Headers)/2)
+	for i := 0; i < len(hc.Headers); i += 2 {
+		k = append(k, hc.Headers[i])
+	}
+	return k
+}
+
+/*
+	Inject returns a copy of h with the trace context of ctx added, using
+	the global propagator.
+*/
+func Inject(ctx context.Context, h stompws.Headers) stompws.Headers {
+	return inject(ctx, h, otel.GetTextMapPropagator())
+}
+
+/*
+	Extract returns ctx with any trace context from h added, using the
+	global propagator.
+*/
+func Extract(ctx context.Context, h stompws.Headers) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, &HeaderCarrier{h})
+}
+
+func inject(ctx context.Context, h stompws.Headers,
+	p propagation.TextMapPropagator) stompws.Headers {
+	hc := &HeaderCarrier{h.Clone()}
+	p.Inject(ctx, hc)
+	return hc.Headers
+}
+
+/*
+	Option configures a Tracer.
+*/
+type Option func(*Tracer)
+
+/*
+	WithTracerProvider sets the TracerProvider, default the global one.
+*/
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(t *Tracer) { t.tp = tp }
+}
+
+/*
+	WithPropagator sets the propagator, default the global one.
+*/
+func WithPropagator(p propagation.TextMapPropagator) Option {
+	return func(t *Tracer) { t.prop = p }
+}
+
+/*
+	WithSystem sets the messaging.system attribute, default "stomp".  Use
+	the broker name, for example "activemq" or "rabbitmq".
+*/
+func WithSystem(s string) Option {
+	return func(t *Tracer) { t.sys = s }
+}
+
+/*
+	Tracer creates spans for the traffic on one connection.
+*/
+type Tracer struct {
+	c    stompws.STOMPConnector
+	tp   trace.TracerProvider
+	prop propagation.TextMapPropagator
+	sys  string
+	tr   trace.Tracer
+}
+
+/*
+	New returns a Tracer for c.
+*/
+func New(c stompws.STOMPConnector, opts ...Option) *Tracer {
+	t := &Tracer{c: c, sys: "stomp"}
+	for _, o := range opts {
+		o(t)
+	}
+	if t.tp == nil {
+		t.tp = otel.GetTracerProvider()
+	}
+	if t.prop == nil {
+		t.prop = otel.GetTextMapPropagator()
+	}
+	t.tr = t.tp.Tracer(Name)
+	return t
+}
+
+/*
+	Send is like SendContext, in a producer span.  Without a ContextStomper
+	connection, ctx only parents the span.
+*/
+func (t *Tracer) Send(ctx context.Context, h stompws.Headers, b string) error {
+	ctx, sp, h := t.startSend(ctx, h, len(b))
+	var e error
+	if cs, ok := t.c.(stompws.ContextStomper); ok {
+		e = cs.SendContext(ctx, h, b)
+	} else {
+		e = t.c.Send(h, b)
+	}
+	return end(sp, e)
+}
+
+/*
+	SendBytes is like SendBytesContext, in a producer span.  Without a
+	ContextStomper connection, ctx only parents the span.
+*/
+func (t *Tracer) SendBytes(ctx context.Context, h stompws.Headers, b []byte) error {
+	ctx, sp, h := t.startSend(ctx, h, len(b))
+	var e error
+	if cs, ok := t.c.(stompws.ContextStomper); ok {
+		e = cs.SendBytesContext(ctx, h, b)
+	} else {
+		e = t.c.SendBytes(h, b)
+	}
+	return end(sp, e)
+}
+
+/*
+	Start extracts the trace context from a MESSAGE, and starts a consumer
+	span for processing it.  The caller must end the span.
+
+	Example:
+		for md := range s {
+			ctx, sp := t.Start(context.Background(), md)
+			e := process(ctx, md.Message.Body)
+			sp.End()
+		}
+*/
+func (t *Tracer) Start(ctx context.Context, md stompws.MessageData) (context.Context, trace.Span) {
+	h := md.Message.Headers
+	ctx = t.prop.Extract(ctx, &HeaderCarrier{h})
+	d := h.Value(stompws.HK_DESTINATION)
+	a := append(t.attrs(d, len(md.Message.Body)), semconv.MessagingOperationProcess)
+	if v, ok := h.Contains(stompws.HK_MESSAGE_ID); ok {
+		a = append(a, semconv.MessagingMessageIDKey.String(v))
+	}
+	if v, ok := h.Contains(stompws.HK_SUBSCRIPTION); ok {
+		a = append(a, SubscriptionKey.String(v))
+	}
+	ctx, sp := t.tr.Start(ctx, spanName(d, "process"),
+		trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(a...))
+	if md.Error != nil {
+		sp.RecordError(md.Error)
+		sp.SetStatus(codes.Error, md.Error.Error())
+	}
+	return ctx, sp
+}
+
+/*
+	Handler returns a SubscribeFunc handler that calls hf in a consumer
+	span, with the extracted trace context.  An error from hf is recorded
+	on the span.
+*/
+func (t *Tracer) Handler(hf func(context.Context, stompws.MessageData) error) func(stompws.MessageData) error {
+	return func(md stompws.MessageData) error {
+		ctx, sp := t.Start(context.Background(), md)
+		return end(sp, hf(ctx, md))
+	}
+}
+
+/*
+	Start a producer span, and inject it into a copy of the headers.
+*/
+func (t *Tracer) startSend(ctx context.Context, h stompws.Headers,
+	n int) (context.Context, trace.Span, stompws.Headers) {
+	d := h.Value(stompws.HK_DESTINATION)
+	ctx, sp := t.tr.Start(ctx, spanName(d, "send"),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(t.attrs(d, n)...))
+	return ctx, sp, inject(ctx, h, t.prop)
+}
+
+/*
+	Attributes common to all spans.
+*/
+func (t *Tracer) attrs(d string, n int) []attribute.KeyValue {
+	a := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(t.sys),
+		semconv.MessagingProtocolKey.String("STOMP"),
+		semconv.MessagingProtocolVersionKey.String(t.c.Protocol()),
+		semconv.MessagingDestinationKey.String(d),
+		semconv.MessagingMessagePayloadSizeBytesKey.Int(n),
+	}
+	switch {
+	case strings.HasPrefix(d, "/queue/"):
+		a = append(a, semconv.MessagingDestinationKindQueue)
+	case strings.HasPrefix(d, "/topic/"):
+		a = append(a, semconv.MessagingDestinationKindTopic)
+	}
+	return a
+}
+
+/*
+	Span names follow the messaging conventions: "<destination> <operation>".
+*/
+func spanName(d, op string) string {
+	if d == "" {
+		return "(anonymous) " + op
+	}
+	return d + " " + op
+}
+
+/*
+	End a span, recording any error.
+*/
+func end(sp trace.Span, e error) error {
+	if e != nil {
+		sp.RecordError(e)
+		sp.SetStatus(codes.Error, e.Error())
+	}
+	sp.End()
+	return e
+}
@@ -0,0 +1,168 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompotel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go"
+	"github.com/drawdy/stomp-ws-go/stomptest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+	Test trace context round trips through Headers.
+*/
+func TestInjectExtract(t *testing.T) {
+	p := propagation.TraceContext{}
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ts, _ := trace.ParseTraceState("k1=v:1,k2=v2")
+	sc = sc.WithTraceState(ts)
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	h := stompws.Headers{stompws.HK_DESTINATION, "/queue/otel", "traceparent", "old"}
+	ih := inject(ctx, h, p)
+	if h.Value("traceparent") != "old" {
+		t.Fatalf("TestInjectExtract headers modified %v\n", h)
+	}
+	if ih.Value("traceparent") != "00-01020300000000000000000000000000-0405060000000000-01" ||
+		ih.Value("tracestate") != "k1=v:1,k2=v2" || len(ih) != 6 {
+		t.Fatalf("TestInjectExtract bad headers %v\n", ih)
+	}
+	xc := trace.SpanContextFromContext(p.Extract(context.Background(), &HeaderCarrier{ih}))
+	if !xc.IsRemote() || xc.TraceID() != sc.TraceID() || xc.SpanID() != sc.SpanID() ||
+		xc.TraceState().String() != ts.String() {
+		t.Fatalf("TestInjectExtract expected %v, got %v\n", sc, xc)
+	}
+}
+
+/*
+	Test producer and consumer spans through a broker, for each protocol
+	level.
+*/
+func TestTracerSpans(t *testing.T) {
+	for _, v := range stompws.Protocols() {
+		b := stomptest.NewBroker(nil)
+		a, e := b.Listen("127.0.0.1:0")
+		if e != nil {
+			t.Fatalf("TestTracerSpans Listen expected nil, got %v\n", e)
+		}
+		n, e := net.Dial("tcp", a)
+		if e != nil {
+			t.Fatalf("TestTracerSpans Dial expected nil, got %v\n", e)
+		}
+		c, e := stompws.Connect(n, stompws.Headers{stompws.HK_ACCEPT_VERSION, v,
+			stompws.HK_HOST, "localhost"})
+		if e != nil {
+			t.Fatalf("TestTracerSpans CONNECT expected nil, got %v\n", e)
+		}
+		sr := tracetest.NewSpanRecorder()
+		tr := New(c, WithTracerProvider(sdktrace.NewTracerProvider(
+			sdktrace.WithSpanProcessor(sr))), WithPropagator(propagation.TraceContext{}))
+		d := "/queue/otel." + v
+		got := make(chan trace.SpanContext, 2)
+		s, e := c.SubscribeFunc(stompws.Headers{stompws.HK_DESTINATION, d},
+			tr.Handler(func(ctx context.Context, md stompws.MessageData) error {
+				got <- trace.SpanContextFromContext(ctx)
+				if md.Message.BodyString() == "fail" {
+					return errors.New("failed")
+				}
+				return nil
+			}), nil)
+		if e != nil {
+			t.Fatalf("TestTracerSpans SubscribeFunc expected nil, got %v\n", e)
+		}
+		if e = tr.Send(context.Background(), stompws.Headers{stompws.HK_DESTINATION, d},
+			"ok"); e != nil {
+			t.Fatalf("TestTracerSpans Send expected nil, got %v\n", e)
+		}
+		if e = tr.SendBytes(context.Background(), stompws.Headers{stompws.HK_DESTINATION, d},
+			[]byte("fail")); e != nil {
+			t.Fatalf("TestTracerSpans SendBytes expected nil, got %v\n", e)
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case <-got:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("TestTracerSpans %s no MESSAGE\n", v)
+			}
+		}
+		_ = s.Unsubscribe()
+		<-s.Done()
+		e = c.Disconnect(stompws.Headers{})
+		if e != nil {
+			t.Fatalf("TestTracerSpans DISCONNECT expected nil, got %v\n", e)
+		}
+		_ = n.Close()
+		_ = b.Close()
+		//
+		sp := sr.Ended()
+		if len(sp) != 4 {
+			t.Fatalf("TestTracerSpans %s expected 4 spans, got %d\n", v, len(sp))
+		}
+		prod := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+		for _, s := range sp {
+			if s.SpanKind() == trace.SpanKindProducer {
+				prod[s.SpanContext().SpanID()] = s
+				if s.Name() != d+" send" || attr(s, "messaging.destination") != d ||
+					attr(s, "messaging.protocol_version") != v {
+					t.Fatalf("TestTracerSpans %s bad producer span %v %v\n", v, s.Name(),
+						s.Attributes())
+				}
+			}
+		}
+		for _, s := range sp {
+			if s.SpanKind() != trace.SpanKindConsumer {
+				continue
+			}
+			p, ok := prod[s.Parent().SpanID()]
+			if !ok || s.Parent().TraceID() != p.SpanContext().TraceID() {
+				t.Fatalf("TestTracerSpans %s consumer span has no producer parent\n", v)
+			}
+			if s.Name() != d+" process" || attr(s, "messaging.message_id") == "" ||
+				attr(s, "messaging.stomp.subscription") == "" ||
+				attr(s, "messaging.operation") != "process" {
+				t.Fatalf("TestTracerSpans %s bad consumer span %v %v\n", v, s.Name(),
+					s.Attributes())
+			}
+			fail := attr(p, "messaging.message_payload_size_bytes") == "4"
+			if fail != (s.Status().Code == codes.Error) {
+				t.Fatalf("TestTracerSpans %s bad consumer status %v\n", v, s.Status())
+			}
+		}
+	}
+}
+
+func attr(s sdktrace.ReadOnlySpan, k string) string {
+	for _, a := range s.Attributes() {
+		if string(a.Key) == k {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
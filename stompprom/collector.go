@@ -0,0 +1,243 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+/*
+	Package stompprom exports stompngo connection metrics to Prometheus.
+
+	A Collector reads Stats from each connection added to it, on every
+	scrape.  All metrics have a "connection" label, the name given to Add.
+
+		stomp_frames_read_total			counter, by command
+		stomp_bytes_read_total			counter, by command
+		stomp_frames_written_total		counter, by command
+		stomp_bytes_written_total		counter, by command
+		stomp_heartbeats_sent_total		counter
+		stomp_heartbeats_received_total		counter
+		stomp_heartbeat_send_failures_total	counter
+		stomp_heartbeat_receive_failures_total	counter
+		stomp_heartbeat_send_failed		gauge, Hbsf as 0 or 1
+		stomp_heartbeat_receive_failed		gauge, Hbrf as 0 or 1
+		stomp_errors_total			counter, by kind: read, write, protocol
+		stomp_reconnects_total			counter
+		stomp_sub_chan_cap			gauge, SubChanCap()
+		stomp_subscription_depth		gauge, by subscription
+		stomp_subscription_capacity		gauge, by subscription
+		stomp_subscription_delivered_total	counter, by subscription
+		stomp_subscription_acked_total		counter, by subscription
+		stomp_subscription_nacked_total		counter, by subscription
+		stomp_subscription_dropped_total	counter, by subscription
+		stomp_receipt_latency_seconds		histogram
+
+	Heart beat counts restart with each session, and subscription metrics
+	with each subscription.
+
+	Example:
+		pc := stompprom.NewCollector()
+		pc.Add("orders", c)
+		prometheus.MustRegister(pc)
+		http.Handle("/metrics", promhttp.Handler())
+*/
+package stompprom
+
+import (
+	"sync"
+
+	"github.com/drawdy/stomp-ws-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	lc  = []string{"connection"}
+	lcc = []string{"connection", "command"}
+	lck = []string{"connection", "kind"}
+	lcs = []string{"connection", "subscription"}
+
+	framesRead = prometheus.NewDesc("stomp_frames_read_total",
+		"Frames read, by broker command.", lcc, nil)
+	bytesRead = prometheus.NewDesc("stomp_bytes_read_total",
+		"Bytes read, by broker command.", lcc, nil)
+	framesWritten = prometheus.NewDesc("stomp_frames_written_total",
+		"Frames written, by client command.", lcc, nil)
+	bytesWritten = prometheus.NewDesc("stomp_bytes_written_total",
+		"Bytes written, by client command.", lcc, nil)
+	hbSent = prometheus.NewDesc("stomp_heartbeats_sent_total",
+		"Heart beats sent in the current session.", lc, nil)
+	hbReceived = prometheus.NewDesc("stomp_heartbeats_received_total",
+		"Heart beat receive checks passed in the current session.", lc, nil)
+	hbSendFailures = prometheus.NewDesc("stomp_heartbeat_send_failures_total",
+		"Heart beat sends that failed.", lc, nil)
+	hbReceiveFailures = prometheus.NewDesc("stomp_heartbeat_receive_failures_total",
+		"Heart beat receive checks that found no broker data.", lc, nil)
+	hbSendFailed = prometheus.NewDesc("stomp_heartbeat_send_failed",
+		"1 if the last heart beat send failed.", lc, nil)
+	hbReceiveFailed = prometheus.NewDesc("stomp_heartbeat_receive_failed",
+		"1 if the last heart beat receive check failed.", lc, nil)
+	errorsTotal = prometheus.NewDesc("stomp_errors_total",
+		"Errors, by kind: read, write or protocol.", lck, nil)
+	reconnects = prometheus.NewDesc("stomp_reconnects_total",
+		"Successful reconnects.", lc, nil)
+	subChanCap = prometheus.NewDesc("stomp_sub_chan_cap",
+		"Default subscription channel capacity.", lc, nil)
+	subDepth = prometheus.NewDesc("stomp_subscription_depth",
+		"Messages waiting on the subscription channel.", lcs, nil)
+	subCapacity = prometheus.NewDesc("stomp_subscription_capacity",
+		"Subscription channel capacity.", lcs, nil)
+	subDelivered = prometheus.NewDesc("stomp_subscription_delivered_total",
+		"Messages delivered to the subscription channel.", lcs, nil)
+	subAcked = prometheus.NewDesc("stomp_subscription_acked_total",
+		"ACK frames sent for the subscription.", lcs, nil)
+	subNacked = prometheus.NewDesc("stomp_subscription_nacked_total",
+		"NACK frames sent for the subscription.", lcs, nil)
+	subDropped = prometheus.NewDesc("stomp_subscription_dropped_total",
+		"Messages dropped by the subscription overflow policy.", lcs, nil)
+	receiptLatency = prometheus.NewDesc("stomp_receipt_latency_seconds",
+		"Time from sending a frame to the broker RECEIPT or ERROR.", lc, nil)
+
+	descs = []*prometheus.Desc{framesRead, bytesRead, framesWritten,
+		bytesWritten, hbSent, hbReceived, hbSendFailures, hbReceiveFailures,
+		hbSendFailed, hbReceiveFailed, errorsTotal, reconnects, subChanCap,
+		subDepth, subCapacity, subDelivered, subAcked, subNacked, subDropped,
+		receiptLatency}
+)
+
+/*
+	Source is a connection a Collector reads Stats from.  A
+	*stompws.Connection is a Source.
+*/
+type Source interface {
+	Stats() stompws.Stats
+}
+
+/*
+	Collector is a prometheus.Collector over a set of named connections.  It
+	is safe for concurrent use.
+*/
+type Collector struct {
+	lock  sync.Mutex
+	conns map[string]Source
+}
+
+/*
+	NewCollector returns a Collector with no connections.
+*/
+func NewCollector() *Collector {
+	return &Collector{conns: make(map[string]Source)}
+}
+
+/*
+	Add starts exporting c with connection label name.  A connection already
+	added with that name is replaced.
+*/
+func (pc *Collector) Add(name string, c Source) {
+	pc.lock.Lock()
+	pc.conns[name] = c
+	pc.lock.Unlock()
+}
+
+/*
+	Remove stops exporting the connection added with name.
+*/
+func (pc *Collector) Remove(name string) {
+	pc.lock.Lock()
+	delete(pc.conns, name)
+	pc.lock.Unlock()
+}
+
+/*
+	Describe implements prometheus.Collector.
+*/
+func (pc *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range descs {
+		ch <- d
+	}
+}
+
+/*
+	Collect implements prometheus.Collector.
+*/
+func (pc *Collector) Collect(ch chan<- prometheus.Metric) {
+	pc.lock.Lock()
+	cs := make(map[string]Source, len(pc.conns))
+	for n, c := range pc.conns {
+		cs[n] = c
+	}
+	pc.lock.Unlock()
+	for n, c := range cs {
+		collect(ch, n, c.Stats())
+	}
+}
+
+/*
+	The metrics for one connection.
+*/
+func collect(ch chan<- prometheus.Metric, n string, s stompws.Stats) {
+	counter := func(d *prometheus.Desc, v int64, lv ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue,
+			float64(v), append([]string{n}, lv...)...)
+	}
+	gauge := func(d *prometheus.Desc, v int64, lv ...string) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue,
+			float64(v), append([]string{n}, lv...)...)
+	}
+	for k, v := range s.FramesReadByCommand {
+		counter(framesRead, v, k)
+		counter(bytesRead, s.BytesReadByCommand[k], k)
+	}
+	for k, v := range s.FramesWrittenByCommand {
+		counter(framesWritten, v, k)
+		counter(bytesWritten, s.BytesWrittenByCommand[k], k)
+	}
+	counter(hbSent, s.HeartBeatsSent)
+	counter(hbReceived, s.HeartBeatsReceived)
+	counter(hbSendFailures, s.HeartBeatSendFailures)
+	counter(hbReceiveFailures, s.HeartBeatReceiveFailures)
+	gauge(hbSendFailed, flag(s.HeartBeatSendFailed))
+	gauge(hbReceiveFailed, flag(s.HeartBeatReceiveFailed))
+	counter(errorsTotal, s.ReadErrors, "read")
+	counter(errorsTotal, s.WriteErrors, "write")
+	counter(errorsTotal, s.ProtocolErrors, "protocol")
+	counter(reconnects, s.Reconnects)
+	gauge(subChanCap, int64(s.SubChanCap))
+	for id, ss := range s.Subscriptions {
+		gauge(subDepth, int64(ss.Depth), id)
+		gauge(subCapacity, int64(ss.Capacity), id)
+		counter(subDelivered, ss.Delivered, id)
+		counter(subAcked, ss.Acked, id)
+		counter(subNacked, ss.Nacked, id)
+		counter(subDropped, ss.Dropped, id)
+	}
+	ch <- prometheus.MustNewConstHistogram(receiptLatency,
+		s.ReceiptLatency.Count, s.ReceiptLatency.Sum,
+		buckets(s.ReceiptLatency), n)
+}
+
+func flag(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/*
+	Histogram buckets in Prometheus form.
+*/
+func buckets(h stompws.Histogram) map[float64]uint64 {
+	b := make(map[float64]uint64, len(h.Buckets))
+	for i, ub := range h.Buckets {
+		b[ub] = h.Counts[i]
+	}
+	return b
+}
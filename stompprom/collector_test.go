@@ -0,0 +1,134 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompprom
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drawdy/stomp-ws-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+/*
+	A connection that only reports fixed Stats.
+*/
+type fakeConn struct {
+	s stompws.Stats
+}
+
+func (f fakeConn) Stats() stompws.Stats {
+	return f.s
+}
+
+func testStats() stompws.Stats {
+	return stompws.Stats{
+		HeartBeatsSent:         7,
+		HeartBeatReceiveFailed: true,
+		ReadErrors:             1,
+		Reconnects:             2,
+		SubChanCap:             10,
+		FramesReadByCommand:    map[string]int64{stompws.MESSAGE: 3},
+		BytesReadByCommand:     map[string]int64{stompws.MESSAGE: 300},
+		FramesWrittenByCommand: map[string]int64{stompws.SEND: 4},
+		BytesWrittenByCommand:  map[string]int64{stompws.SEND: 400},
+		Subscriptions: map[string]stompws.SubStats{
+			"s1": {Delivered: 5, Acked: 4, Depth: 1, Capacity: 10}},
+		ReceiptLatency: stompws.Histogram{Buckets: []float64{.01, .1},
+			Counts: []uint64{1, 3}, Count: 4, Sum: 1.5},
+	}
+}
+
+/*
+	Test exported values.
+*/
+func TestCollector(t *testing.T) {
+	pc := NewCollector()
+	pc.Add("c1", fakeConn{s: testStats()})
+	exp := `
+# HELP stomp_frames_read_total Frames read, by broker command.
+# TYPE stomp_frames_read_total counter
+stomp_frames_read_total{command="MESSAGE",connection="c1"} 3
+# HELP stomp_bytes_written_total Bytes written, by client command.
+# TYPE stomp_bytes_written_total counter
+stomp_bytes_written_total{command="SEND",connection="c1"} 400
+# HELP stomp_heartbeats_sent_total Heart beats sent in the current session.
+# TYPE stomp_heartbeats_sent_total counter
+stomp_heartbeats_sent_total{connection="c1"} 7
+# HELP stomp_heartbeat_receive_failed 1 if the last heart beat receive check failed.
+# TYPE stomp_heartbeat_receive_failed gauge
+stomp_heartbeat_receive_failed{connection="c1"} 1
+# HELP stomp_errors_total Errors, by kind: read, write or protocol.
+# TYPE stomp_errors_total counter
+stomp_errors_total{connection="c1",kind="protocol"} 0
+stomp_errors_total{connection="c1",kind="read"} 1
+stomp_errors_total{connection="c1",kind="write"} 0
+# HELP stomp_reconnects_total Successful reconnects.
+# TYPE stomp_reconnects_total counter
+stomp_reconnects_total{connection="c1"} 2
+# HELP stomp_subscription_depth Messages waiting on the subscription channel.
+# TYPE stomp_subscription_depth gauge
+stomp_subscription_depth{connection="c1",subscription="s1"} 1
+# HELP stomp_subscription_capacity Subscription channel capacity.
+# TYPE stomp_subscription_capacity gauge
+stomp_subscription_capacity{connection="c1",subscription="s1"} 10
+# HELP stomp_receipt_latency_seconds Time from sending a frame to the broker RECEIPT or ERROR.
+# TYPE stomp_receipt_latency_seconds histogram
+stomp_receipt_latency_seconds_bucket{connection="c1",le="0.01"} 1
+stomp_receipt_latency_seconds_bucket{connection="c1",le="0.1"} 3
+stomp_receipt_latency_seconds_bucket{connection="c1",le="+Inf"} 4
+stomp_receipt_latency_seconds_sum{connection="c1"} 1.5
+stomp_receipt_latency_seconds_count{connection="c1"} 4
+`
+	e := testutil.CollectAndCompare(pc, strings.NewReader(exp),
+		"stomp_frames_read_total", "stomp_bytes_written_total",
+		"stomp_heartbeats_sent_total", "stomp_heartbeat_receive_failed",
+		"stomp_errors_total", "stomp_reconnects_total",
+		"stomp_subscription_depth", "stomp_subscription_capacity",
+		"stomp_receipt_latency_seconds")
+	if e != nil {
+		t.Fatalf("TestCollector expected nil, got %v\n", e)
+	}
+}
+
+/*
+	Test registration, and several connections.
+*/
+func TestCollectorRegister(t *testing.T) {
+	pc := NewCollector()
+	r := prometheus.NewPedanticRegistry()
+	if e := r.Register(pc); e != nil {
+		t.Fatalf("TestCollectorRegister expected nil, got %v\n", e)
+	}
+	if n := testutil.CollectAndCount(pc); n != 0 {
+		t.Fatalf("TestCollectorRegister expected 0 metrics, got %d\n", n)
+	}
+	pc.Add("c1", fakeConn{s: testStats()})
+	pc.Add("c2", fakeConn{s: testStats()})
+	n := testutil.CollectAndCount(pc, "stomp_reconnects_total")
+	if n != 2 {
+		t.Fatalf("TestCollectorRegister expected 2, got %d\n", n)
+	}
+	if _, e := r.Gather(); e != nil {
+		t.Fatalf("TestCollectorRegister Gather expected nil, got %v\n", e)
+	}
+	pc.Remove("c1")
+	if n = testutil.CollectAndCount(pc, "stomp_reconnects_total"); n != 1 {
+		t.Fatalf("TestCollectorRegister expected 1, got %d\n", n)
+	}
+}
@@ -0,0 +1,245 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+/*
+	Package stomptest provides a minimal, in memory, STOMP 1.0, 1.1 and 1.2
+	broker for tests and local development.
+
+	The broker listens on TCP and / or WebSocket.  Destinations starting with
+	"/topic/" are topics: a message is delivered to every current subscriber
+	and is not retained.  All other destinations are queues: messages are
+	retained until a subscriber is available, and each message goes to one
+	subscriber, round robin.
+
+	Supported: all ack modes, ACK and NACK (a NACKed message is discarded),
+	transactions, receipts, heart beats, and ERROR frames for protocol
+	violations.  Nothing is persisted.
+
+	Example:
+		b := stomptest.NewBroker(nil)
+		defer b.Close()
+		addr, e := b.Listen("127.0.0.1:0")
+		if e != nil {
+			// Do something sane ...
+		}
+		n, e := net.Dial("tcp", addr)
+		// Use n with stompngo.Connect
+*/
+package stomptest
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	v10 = "1.0"
+	v11 = "1.1"
+	v12 = "1.2"
+)
+
+var versions = []string{v10, v11, v12}
+
+/*
+	Options configures a Broker.  A nil *Options, or any zero field, means
+	use the default.
+*/
+type Options struct {
+	Login     string // Required login, default: any
+	Passcode  string // Required passcode, checked if Login is set
+	HeartBeat string // Server heart-beat header value, default "1000,1000"
+	Server    string // Server header value, default "stomptest"
+}
+
+/*
+	Broker is an in memory STOMP broker.
+*/
+type Broker struct {
+	opts     Options
+	lock     sync.Mutex
+	queues   map[string]*queue
+	topics   map[string][]*subscription
+	sessions map[*session]bool
+	lns      []net.Listener
+	srvs     []*http.Server
+	closed   bool
+	nsess    int64 // Session counter
+	nmsg     int64 // Message id counter
+	upgrader websocket.Upgrader
+}
+
+/*
+	NewBroker creates a Broker.  Use Listen, ListenWS, ServeConn or the
+	http.Handler interface to accept clients.
+*/
+func NewBroker(o *Options) *Broker {
+	b := &Broker{
+		queues:   make(map[string]*queue),
+		topics:   make(map[string][]*subscription),
+		sessions: make(map[*session]bool),
+	}
+	if o != nil {
+		b.opts = *o
+	}
+	if b.opts.HeartBeat == "" {
+		b.opts.HeartBeat = "1000,1000"
+	}
+	if b.opts.Server == "" {
+		b.opts.Server = "stomptest"
+	}
+	b.upgrader.Subprotocols = []string{"v12.stomp", "v11.stomp", "v10.stomp"}
+	b.upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	return b
+}
+
+/*
+	Listen accepts STOMP clients over TCP on addr, e.g. "127.0.0.1:0".  It
+	returns the address actually used.
+*/
+func (b *Broker) Listen(addr string) (string, error) {
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		return "", e
+	}
+	b.lock.Lock()
+	b.lns = append(b.lns, ln)
+	b.lock.Unlock()
+	go func() {
+		for {
+			n, e := ln.Accept()
+			if e != nil {
+				return
+			}
+			go b.ServeConn(n)
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+/*
+	ListenWS accepts STOMP clients over WebSocket on addr.  Any URL path is
+	accepted.  It returns a ws:// URL for the listener.
+*/
+func (b *Broker) ListenWS(addr string) (string, error) {
+	ln, e := net.Listen("tcp", addr)
+	if e != nil {
+		return "", e
+	}
+	s := &http.Server{Handler: b}
+	b.lock.Lock()
+	b.srvs = append(b.srvs, s)
+	b.lock.Unlock()
+	go func() { _ = s.Serve(ln) }()
+	return "ws://" + ln.Addr().String() + "/", nil
+}
+
+/*
+	ServeConn serves one STOMP client on an established network connection.
+	It returns when the client disconnects or the broker is closed.
+*/
+func (b *Broker) ServeConn(n net.Conn) {
+	b.serve(newNetTransport(n))
+}
+
+/*
+	ServeHTTP upgrades the request to a WebSocket connection, and serves one
+	STOMP client on it.
+*/
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, e := b.upgrader.Upgrade(w, r, nil)
+	if e != nil {
+		return
+	}
+	b.serve(newWSTransport(ws))
+}
+
+/*
+	Close stops all listeners and disconnects all clients.
+*/
+func (b *Broker) Close() error {
+	b.lock.Lock()
+	b.closed = true
+	lns, srvs := b.lns, b.srvs
+	ss := make([]*session, 0, len(b.sessions))
+	for s := range b.sessions {
+		ss = append(ss, s)
+	}
+	b.lock.Unlock()
+	for _, ln := range lns {
+		_ = ln.Close()
+	}
+	for _, s := range srvs {
+		_ = s.Close()
+	}
+	for _, s := range ss {
+		s.t.Close()
+	}
+	return nil
+}
+
+/*
+	QueueDepth returns the number of messages waiting on a queue, not
+	counting messages delivered but not yet acknowledged.
+*/
+func (b *Broker) QueueDepth(d string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if q, ok := b.queues[d]; ok {
+		return len(q.msgs)
+	}
+	return 0
+}
+
+func (b *Broker) serve(t transport) {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		t.Close()
+		return
+	}
+	b.nsess++
+	s := newSession(b, t, "stomptest-"+strconv.FormatInt(b.nsess, 10))
+	b.sessions[s] = true
+	b.lock.Unlock()
+	s.run()
+	b.lock.Lock()
+	delete(b.sessions, s)
+	b.lock.Unlock()
+}
+
+/*
+	Choose the protocol level from a CONNECT accept-version header.
+*/
+func negotiate(av string) (string, bool) {
+	if av == "" {
+		return v10, true
+	}
+	best := ""
+	for _, v := range strings.Split(av, ",") {
+		v = strings.TrimSpace(v)
+		for _, sv := range versions {
+			if v == sv && v > best {
+				best = v
+			}
+		}
+	}
+	return best, best != ""
+}
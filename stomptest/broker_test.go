@@ -0,0 +1,298 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomptest
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	A raw STOMP test client.
+*/
+type testClient struct {
+	t *testing.T
+	n net.Conn
+	r *bufio.Reader
+	v string
+}
+
+func startBroker(t *testing.T, o *Options) (*Broker, string) {
+	b := NewBroker(o)
+	a, e := b.Listen("127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("Listen error: %v\n", e)
+	}
+	return b, a
+}
+
+/*
+	Test helper.  Dial and CONNECT, hdrs are extra CONNECT headers.
+*/
+func connect(t *testing.T, a string, v string, hdrs ...string) *testClient {
+	n, e := net.Dial("tcp", a)
+	if e != nil {
+		t.Fatalf("Dial error: %v\n", e)
+	}
+	c := &testClient{t: t, n: n, r: bufio.NewReader(n), v: v10}
+	h := hdrs
+	if v != v10 {
+		h = append([]string{"accept-version", v, "host", "localhost"}, h...)
+	}
+	c.send("CONNECT", h, "")
+	f := c.next()
+	if f.cmd != "CONNECTED" {
+		t.Fatalf("expected CONNECTED, got %s %v\n", f.cmd, f.hdrs)
+	}
+	c.v = v
+	return c
+}
+
+func (c *testClient) send(cmd string, h []string, b string) {
+	f := &frame{cmd: cmd, hdrs: h, body: []byte(b)}
+	if _, e := c.n.Write(f.bytes(c.v)); e != nil {
+		c.t.Fatalf("write error: %v\n", e)
+	}
+}
+
+func (c *testClient) next() *frame {
+	_ = c.n.SetReadDeadline(time.Now().Add(5 * time.Second))
+	f, e := readFrame(c.r, c.v)
+	if e != nil {
+		c.t.Fatalf("read error: %v\n", e)
+	}
+	return f
+}
+
+func (c *testClient) expect(cmd string) *frame {
+	f := c.next()
+	if f.cmd != cmd {
+		c.t.Fatalf("expected %s, got %s %v %q\n", cmd, f.cmd, f.hdrs, f.body)
+	}
+	return f
+}
+
+/*
+	Check nothing arrives for a short time.
+*/
+func (c *testClient) quiet() {
+	_ = c.n.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if f, e := readFrame(c.r, c.v); e == nil {
+		c.t.Fatalf("unexpected frame %s %v\n", f.cmd, f.hdrs)
+	}
+	c.r = bufio.NewReader(c.n)
+}
+
+/*
+	Test queue delivery, acknowledgement and receipts.
+*/
+func TestBrokerQueue(t *testing.T) {
+	b, a := startBroker(t, nil)
+	defer b.Close()
+	p := connect(t, a, v12)
+	p.send("SEND", []string{"destination", "/queue/q1", "receipt", "r1",
+		"app:key", "a\nb"}, "m1")
+	if f := p.expect("RECEIPT"); f.value("receipt-id") != "r1" {
+		t.Fatalf("expected receipt-id r1, got %v\n", f.hdrs)
+	}
+	if d := b.QueueDepth("/queue/q1"); d != 1 {
+		t.Fatalf("expected depth 1, got %d\n", d)
+	}
+	c := connect(t, a, v12)
+	c.send("SUBSCRIBE", []string{"destination", "/queue/q1", "id", "s1",
+		"ack", "client-individual"}, "")
+	f := c.expect("MESSAGE")
+	if string(f.body) != "m1" || f.value("subscription") != "s1" ||
+		f.value("app:key") != "a\nb" {
+		t.Fatalf("bad MESSAGE %v %q\n", f.hdrs, f.body)
+	}
+	c.send("ACK", []string{"id", f.value("ack"), "receipt", "r2"}, "")
+	c.expect("RECEIPT")
+	c.send("ACK", []string{"id", f.value("ack")}, "")
+	c.expect("ERROR") // Already acknowledged
+}
+
+/*
+	Test topic delivery to every subscriber, and no retention.
+*/
+func TestBrokerTopic(t *testing.T) {
+	b, a := startBroker(t, nil)
+	defer b.Close()
+	p := connect(t, a, v11)
+	p.send("SEND", []string{"destination", "/topic/t1", "receipt", "r0"}, "lost")
+	p.expect("RECEIPT")
+	c1 := connect(t, a, v10)
+	c1.send("SUBSCRIBE", []string{"destination", "/topic/t1", "receipt", "r1"}, "")
+	c1.expect("RECEIPT")
+	c2 := connect(t, a, v12)
+	c2.send("SUBSCRIBE", []string{"destination", "/topic/t1", "id", "s2",
+		"receipt", "r2"}, "")
+	c2.expect("RECEIPT")
+	p.send("SEND", []string{"destination", "/topic/t1"}, "kept")
+	for _, c := range []*testClient{c1, c2} {
+		if f := c.expect("MESSAGE"); string(f.body) != "kept" {
+			t.Fatalf("expected kept, got %q\n", f.body)
+		}
+	}
+}
+
+/*
+	Test unacknowledged messages are redelivered after a client leaves.
+*/
+func TestBrokerRedeliver(t *testing.T) {
+	b, a := startBroker(t, nil)
+	defer b.Close()
+	c := connect(t, a, v11)
+	c.send("SUBSCRIBE", []string{"destination", "/queue/q2", "id", "s1",
+		"ack", "client"}, "")
+	c.send("SEND", []string{"destination", "/queue/q2"}, "one")
+	c.send("SEND", []string{"destination", "/queue/q2"}, "two")
+	c.expect("MESSAGE")
+	f := c.expect("MESSAGE")
+	c.send("DISCONNECT", []string{"receipt", "bye"}, "")
+	c.expect("RECEIPT")
+	c = connect(t, a, v11)
+	c.send("SUBSCRIBE", []string{"destination", "/queue/q2", "id", "s1",
+		"ack", "client"}, "")
+	for _, w := range []string{"one", "two"} {
+		if f = c.expect("MESSAGE"); string(f.body) != w {
+			t.Fatalf("expected %s, got %q\n", w, f.body)
+		}
+	}
+	// Client mode ACK is cumulative
+	c.send("ACK", []string{"message-id", f.value("message-id"),
+		"subscription", "s1", "receipt", "r1"}, "")
+	c.expect("RECEIPT")
+	c.send("UNSUBSCRIBE", []string{"id", "s1", "receipt", "r2"}, "")
+	c.expect("RECEIPT")
+	if d := b.QueueDepth("/queue/q2"); d != 0 {
+		t.Fatalf("expected depth 0, got %d\n", d)
+	}
+}
+
+/*
+	Test transactions.
+*/
+func TestBrokerTransaction(t *testing.T) {
+	b, a := startBroker(t, nil)
+	defer b.Close()
+	c := connect(t, a, v12)
+	c.send("SUBSCRIBE", []string{"destination", "/queue/q3", "id", "s1"}, "")
+	c.send("BEGIN", []string{"transaction", "tx1"}, "")
+	c.send("SEND", []string{"destination", "/queue/q3", "transaction", "tx1"}, "one")
+	c.quiet()
+	c.send("COMMIT", []string{"transaction", "tx1"}, "")
+	if f := c.expect("MESSAGE"); string(f.body) != "one" {
+		t.Fatalf("expected one, got %q\n", f.body)
+	}
+	c.send("BEGIN", []string{"transaction", "tx2"}, "")
+	c.send("SEND", []string{"destination", "/queue/q3", "transaction", "tx2"}, "two")
+	c.send("ABORT", []string{"transaction", "tx2", "receipt", "r1"}, "")
+	c.expect("RECEIPT")
+	c.send("COMMIT", []string{"transaction", "tx2", "receipt", "r2"}, "")
+	if f := c.expect("ERROR"); f.value("receipt-id") != "r2" {
+		t.Fatalf("expected receipt-id r2, got %v\n", f.hdrs)
+	}
+}
+
+/*
+	Test ERROR frames for protocol violations.
+*/
+func TestBrokerErrors(t *testing.T) {
+	b, a := startBroker(t, &Options{Login: "user", Passcode: "pw"})
+	defer b.Close()
+	for _, h := range [][]string{
+		{"accept-version", "2.0", "login", "user", "passcode", "pw"},
+		{"accept-version", "1.2", "login", "user", "passcode", "bad"},
+		{"accept-version", "1.2", "login", "user", "passcode", "pw",
+			"heart-beat", "x,1"},
+	} {
+		n, e := net.Dial("tcp", a)
+		if e != nil {
+			t.Fatalf("Dial error: %v\n", e)
+		}
+		c := &testClient{t: t, n: n, r: bufio.NewReader(n), v: v10}
+		c.send("CONNECT", h, "")
+		c.expect("ERROR")
+		n.Close()
+	}
+	c := connect(t, a, v12, "login", "user", "passcode", "pw")
+	c.send("SEND", []string{"receipt", "r1"}, "no destination")
+	if f := c.expect("ERROR"); f.value("receipt-id") != "r1" {
+		t.Fatalf("expected receipt-id r1, got %v\n", f.hdrs)
+	}
+	// The broker closes the connection after ERROR
+	_ = c.n.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, e := readFrame(c.r, c.v); e == nil {
+		t.Fatalf("expected connection closed\n")
+	}
+}
+
+/*
+	Test broker heart beats.
+*/
+func TestBrokerHeartBeat(t *testing.T) {
+	b, a := startBroker(t, &Options{HeartBeat: "50,50"})
+	defer b.Close()
+	c := connect(t, a, v12, "heart-beat", "0,50")
+	_ = c.n.SetReadDeadline(time.Now().Add(time.Second))
+	if l, e := c.r.ReadString('\n'); e != nil || l != "\n" {
+		t.Fatalf("expected heart beat, got %q %v\n", l, e)
+	}
+}
+
+/*
+	Test a WebSocket client.
+*/
+func TestBrokerWS(t *testing.T) {
+	b := NewBroker(nil)
+	defer b.Close()
+	u, e := b.ListenWS("127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("ListenWS error: %v\n", e)
+	}
+	d := websocket.Dialer{Subprotocols: []string{"v12.stomp"}}
+	ws, _, e := d.Dial(u, nil)
+	if e != nil {
+		t.Fatalf("Dial error: %v\n", e)
+	}
+	defer ws.Close()
+	wr := bufio.NewReader(newWSTransport(ws))
+	for _, f := range []*frame{
+		{cmd: "CONNECT", hdrs: []string{"accept-version", "1.2", "host", "h"}},
+		{cmd: "SUBSCRIBE", hdrs: []string{"destination", "/queue/ws", "id", "s1"}},
+		{cmd: "SEND", hdrs: []string{"destination", "/queue/ws", "content-length", "2"},
+			body: []byte{0xff, 0}},
+	} {
+		if e = ws.WriteMessage(websocket.TextMessage, f.bytes(v12)); e != nil {
+			t.Fatalf("write error: %v\n", e)
+		}
+	}
+	for _, w := range []string{"CONNECTED", "MESSAGE"} {
+		f, e := readFrame(wr, v12)
+		if e != nil || f.cmd != w {
+			t.Fatalf("expected %s, got %v %v\n", w, f, e)
+		}
+		if w == "MESSAGE" && string(f.body) != "\xff\x00" {
+			t.Fatalf("expected binary body, got %q\n", f.body)
+		}
+	}
+}
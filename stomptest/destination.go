@@ -0,0 +1,204 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomptest
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+/*
+	A message accepted from a SEND frame.
+*/
+type message struct {
+	id   string
+	dest string
+	hdrs []string // Application headers from the SEND frame
+	body []byte
+	cl   bool // SEND frame had a content-length header
+}
+
+/*
+	Headers the broker sets itself, and does not copy from SEND.
+*/
+var brokerHeaders = map[string]bool{
+	"destination": true, "message-id": true, "subscription": true,
+	"ack": true, "receipt": true, "transaction": true, "content-length": true,
+}
+
+/*
+	A client subscription.  Messages delivered in client or
+	client-individual mode stay pending until ACK or NACK.
+*/
+type subscription struct {
+	s    *session
+	id   string
+	dest string
+	ack  string
+	pend []*delivery
+}
+
+type delivery struct {
+	ackid string
+	m     *message
+}
+
+type queue struct {
+	msgs []*message
+	subs []*subscription
+	next int // Round robin position
+}
+
+func isTopic(d string) bool {
+	return strings.HasPrefix(d, "/topic/")
+}
+
+/*
+	All functions below are called with the broker lock held.
+*/
+
+func (b *Broker) queue(d string) *queue {
+	q, ok := b.queues[d]
+	if !ok {
+		q = &queue{}
+		b.queues[d] = q
+	}
+	return q
+}
+
+/*
+	Accept a message from a SEND frame.
+*/
+func (b *Broker) publish(f *frame) {
+	b.nmsg++
+	m := &message{id: "stomptest-msg-" + strconv.FormatInt(b.nmsg, 10),
+		dest: f.value("destination"), body: f.body}
+	_, m.cl = f.header("content-length")
+	for i := 0; i < len(f.hdrs); i += 2 {
+		if !brokerHeaders[f.hdrs[i]] {
+			m.hdrs = append(m.hdrs, f.hdrs[i], f.hdrs[i+1])
+		}
+	}
+	if isTopic(m.dest) {
+		for _, sub := range b.topics[m.dest] {
+			b.deliver(sub, m)
+		}
+		return
+	}
+	q := b.queue(m.dest)
+	q.msgs = append(q.msgs, m)
+	b.dispatch(q)
+}
+
+/*
+	Hand queued messages to subscribers, round robin.
+*/
+func (b *Broker) dispatch(q *queue) {
+	for len(q.msgs) > 0 && len(q.subs) > 0 {
+		q.next %= len(q.subs)
+		sub := q.subs[q.next]
+		q.next++
+		m := q.msgs[0]
+		q.msgs = q.msgs[1:]
+		b.deliver(sub, m)
+	}
+}
+
+/*
+	Send a MESSAGE frame to a subscriber.
+*/
+func (b *Broker) deliver(sub *subscription, m *message) {
+	f := &frame{cmd: "MESSAGE", body: m.body}
+	f.hdrs = []string{"destination", m.dest, "message-id", m.id,
+		"subscription", sub.id}
+	if sub.ack != "auto" {
+		d := &delivery{ackid: m.id, m: m}
+		if sub.s.version == v12 {
+			sub.s.nack++
+			d.ackid = sub.s.id + "-" + strconv.FormatInt(sub.s.nack, 10)
+			f.hdrs = append(f.hdrs, "ack", d.ackid)
+		}
+		sub.pend = append(sub.pend, d)
+	}
+	if m.cl || bytes.IndexByte(m.body, 0) >= 0 {
+		f.hdrs = append(f.hdrs, "content-length", strconv.Itoa(len(m.body)))
+	}
+	f.hdrs = append(f.hdrs, m.hdrs...)
+	sub.s.send(f)
+}
+
+func (b *Broker) addSub(sub *subscription) {
+	if isTopic(sub.dest) {
+		b.topics[sub.dest] = append(b.topics[sub.dest], sub)
+		return
+	}
+	q := b.queue(sub.dest)
+	q.subs = append(q.subs, sub)
+	b.dispatch(q)
+}
+
+/*
+	Remove a subscription.  Unacknowledged queue messages are returned to
+	the front of the queue.
+*/
+func (b *Broker) removeSub(sub *subscription) {
+	if isTopic(sub.dest) {
+		b.topics[sub.dest] = removeFrom(b.topics[sub.dest], sub)
+		return
+	}
+	q := b.queue(sub.dest)
+	q.subs = removeFrom(q.subs, sub)
+	if len(sub.pend) > 0 {
+		ms := make([]*message, 0, len(sub.pend)+len(q.msgs))
+		for _, d := range sub.pend {
+			ms = append(ms, d.m)
+		}
+		q.msgs = append(ms, q.msgs...)
+		sub.pend = nil
+	}
+	b.dispatch(q)
+}
+
+func removeFrom(l []*subscription, sub *subscription) []*subscription {
+	r := l[:0]
+	for _, s := range l {
+		if s != sub {
+			r = append(r, s)
+		}
+	}
+	return r
+}
+
+/*
+	Settle pending deliveries for an ACK or NACK.  In client mode all earlier
+	deliveries are settled too.  Returns false if nothing matched.
+*/
+func (sub *subscription) settle(ackid string) bool {
+	for i, d := range sub.pend {
+		if d.ackid != ackid {
+			continue
+		}
+		if sub.ack == "client" {
+			sub.pend = sub.pend[i+1:]
+		} else {
+			sub.pend = append(sub.pend[:i], sub.pend[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
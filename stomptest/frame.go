@@ -0,0 +1,81 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomptest
+
+import (
+	"bufio"
+	"bytes"
+
+	stompframe "github.com/drawdy/stomp-ws-go/frame"
+)
+
+/*
+	A STOMP frame as seen by the broker.  Headers are key / value pairs in
+	wire order, duplicates included.
+*/
+type frame struct {
+	cmd  string
+	hdrs []string
+	body []byte
+}
+
+/*
+	First value for a header key.
+*/
+func (f *frame) header(k string) (string, bool) {
+	for i := 0; i < len(f.hdrs); i += 2 {
+		if f.hdrs[i] == k {
+			return f.hdrs[i+1], true
+		}
+	}
+	return "", false
+}
+
+func (f *frame) value(k string) string {
+	v, _ := f.header(k)
+	return v
+}
+
+/*
+	Read one frame.  Heart beat EOLs before the command are skipped.  Header
+	values are unescaped for STOMP 1.1+, except on CONNECT / STOMP.
+*/
+func readFrame(r *bufio.Reader, v string) (*frame, error) {
+	d := stompframe.NewDecoder(r)
+	d.Version = v
+	for {
+		f, e := d.Decode()
+		if e != nil {
+			return nil, e
+		}
+		if !f.IsHeartBeat() {
+			return &frame{cmd: f.Command, hdrs: f.Headers, body: f.Body}, nil
+		}
+	}
+}
+
+/*
+	Encode a frame for the wire.  Headers are escaped for STOMP 1.1+, except
+	on CONNECTED.
+*/
+func (f *frame) bytes(v string) []byte {
+	var b bytes.Buffer
+	en := stompframe.NewEncoder(&b)
+	en.Version = v
+	_ = en.Encode(&stompframe.Frame{Command: f.cmd, Headers: f.hdrs, Body: f.body})
+	return b.Bytes()
+}
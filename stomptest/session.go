@@ -0,0 +1,454 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomptest
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	One client connection.
+
+	The session goroutine reads and handles client frames.  Outbound frames
+	are queued without blocking, and written by a separate writer goroutine,
+	so a slow client never blocks the broker.
+*/
+type session struct {
+	b       *Broker
+	t       transport
+	id      string
+	version string
+	subs    map[string]*subscription // By subscription id
+	txs     map[string][]*frame      // Open transactions
+	nack    int64                    // Ack id counter, under broker lock
+	lr      int64                    // Last read, UnixNano, atomic
+	hbs     time.Duration            // Heart beat send interval
+	hbr     time.Duration            // Expected client heart beat interval
+	//
+	wlock   sync.Mutex
+	out     [][]byte
+	closing bool // Close the transport once out is written
+	wake    chan struct{}
+	done    chan struct{} // Writer done
+}
+
+func newSession(b *Broker, t transport, id string) *session {
+	return &session{b: b, t: t, id: id, version: v10,
+		subs: make(map[string]*subscription), txs: make(map[string][]*frame),
+		wake: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+/*
+	Records the time of every successful read, for heart beat checks.
+*/
+type readTracker struct {
+	s *session
+}
+
+func (r readTracker) Read(p []byte) (int, error) {
+	n, e := r.s.t.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&r.s.lr, time.Now().UnixNano())
+	}
+	return n, e
+}
+
+/*
+	A STOMP protocol violation.  The broker sends ERROR and closes the
+	connection.
+*/
+type protoError struct {
+	msg string
+}
+
+func perr(msg string) *protoError {
+	return &protoError{msg: msg}
+}
+
+/*
+	Session main loop.
+*/
+func (s *session) run() {
+	r := bufio.NewReader(readTracker{s})
+	f, e := readFrame(r, v10)
+	if e != nil {
+		s.t.Close()
+		return
+	}
+	pe := s.connect(f)
+	go s.writer()
+	if pe != nil {
+		s.sendError(f, pe)
+		s.finish(true)
+		return
+	}
+	graceful := false
+	for {
+		f, e = readFrame(r, s.version)
+		if e != nil {
+			break
+		}
+		if f.cmd == "DISCONNECT" {
+			s.receipt(f)
+			graceful = true
+			break
+		}
+		if pe = s.handle(f); pe != nil {
+			s.sendError(f, pe)
+			graceful = true
+			break
+		}
+		s.receipt(f)
+	}
+	s.finish(graceful)
+}
+
+/*
+	Handle CONNECT or STOMP, and queue the CONNECTED frame.
+*/
+func (s *session) connect(f *frame) *protoError {
+	if f.cmd != "CONNECT" && f.cmd != "STOMP" {
+		return perr("CONNECT expected")
+	}
+	av, hasav := f.header("accept-version")
+	v, ok := negotiate(av)
+	if !ok {
+		return &protoError{msg: "Supported protocol versions are " +
+			strings.Join(versions, " ")}
+	}
+	s.version = v
+	if s.b.opts.Login != "" && (f.value("login") != s.b.opts.Login ||
+		f.value("passcode") != s.b.opts.Passcode) {
+		return perr("Access refused")
+	}
+	c := &frame{cmd: "CONNECTED"}
+	if hasav {
+		c.hdrs = append(c.hdrs, "version", v)
+	}
+	c.hdrs = append(c.hdrs, "session", s.id, "server", s.b.opts.Server)
+	if v != v10 {
+		cx, cy, ok := parseHeartBeat(f.value("heart-beat"))
+		if !ok {
+			return perr("invalid heart-beat header")
+		}
+		sx, sy, _ := parseHeartBeat(s.b.opts.HeartBeat)
+		if sx > 0 && cy > 0 {
+			s.hbs = maxDuration(sx, cy)
+		}
+		if sy > 0 && cx > 0 {
+			s.hbr = maxDuration(sy, cx)
+		}
+		c.hdrs = append(c.hdrs, "heart-beat", s.b.opts.HeartBeat)
+	}
+	s.send(c)
+	return nil
+}
+
+/*
+	Parse a heart-beat header value.  An empty value means "0,0".
+*/
+func parseHeartBeat(v string) (time.Duration, time.Duration, bool) {
+	if v == "" {
+		return 0, 0, true
+	}
+	p := strings.Split(v, ",")
+	if len(p) != 2 {
+		return 0, 0, false
+	}
+	x, e1 := strconv.ParseInt(strings.TrimSpace(p[0]), 10, 64)
+	y, e2 := strconv.ParseInt(strings.TrimSpace(p[1]), 10, 64)
+	if e1 != nil || e2 != nil || x < 0 || y < 0 {
+		return 0, 0, false
+	}
+	return time.Duration(x) * time.Millisecond,
+		time.Duration(y) * time.Millisecond, true
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+/*
+	Handle one client frame after CONNECT.
+*/
+func (s *session) handle(f *frame) *protoError {
+	switch f.cmd {
+	case "SEND":
+		if f.value("destination") == "" {
+			return perr("destination required, SEND")
+		}
+		return s.transact(f)
+	case "ACK", "NACK":
+		if f.cmd == "NACK" && s.version == v10 {
+			return perr("NACK not supported, STOMP 1.0")
+		}
+		return s.transact(f)
+	case "SUBSCRIBE":
+		return s.subscribe(f)
+	case "UNSUBSCRIBE":
+		return s.unsubscribe(f)
+	case "BEGIN":
+		tx := f.value("transaction")
+		if tx == "" {
+			return perr("transaction required, BEGIN")
+		}
+		if _, ok := s.txs[tx]; ok {
+			return perr("transaction already started: " + tx)
+		}
+		s.txs[tx] = []*frame{}
+		return nil
+	case "COMMIT", "ABORT":
+		tx := f.value("transaction")
+		fs, ok := s.txs[tx]
+		if !ok {
+			return perr("unknown transaction, " + f.cmd + ": " + tx)
+		}
+		delete(s.txs, tx)
+		if f.cmd == "ABORT" {
+			return nil
+		}
+		for _, tf := range fs {
+			if pe := s.apply(tf); pe != nil {
+				return pe
+			}
+		}
+		return nil
+	}
+	return perr("unknown command: " + f.cmd)
+}
+
+/*
+	Apply SEND, ACK or NACK now, or hold it in its transaction.
+*/
+func (s *session) transact(f *frame) *protoError {
+	tx, ok := f.header("transaction")
+	if !ok {
+		return s.apply(f)
+	}
+	fs, ok := s.txs[tx]
+	if !ok {
+		return perr("unknown transaction, " + f.cmd + ": " + tx)
+	}
+	s.txs[tx] = append(fs, f)
+	return nil
+}
+
+func (s *session) apply(f *frame) *protoError {
+	s.b.lock.Lock()
+	defer s.b.lock.Unlock()
+	if f.cmd == "SEND" {
+		s.b.publish(f)
+		return nil
+	}
+	// ACK or NACK
+	ak := "message-id"
+	if s.version == v12 {
+		ak = "id"
+	}
+	id := f.value(ak)
+	if id == "" {
+		return perr(ak + " required, " + f.cmd)
+	}
+	sid, bysub := f.header("subscription")
+	for _, sub := range s.subs {
+		if bysub && sub.id != sid {
+			continue
+		}
+		if sub.settle(id) {
+			return nil
+		}
+	}
+	return perr("no pending message, " + f.cmd + ": " + id)
+}
+
+func (s *session) subscribe(f *frame) *protoError {
+	d := f.value("destination")
+	if d == "" {
+		return perr("destination required, SUBSCRIBE")
+	}
+	id, ok := f.header("id")
+	if !ok {
+		if s.version != v10 {
+			return perr("id required, SUBSCRIBE")
+		}
+		id = d
+	}
+	if _, ok = s.subs[id]; ok {
+		return perr("duplicate subscription id: " + id)
+	}
+	am := f.value("ack")
+	switch am {
+	case "":
+		am = "auto"
+	case "auto", "client", "client-individual":
+	default:
+		return perr("invalid ack mode: " + am)
+	}
+	sub := &subscription{s: s, id: id, dest: d, ack: am}
+	s.subs[id] = sub
+	s.b.lock.Lock()
+	s.b.addSub(sub)
+	s.b.lock.Unlock()
+	return nil
+}
+
+func (s *session) unsubscribe(f *frame) *protoError {
+	id, ok := f.header("id")
+	if !ok && s.version == v10 {
+		d := f.value("destination")
+		for _, sub := range s.subs {
+			if sub.dest == d {
+				id, ok = sub.id, true
+			}
+		}
+	}
+	sub, found := s.subs[id]
+	if !ok || !found {
+		return perr("unknown subscription, UNSUBSCRIBE: " + id)
+	}
+	delete(s.subs, id)
+	s.b.lock.Lock()
+	s.b.removeSub(sub)
+	s.b.lock.Unlock()
+	return nil
+}
+
+/*
+	Send a RECEIPT if the frame asked for one.
+*/
+func (s *session) receipt(f *frame) {
+	if r, ok := f.header("receipt"); ok {
+		s.send(&frame{cmd: "RECEIPT", hdrs: []string{"receipt-id", r}})
+	}
+}
+
+func (s *session) sendError(f *frame, pe *protoError) {
+	e := &frame{cmd: "ERROR", hdrs: []string{"message", pe.msg}}
+	if r, ok := f.header("receipt"); ok {
+		e.hdrs = append(e.hdrs, "receipt-id", r)
+	}
+	if strings.HasPrefix(pe.msg, "Supported protocol") {
+		e.hdrs = append(e.hdrs, "version", strings.Join(versions, ","))
+	}
+	e.hdrs = append(e.hdrs, "content-type", "text/plain")
+	e.body = []byte(pe.msg + "\n" + f.cmd + "\n")
+	s.send(e)
+}
+
+/*
+	Queue a frame for the writer.  Never blocks.
+*/
+func (s *session) send(f *frame) {
+	b := f.bytes(s.version)
+	s.wlock.Lock()
+	if !s.closing {
+		s.out = append(s.out, b)
+	}
+	s.wlock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+/*
+	Session end.  Subscriptions are removed, unacknowledged messages are
+	requeued, and open transactions are discarded.  A graceful finish writes
+	any queued frames before closing the transport.
+*/
+func (s *session) finish(graceful bool) {
+	if !graceful {
+		s.t.Close()
+	}
+	s.b.lock.Lock()
+	for _, sub := range s.subs {
+		s.b.removeSub(sub)
+	}
+	s.b.lock.Unlock()
+	s.subs = nil
+	s.txs = nil
+	s.wlock.Lock()
+	s.closing = true
+	s.wlock.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	<-s.done
+}
+
+/*
+	Session writer, including heart beats.  Also closes the transport if
+	client heart beats stop.
+*/
+func (s *session) writer() {
+	defer close(s.done)
+	var hbsc, hbrc <-chan time.Time
+	if s.hbs > 0 {
+		t := time.NewTicker(s.hbs / 2)
+		defer t.Stop()
+		hbsc = t.C
+	}
+	if s.hbr > 0 {
+		t := time.NewTicker(s.hbr)
+		defer t.Stop()
+		hbrc = t.C
+	}
+	lw := time.Now()
+	for {
+		s.wlock.Lock()
+		out, closing := s.out, s.closing
+		s.out = nil
+		s.wlock.Unlock()
+		for _, b := range out {
+			if s.t.Write(b) != nil {
+				s.t.Close()
+				return
+			}
+			lw = time.Now()
+		}
+		if closing {
+			s.t.Shutdown()
+			return
+		}
+		select {
+		case <-s.wake:
+		case <-hbsc:
+			if time.Since(lw) >= s.hbs/2 {
+				if s.t.Write([]byte("\n")) != nil {
+					s.t.Close()
+					return
+				}
+				lw = time.Now()
+			}
+		case <-hbrc:
+			lr := time.Unix(0, atomic.LoadInt64(&s.lr))
+			if time.Since(lr) > 2*s.hbr {
+				s.t.Close() // Client is gone
+				return
+			}
+		}
+	}
+}
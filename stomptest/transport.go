@@ -0,0 +1,125 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stomptest
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	A client connection.  Write is only called by the session writer.
+*/
+type transport interface {
+	io.Reader
+	Write(b []byte) error
+	Close()    // Abrupt close
+	Shutdown() // Close after the client has seen all output
+}
+
+type netTransport struct {
+	n net.Conn
+}
+
+func newNetTransport(n net.Conn) *netTransport {
+	return &netTransport{n: n}
+}
+
+func (t *netTransport) Read(p []byte) (int, error) {
+	return t.n.Read(p)
+}
+
+func (t *netTransport) Write(b []byte) error {
+	_, e := t.n.Write(b)
+	return e
+}
+
+func (t *netTransport) Close() {
+	_ = t.n.Close()
+}
+
+/*
+	Half close, and discard input until the client closes, so unread input
+	does not cause a reset that loses the last frames written.
+*/
+func (t *netTransport) Shutdown() {
+	if tc, ok := t.n.(*net.TCPConn); ok {
+		_ = tc.CloseWrite()
+		_ = tc.SetReadDeadline(time.Now().Add(time.Second))
+		_, _ = io.Copy(ioutil.Discard, tc)
+	}
+	_ = t.n.Close()
+}
+
+/*
+	WebSocket transport.  Received messages are read as one byte stream, so
+	frames may be split over, or batched in, messages.  Each frame is sent as
+	one message: text, or binary if it is not valid UTF-8.
+*/
+type wsTransport struct {
+	ws *websocket.Conn
+	r  io.Reader // Current message
+}
+
+func newWSTransport(ws *websocket.Conn) *wsTransport {
+	return &wsTransport{ws: ws}
+}
+
+func (t *wsTransport) Read(p []byte) (int, error) {
+	for {
+		if t.r == nil {
+			_, r, e := t.ws.NextReader()
+			if e != nil {
+				return 0, e
+			}
+			t.r = r
+		}
+		n, e := t.r.Read(p)
+		if e == io.EOF {
+			t.r = nil
+			if n == 0 {
+				continue
+			}
+			e = nil
+		}
+		return n, e
+	}
+}
+
+func (t *wsTransport) Write(b []byte) error {
+	mt := websocket.TextMessage
+	if !utf8.Valid(b) {
+		mt = websocket.BinaryMessage
+	}
+	return t.ws.WriteMessage(mt, b)
+}
+
+func (t *wsTransport) Close() {
+	_ = t.ws.Close()
+}
+
+func (t *wsTransport) Shutdown() {
+	_ = t.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	_ = t.ws.Close()
+}
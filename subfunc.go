@@ -0,0 +1,208 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"sync"
+)
+
+/*
+	SubscribeOptions controls a SubscribeFunc subscription.  A nil
+	*SubscribeOptions, or any zero field, means use the default.
+*/
+type SubscribeOptions struct {
+	Workers     int                      // Handler goroutines, default 1
+	NackOnError bool                     // NACK a failed message (1.1+), default leave it un-acked
+	OnError     func(MessageData, error) // Called with handler, ACK and NACK errors
+}
+
+/*
+	Subscription is a subscription served by handler goroutines, see
+	SubscribeFunc.
+*/
+type Subscription struct {
+	c    *Connection
+	sub  *subscription
+	hdrs Headers // SUBSCRIBE headers, as sent
+	hf   func(MessageData) error
+	o    SubscribeOptions
+	quit chan struct{} // Closed by Unsubscribe
+	once sync.Once
+	done chan struct{}
+}
+
+/*
+	SubscribeFunc subscribes, and calls hf for each MessageData received on
+	the subscription, from o.Workers goroutines.
+
+	For the client and client-individual ack modes, a message is ACKed when
+	hf returns nil.  When hf returns an error the message is NACKed if
+	o.NackOnError is set and the protocol level is 1.1+, otherwise it is left
+	un-acked for the broker to redeliver.  Note that a client mode ACK is
+	cumulative, so use client-individual with more than one worker.
+
+	The workers stop after Unsubscribe, or when the connection shuts down.
+	Messages not yet handled are then dropped, and in the client ack modes
+	are redelivered by the broker.
+
+	Example:
+		h := stompngo.Headers{stompngo.HK_DESTINATION, "/queue/myqueue",
+			stompngo.HK_ACK, stompngo.AckModeClientIndividual}
+		s, e := c.SubscribeFunc(h, func(md stompngo.MessageData) error {
+			return process(md.Message.Body)
+		}, &stompngo.SubscribeOptions{Workers: 4})
+		if e != nil {
+			// Do something sane ...
+		}
+		// ...
+		e = s.Unsubscribe()
+*/
+func (c *Connection) SubscribeFunc(h Headers, hf func(MessageData) error,
+	o *SubscribeOptions) (*Subscription, error) {
+	if hf == nil {
+		return nil, ENOHNDLR
+	}
+	ch := h.Clone()
+	if _, ok := ch.Contains(HK_ID); !ok && c.Protocol() != SPL_10 {
+		ch = ch.Add(HK_ID, Uuid()) // So the subscription can be found
+	}
+	mdc, e := c.Subscribe(ch)
+	if e != nil {
+		return nil, e
+	}
+	id := ch.Value(HK_ID)
+	if id == "" { // 1.0, id generated from the destination
+		id = Sha1(ch.Value(HK_DESTINATION))
+	}
+	c.subsLock.RLock()
+	sub := c.subs[id]
+	c.subsLock.RUnlock()
+	if sub == nil || sub.md != mdc {
+		return nil, EBADSID // Unsubscribed already
+	}
+	s := &Subscription{c: c, sub: sub, hdrs: sub.hdrs, hf: hf,
+		quit: make(chan struct{}), done: make(chan struct{})}
+	if o != nil {
+		s.o = *o
+	}
+	if s.o.Workers < 1 {
+		s.o.Workers = 1
+	}
+	go s.run()
+	return s, nil
+}
+
+/*
+	Id returns the subscription id.
+*/
+func (s *Subscription) Id() string {
+	return s.sub.id
+}
+
+/*
+	Done returns a channel that is closed when all workers have stopped.
+*/
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+/*
+	Unsubscribe stops the workers, waits for running handlers and their ACKs
+	to complete, and then sends UNSUBSCRIBE.  Messages received in between
+	are dropped.  Do not call it from a handler.
+*/
+func (s *Subscription) Unsubscribe() error {
+	s.once.Do(func() { close(s.quit) })
+	<-s.done
+	go s.c.discard(s.sub) // Until the subscription is deleted
+	h := Headers{HK_ID, s.sub.id}
+	if d, ok := s.hdrs.Contains(HK_DESTINATION); ok {
+		h = h.Add(HK_DESTINATION, d)
+	}
+	return s.c.Unsubscribe(h)
+}
+
+/*
+	Feed the workers until the subscription stops.
+*/
+func (s *Subscription) run() {
+	w := make(chan MessageData)
+	var wg sync.WaitGroup
+	for i := 0; i < s.o.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for md := range w {
+				s.handle(md)
+			}
+		}()
+	}
+feed:
+	for {
+		select {
+		case md, ok := <-s.sub.md:
+			if !ok {
+				break feed // Connection shutdown
+			}
+			select {
+			case w <- md:
+			case <-s.sub.stop:
+				break feed
+			case <-s.quit:
+				break feed
+			}
+		case <-s.sub.stop:
+			break feed
+		case <-s.quit:
+			break feed
+		}
+	}
+	close(w)
+	wg.Wait()
+	close(s.done)
+}
+
+/*
+	Call the handler for one MessageData, then ACK or NACK as required.
+*/
+func (s *Subscription) handle(md MessageData) {
+	he := s.hf(md)
+	if he != nil {
+		s.onError(md, he)
+	}
+	if md.Error != nil || md.Message.Command != MESSAGE ||
+		(s.sub.am != AckModeClient && s.sub.am != AckModeClientIndividual) {
+		return
+	}
+	d := &Delivery{MessageData: md, c: s.c, sid: s.sub.id, am: s.sub.am}
+	var e error
+	switch {
+	case he == nil:
+		e = d.Ack()
+	case s.o.NackOnError && s.c.Protocol() != SPL_10:
+		e = d.Nack()
+	}
+	if e != nil {
+		s.onError(md, e)
+	}
+}
+
+func (s *Subscription) onError(md MessageData, e error) {
+	if s.o.OnError != nil {
+		s.o.OnError(md, e)
+	}
+}
@@ -0,0 +1,182 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/drawdy/stomp-ws-go/stomptest"
+)
+
+/*
+	Test helper.  Connect to a new in memory broker.
+*/
+func connectStomptest(t *testing.T, v string) (*stomptest.Broker, *Connection) {
+	b := stomptest.NewBroker(nil)
+	a, e := b.Listen("127.0.0.1:0")
+	if e != nil {
+		t.Fatalf("Listen expected nil, got %v\n", e)
+	}
+	n, e := net.Dial("tcp", a)
+	if e != nil {
+		t.Fatalf("Dial expected nil, got %v\n", e)
+	}
+	c, e := Connect(n, Headers{HK_ACCEPT_VERSION, v, HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("CONNECT expected nil, got %v\n", e)
+	}
+	return b, c
+}
+
+/*
+	Test SubscribeFunc with several workers and client-individual acks.
+*/
+func TestSubscribeFunc(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	const n = 20
+	got := make(chan string, n)
+	errs := make(chan error, n)
+	hf := func(md MessageData) error {
+		got <- string(md.Message.Body)
+		if string(md.Message.Body) == "bad" {
+			return Error("bad message")
+		}
+		return nil
+	}
+	o := &SubscribeOptions{Workers: 4,
+		OnError: func(md MessageData, e error) { errs <- e }}
+	h := Headers{HK_DESTINATION, "/queue/subfunc", HK_ACK, AckModeClientIndividual}
+	s, e := c.SubscribeFunc(h, hf, o)
+	if e != nil {
+		t.Fatalf("TestSubscribeFunc expected nil, got %v\n", e)
+	}
+	for i := 0; i < n-1; i++ {
+		e = c.Send(Headers{HK_DESTINATION, "/queue/subfunc"}, strconv.Itoa(i))
+		if e != nil {
+			t.Fatalf("TestSubscribeFunc SEND expected nil, got %v\n", e)
+		}
+	}
+	_ = c.Send(Headers{HK_DESTINATION, "/queue/subfunc"}, "bad")
+	for i := 0; i < n; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestSubscribeFunc timeout, %d handled\n", i)
+		}
+	}
+	select {
+	case e = <-errs:
+		if e.Error() != "bad message" {
+			t.Fatalf("TestSubscribeFunc expected bad message, got %v\n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestSubscribeFunc OnError timeout\n")
+	}
+	// ACKs are sent before UNSUBSCRIBE, the failed message is returned
+	if e = s.Unsubscribe(); e != nil {
+		t.Fatalf("TestSubscribeFunc Unsubscribe expected nil, got %v\n", e)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestSubscribeFunc Done timeout\n")
+	}
+	_ = c.Disconnect(Headers{HK_RECEIPT, "subfunc"})
+	if d := b.QueueDepth("/queue/subfunc"); d != 1 {
+		t.Fatalf("TestSubscribeFunc expected depth 1, got %d\n", d)
+	}
+}
+
+/*
+	Test SubscribeFunc workers stop when the connection shuts down.
+*/
+func TestSubscribeFuncShutdown(t *testing.T) {
+	b, c := connectStomptest(t, SPL_11)
+	defer b.Close()
+	if _, e := c.SubscribeFunc(Headers{HK_DESTINATION, "/queue/x"}, nil, nil); e != ENOHNDLR {
+		t.Fatalf("TestSubscribeFuncShutdown expected [%v], got [%v]\n", ENOHNDLR, e)
+	}
+	s, e := c.SubscribeFunc(Headers{HK_DESTINATION, "/queue/x"},
+		func(md MessageData) error { return nil }, nil)
+	if e != nil {
+		t.Fatalf("TestSubscribeFuncShutdown expected nil, got %v\n", e)
+	}
+	_ = c.Disconnect(empty_headers)
+	select {
+	case <-s.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestSubscribeFuncShutdown Done timeout\n")
+	}
+}
+
+/*
+	Test Unsubscribe with a full subscription channel, and the reader
+	waiting to deliver more.
+*/
+func TestSubscribeFuncUnsubscribeFull(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	hf := func(md MessageData) error {
+		select {
+		case started <- true:
+		default:
+		}
+		<-release
+		return nil
+	}
+	h := Headers{HK_DESTINATION, "/queue/subfunc.full", HK_ID, "full",
+		StompPlusMaxInFlight, "1"}
+	s, e := c.SubscribeFunc(h, hf, nil)
+	if e != nil {
+		t.Fatalf("TestSubscribeFuncUnsubscribeFull expected nil, got %v\n", e)
+	}
+	for i := 0; i < 5; i++ {
+		_ = c.Send(Headers{HK_DESTINATION, "/queue/subfunc.full"}, strconv.Itoa(i))
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestSubscribeFuncUnsubscribeFull handler timeout\n")
+	}
+	for i := 0; len(s.sub.md) < cap(s.sub.md); i++ {
+		if i == 500 {
+			t.Fatalf("TestSubscribeFuncUnsubscribeFull channel not full\n")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ue := make(chan error, 1)
+	go func() { ue <- s.Unsubscribe() }()
+	// The feed stops before the handler returns, nothing reads the channel
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	select {
+	case e = <-ue:
+		if e != nil {
+			t.Fatalf("TestSubscribeFuncUnsubscribeFull expected nil, got %v\n", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestSubscribeFuncUnsubscribeFull Unsubscribe deadlock\n")
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
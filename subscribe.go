@@ -17,6 +17,7 @@
 package stompws
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
@@ -56,7 +57,15 @@ var _ = fmt.Println
 
 */
 func (c *Connection) Subscribe(h Headers) (<-chan MessageData, error) {
-	c.log(SUBSCRIBE, "start", h, c.Protocol())
+	return c.SubscribeContext(context.Background(), h)
+}
+
+/*
+	SubscribeContext is like Subscribe, but ctx can cancel the operation, or
+	set a deadline for it.  If ctx is done first, ctx.Err() is returned.
+*/
+func (c *Connection) SubscribeContext(ctx context.Context, h Headers) (<-chan MessageData, error) {
+	c.log(LogLifecycle, LevelDebug, "SUBSCRIBE start", "headers", h, "protocol", c.Protocol())
 	if !c.isConnected() {
 		return nil, ECONBAD
 	}
@@ -79,15 +88,46 @@ func (c *Connection) Subscribe(h Headers) (<-chan MessageData, error) {
 	//
 	f := Frame{SUBSCRIBE, ch, NULLBUFF}
 	//
-	r := make(chan error)
-	if e = c.writeWireData(wiredata{f, r}); e != nil {
+	r, e := c.enqueue(ctx, f)
+	if e != nil {
+		// Never enqueued, the broker will not see this SUBSCRIBE
+		c.deleteSubscription(sub.id)
 		return nil, e
 	}
-	e = <-r
-	c.log(SUBSCRIBE, "end", ch, c.Protocol())
+	select {
+	case e = <-r:
+	case <-ctx.Done():
+		// The SUBSCRIBE will still be written.  Do not leave a subscription
+		// that no client will ever read.
+		go c.abandonSubscription(sub)
+		return nil, ctx.Err()
+	}
+	c.log(LogLifecycle, LevelDebug, "SUBSCRIBE end", "headers", ch, "protocol", c.Protocol())
 	return sub.md, e
 }
 
+/*
+	Cancel a subscription whose SUBSCRIBE is already queued to the writer.
+	The UNSUBSCRIBE is queued after it, and the subscription is kept until
+	that is written, so MESSAGE frames sent in between still find it.  They
+	are dropped.
+*/
+func (c *Connection) abandonSubscription(sub *subscription) {
+	go c.discard(sub) // No client reads sub.md
+	h := Headers{HK_ID, sub.id}
+	if d, ok := sub.hdrs.Contains(HK_DESTINATION); ok {
+		h = h.Add(HK_DESTINATION, d)
+	}
+	if r, e := c.enqueue(context.Background(), Frame{UNSUBSCRIBE, h, NULLBUFF}); e == nil {
+		select {
+		case <-r:
+		case <-c.ssdc:
+		}
+	}
+	c.deleteSubscription(sub.id)
+	c.log(LogLifecycle, LevelDebug, "SUBSCRIBE abandoned", "headers", h)
+}
+
 /*
 	Check SUBSCRIBE specific requirements.
 */
@@ -95,6 +135,9 @@ func (c *Connection) checkSubscribeHeaders(h Headers) error {
 	if _, ok := h.Contains(HK_DESTINATION); !ok {
 		return EREQDSTSUB
 	}
+	if e := checkFlowHeaders(h); e != nil {
+		return e
+	}
 	//
 	am, ok := h.Contains(HK_ACK)
 	//
@@ -114,7 +157,7 @@ func (c *Connection) checkSubscribeHeaders(h Headers) error {
 			}
 		}
 	default:
-		log.Fatalf("Internal protocol level error:<%s>\n", c.Protocol())
+		return EBADPROTO
 	}
 	return nil
 }
@@ -123,8 +166,8 @@ func (c *Connection) checkSubscribeHeaders(h Headers) error {
 	Handle subscribe id.
 */
 func (c *Connection) establishSubscription(h Headers) (*subscription, error, Headers) {
-	c.log(SUBSCRIBE, "start establishSubscription")
-	defer c.log(SUBSCRIBE, "end establishSubscription")
+	c.log(LogLifecycle, LevelDebug, "SUBSCRIBE start establishSubscription")
+	defer c.log(LogLifecycle, LevelDebug, "SUBSCRIBE end establishSubscription")
 	//
 	id, hid := h.Contains(HK_ID)
 	uuid1 := Uuid()
@@ -159,6 +202,7 @@ func (c *Connection) establishSubscription(h Headers) (*subscription, error, Hea
 	sd.drmc = 0                           // Current drain count
 	sd.md = make(chan MessageData, c.scc) // Make subscription MD channel
 	sd.am = h.Value(HK_ACK)               // Set subscription ack mode
+	sd.stop = make(chan struct{})         // Closed on UNSUBSCRIBE
 	//
 	if !hid {
 		// No caller supplied ID.  This STOMP client package supplies one.  It is the
@@ -175,7 +219,7 @@ func (c *Connection) establishSubscription(h Headers) (*subscription, error, Hea
 			sd.id = uuid1
 			h = h.Add(HK_ID, uuid1)
 		default:
-			log.Fatalf("Internal protocol level error:<%s>\n", c.Protocol())
+			return nil, EBADPROTO, h
 		}
 	}
 
@@ -190,6 +234,10 @@ func (c *Connection) establishSubscription(h Headers) (*subscription, error, Hea
 		}
 	}
 
+	h = c.setFlow(sd, h) // STOMP Protocol Enhancement, see flow.go
+
+	sd.hdrs = h // For any replay on reconnect
+
 	// This is a write lock
 	c.subsLock.Lock()
 	c.subs[sd.id] = sd // Add subscription to the connection subscription map
@@ -919,6 +919,38 @@ const (
 // None at present.
 )
 
+//=============================================================================
+//= failover_test type ========================================================
+//=============================================================================
+type (
+	failoverParseData struct {
+		uri string
+		n   int   // Expected endpoint count
+		rnd bool  // Expected randomize
+		exe error // Expected error
+	}
+)
+
+//=============================================================================
+//= failover_test var =========================================================
+//=============================================================================
+var (
+	failoverParseList = []failoverParseData{
+		{"failover:(tcp://a:61613,tcp://b:61613)", 2, false, nil},
+		{"failover:(tcp://a:61613,ws://b:8080/stomp)?randomize=true", 2, true, nil},
+		{"failover:tcp://a:61613,ssl://b:61614", 2, false, nil},
+		{"failover:()", 0, false, ENOENDPT},
+		{"failover:(tcp://a:61613", 0, false, EBADENDP},
+	}
+)
+
+//=============================================================================
+//= failover_test const =======================================================
+//=============================================================================
+const (
+// None at present.
+)
+
 //=============================================================================
 //= for use by all type =======================================================
 //=============================================================================
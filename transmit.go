@@ -16,6 +16,10 @@
 
 package stompws
 
+import (
+	"context"
+)
+
 /*
 	Common transmit data for many stomp API calls.
 */
@@ -29,3 +33,51 @@ func (c *Connection) transmitCommon(v string, h Headers) error {
 	e := <-r
 	return e
 }
+
+/*
+	Common transmit data, with cancellation.
+*/
+func (c *Connection) transmitCommonContext(ctx context.Context, v string,
+	h Headers) error {
+	ch := h.Clone()
+	f := Frame{v, ch, NULLBUFF}
+	return c.transmitContext(ctx, f)
+}
+
+/*
+	Put a frame on the wire, and wait for the write result, unless ctx is
+	done first.  The error channel is buffered, so the writer never blocks
+	on an abandoned request.
+*/
+func (c *Connection) transmitContext(ctx context.Context, f Frame) error {
+	r, e := c.enqueue(ctx, f)
+	if e != nil {
+		return e
+	}
+	select {
+	case e := <-r:
+		return e
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+/*
+	Hand a frame to the writer, unless ctx is done first.  On success the
+	frame will be written, and the write result is sent on the returned
+	channel.
+*/
+func (c *Connection) enqueue(ctx context.Context, f Frame) (chan error, error) {
+	if e := ctx.Err(); e != nil {
+		return nil, e
+	}
+	r := make(chan error, 1)
+	select {
+	case c.output <- wiredata{f, r}:
+		return r, nil
+	case <-c.ssdc:
+		return nil, ECONBAD
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,193 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+	Tx is a STOMP transaction started by BeginTx.  Its methods add the
+	transaction header to every frame, and fail with ETXDONE once the
+	transaction is committed or aborted.  A Tx is safe for concurrent use.
+*/
+type Tx struct {
+	c    *Connection
+	id   string
+	lock sync.Mutex
+	done bool // Committed or aborted
+}
+
+/*
+	BeginTx begins a transaction with a unique transaction id.
+
+	Transactions still open at Disconnect are aborted first.  Calling Abort
+	after Commit is harmless, so a deferred Abort cleans up on any early
+	return or panic.
+
+	Example:
+		tx, e := c.BeginTx()
+		if e != nil {
+			// Do something sane ...
+		}
+		defer tx.Abort() // No effect after Commit
+		e = tx.Send(stompngo.Headers{stompngo.HK_DESTINATION, "/queue/q"}, "m1")
+		if e != nil {
+			// Do something sane ...
+		}
+		e = tx.CommitWait(5 * time.Second)
+*/
+func (c *Connection) BeginTx() (*Tx, error) {
+	t := &Tx{c: c, id: Uuid()}
+	if e := c.Begin(Headers{HK_TRANSACTION, t.id}); e != nil {
+		return nil, e
+	}
+	c.txLock.Lock()
+	c.txs[t.id] = t
+	c.txLock.Unlock()
+	return t, nil
+}
+
+/*
+	Id returns the transaction id.
+*/
+func (t *Tx) Id() string {
+	return t.id
+}
+
+/*
+	Send a message in the transaction.
+*/
+func (t *Tx) Send(h Headers, b string) error {
+	return t.do(func() error { return t.c.Send(t.headers(h), b) })
+}
+
+/*
+	SendBytes sends a message with a byte slice body in the transaction.
+*/
+func (t *Tx) SendBytes(h Headers, b []byte) error {
+	return t.do(func() error { return t.c.SendBytes(t.headers(h), b) })
+}
+
+/*
+	Ack a message in the transaction.  See Connection.Ack for the required
+	headers, or use Delivery.AckInTx.
+*/
+func (t *Tx) Ack(h Headers) error {
+	return t.do(func() error { return t.c.Ack(t.headers(h)) })
+}
+
+/*
+	Nack a message in the transaction.  See Connection.Nack for the required
+	headers, or use Delivery.NackInTx.
+*/
+func (t *Tx) Nack(h Headers) error {
+	return t.do(func() error { return t.c.Nack(t.headers(h)) })
+}
+
+/*
+	Commit the transaction.
+*/
+func (t *Tx) Commit() error {
+	return t.end(COMMIT, false, 0)
+}
+
+/*
+	CommitWait commits the transaction, and waits up to d for the broker
+	RECEIPT.  d <= 0 waits without a time limit.
+*/
+func (t *Tx) CommitWait(d time.Duration) error {
+	return t.end(COMMIT, true, d)
+}
+
+/*
+	Abort the transaction.
+*/
+func (t *Tx) Abort() error {
+	return t.end(ABORT, false, 0)
+}
+
+/*
+	AbortWait aborts the transaction, and waits up to d for the broker
+	RECEIPT.  d <= 0 waits without a time limit.
+*/
+func (t *Tx) AbortWait(d time.Duration) error {
+	return t.end(ABORT, true, d)
+}
+
+func (t *Tx) headers(h Headers) Headers {
+	return h.Delete(HK_TRANSACTION).Add(HK_TRANSACTION, t.id)
+}
+
+/*
+	Run f unless the transaction is complete.
+*/
+func (t *Tx) do(f func() error) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ETXDONE
+	}
+	return f()
+}
+
+/*
+	Send COMMIT or ABORT, optionally with a receipt.  The transaction is
+	complete even if the frame can not be sent.
+*/
+func (t *Tx) end(cmd string, wr bool, d time.Duration) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ETXDONE
+	}
+	t.done = true
+	t.c.txLock.Lock()
+	delete(t.c.txs, t.id)
+	t.c.txLock.Unlock()
+	h := Headers{HK_TRANSACTION, t.id}
+	if !wr {
+		if cmd == COMMIT {
+			return t.c.Commit(h)
+		}
+		return t.c.Abort(h)
+	}
+	r, e := t.c.WithReceipt(cmd, h)
+	if e != nil {
+		return e
+	}
+	_, e = r.Wait(d)
+	return e
+}
+
+/*
+	Abort all open transactions, used by Disconnect.
+*/
+func (c *Connection) abortTxs() {
+	c.txLock.Lock()
+	ts := make([]*Tx, 0, len(c.txs))
+	for _, t := range c.txs {
+		ts = append(ts, t)
+	}
+	c.txLock.Unlock()
+	for _, t := range ts {
+		if e := t.Abort(); e != nil && e != ETXDONE {
+			c.log(LogLifecycle, LevelWarn, "TXABORT", "transaction", t.id, "error", e)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"testing"
+	"time"
+)
+
+/*
+	Test BeginTx, commit, abort and completed transaction errors.
+*/
+func TestTxCommitAbort(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	q := "/queue/tx"
+	tx, e := c.BeginTx()
+	if e != nil {
+		t.Fatalf("TestTxCommitAbort BeginTx expected nil, got %v\n", e)
+	}
+	for _, m := range []string{"one", "two"} {
+		// Any caller transaction header is replaced
+		if e = tx.Send(Headers{HK_DESTINATION, q, HK_TRANSACTION, "x"}, m); e != nil {
+			t.Fatalf("TestTxCommitAbort Send expected nil, got %v\n", e)
+		}
+	}
+	tx2, e := c.BeginTx()
+	if e != nil {
+		t.Fatalf("TestTxCommitAbort BeginTx expected nil, got %v\n", e)
+	}
+	if e = tx2.SendBytes(Headers{HK_DESTINATION, q}, []byte("three")); e != nil {
+		t.Fatalf("TestTxCommitAbort SendBytes expected nil, got %v\n", e)
+	}
+	if e = tx2.AbortWait(5 * time.Second); e != nil {
+		t.Fatalf("TestTxCommitAbort AbortWait expected nil, got %v\n", e)
+	}
+	if d := b.QueueDepth(q); d != 0 {
+		t.Fatalf("TestTxCommitAbort expected depth 0, got %d\n", d)
+	}
+	if e = tx.CommitWait(5 * time.Second); e != nil {
+		t.Fatalf("TestTxCommitAbort CommitWait expected nil, got %v\n", e)
+	}
+	if d := b.QueueDepth(q); d != 2 {
+		t.Fatalf("TestTxCommitAbort expected depth 2, got %d\n", d)
+	}
+	if e = tx.Send(Headers{HK_DESTINATION, q}, "late"); e != ETXDONE {
+		t.Fatalf("TestTxCommitAbort expected [%v], got [%v]\n", ETXDONE, e)
+	}
+	if e = tx.Abort(); e != ETXDONE {
+		t.Fatalf("TestTxCommitAbort expected [%v], got [%v]\n", ETXDONE, e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test open transactions are aborted by Disconnect.
+*/
+func TestTxDisconnect(t *testing.T) {
+	b, c := connectStomptest(t, SPL_11)
+	defer b.Close()
+	q := "/queue/txdisc"
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, q, HK_ID, "s1",
+		HK_ACK, AckModeClientIndividual})
+	if e != nil {
+		t.Fatalf("TestTxDisconnect SUBSCRIBE expected nil, got %v\n", e)
+	}
+	_ = c.Send(Headers{HK_DESTINATION, q}, "m")
+	d, e := c.NewDelivery(<-sc)
+	if e != nil {
+		t.Fatalf("TestTxDisconnect expected nil, got %v\n", e)
+	}
+	tx, e := c.BeginTx()
+	if e != nil {
+		t.Fatalf("TestTxDisconnect BeginTx expected nil, got %v\n", e)
+	}
+	if e = tx.Send(Headers{HK_DESTINATION, q}, "lost"); e != nil {
+		t.Fatalf("TestTxDisconnect Send expected nil, got %v\n", e)
+	}
+	if e = d.AckInTx(tx.Id()); e != nil {
+		t.Fatalf("TestTxDisconnect AckInTx expected nil, got %v\n", e)
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+	if e = tx.Commit(); e != ETXDONE {
+		t.Fatalf("TestTxDisconnect expected [%v], got [%v]\n", ETXDONE, e)
+	}
+	// The ACK was aborted, so the message is back, without "lost"
+	if n := b.QueueDepth(q); n != 1 {
+		t.Fatalf("TestTxDisconnect expected depth 1, got %d\n", n)
+	}
+}
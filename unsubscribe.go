@@ -17,6 +17,7 @@
 package stompws
 
 import (
+	"context"
 	"strconv"
 	"time"
 )
@@ -38,7 +39,16 @@ import (
 
 */
 func (c *Connection) Unsubscribe(h Headers) error {
-	c.log(UNSUBSCRIBE, "start", h)
+	return c.UnsubscribeContext(context.Background(), h)
+}
+
+/*
+	UnsubscribeContext is like Unsubscribe, but ctx can cancel the
+	operation, or set a deadline for it.  If ctx is done first, ctx.Err() is
+	returned.
+*/
+func (c *Connection) UnsubscribeContext(ctx context.Context, h Headers) error {
+	c.log(LogLifecycle, LevelDebug, "UNSUBSCRIBE start", "headers", h)
 	// fmt.Printf("Unsub Headers: %v\n", h)
 	if !c.isConnected() {
 		return ECONBAD
@@ -69,7 +79,7 @@ func (c *Connection) Unsubscribe(h Headers) error {
 			return EUNODSID
 		}
 	default:
-		panic("unsubscribe version not supported: " + c.Protocol())
+		return EBADPROTO
 	}
 	//
 	shaid := Sha1(h.Value(HK_DESTINATION)) // Special for 1.0
@@ -99,27 +109,25 @@ func (c *Connection) Unsubscribe(h Headers) error {
 		usekey = shaid
 		usesp = s10
 	default:
-		panic("unsubscribe version not supported: " + c.Protocol())
+		return EBADPROTO
 	}
 
 	sdn, ok := h.Contains(StompPlusDrainNow) // STOMP Protocol Extension
 
 	if !ok {
-		e = c.transmitCommon(UNSUBSCRIBE, h) // transmitCommon Clones() the headers
+		e = c.transmitCommonContext(ctx, UNSUBSCRIBE, h) // transmitCommon Clones() the headers
 		if e != nil {
 			return e
 		}
 
-		c.subsLock.Lock()
-		delete(c.subs, usekey)
-		c.subsLock.Unlock()
-		c.log(UNSUBSCRIBE, "end", h)
+		c.deleteSubscription(usekey)
+		c.log(LogLifecycle, LevelDebug, "UNSUBSCRIBE end", "headers", h)
 		return nil
 	}
 	//
 	// STOMP Protocol Extension
 	//
-	c.log("sngdrnow extension detected")
+	c.log(LogLifecycle, LevelDebug, "sngdrnow extension detected")
 	idn, err := strconv.ParseInt(sdn, 10, 64)
 	if err != nil {
 		idn = 100 // 100 milliseconds if bad parameter
@@ -135,17 +143,49 @@ forsel:
 				break forsel
 			}
 			dmc++
-			c.log("sngdrnow DROP", dmc, mi.Message.Command, mi.Message.Headers)
+			c.log(LogLifecycle, LevelDebug, "sngdrnow DROP", "count", dmc, "command", mi.Message.Command,
+				"headers", mi.Message.Headers)
 		case _ = <-ticker.C:
-			c.log("sngdrnow extension BREAK")
+			c.log(LogLifecycle, LevelDebug, "sngdrnow extension BREAK")
 			break forsel
 		}
 	}
 	//
-	c.log("sngdrnow extension at very end")
+	c.log(LogLifecycle, LevelDebug, "sngdrnow extension at very end")
+	c.deleteSubscription(usekey)
+	c.log(LogLifecycle, LevelDebug, "UNSUBSCRIBE endsngdrnow", "headers", h)
+	return nil
+}
+
+/*
+	Remove a subscription, and stop any SubscribeFunc workers.
+*/
+func (c *Connection) deleteSubscription(key string) {
 	c.subsLock.Lock()
-	delete(c.subs, usekey)
+	if s, ok := c.subs[key]; ok {
+		close(s.stop)
+		delete(c.subs, key)
+	}
 	c.subsLock.Unlock()
-	c.log(UNSUBSCRIBE, "endsngdrnow", h)
-	return nil
+}
+
+/*
+	Drop messages for a subscription that nothing reads any more, until it
+	is deleted or the connection shuts down.  Otherwise the reader can
+	block on a full channel, holding subsLock, and deleteSubscription waits
+	for it forever.
+*/
+func (c *Connection) discard(s *subscription) {
+	for {
+		select {
+		case md, ok := <-s.md:
+			if !ok {
+				return
+			}
+			c.log(LogLifecycle, LevelDebug, "UNSUBSCRIBE DROP", "subscription", s.id,
+				"headers", md.Message.Headers)
+		case <-s.stop:
+			return
+		}
+	}
 }
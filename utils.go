@@ -18,103 +18,22 @@ package stompws
 
 import (
 	"fmt"
-	"io"
-	"strings"
+
+	"github.com/drawdy/stomp-ws-go/frame"
 )
 
 /*
 	Encode a string per STOMP 1.1+ specifications.
 */
 func encode(s string) string {
-	r := s
-	for _, tr := range codecValues {
-		if strings.Index(r, tr.decoded) >= 0 {
-			r = strings.Replace(r, tr.decoded, tr.encoded, -1)
-		}
-	}
-	return r
+	return frame.Escape(s, SPL_12)
 }
 
 /*
 	Decode a string per STOMP 1.1+ specifications.
 */
 func decode(s string) string {
-	r := s
-	for _, tr := range codecValues {
-		if strings.Index(r, tr.encoded) >= 0 {
-			r = strings.Replace(r, tr.encoded, tr.decoded, -1)
-		}
-	}
-	return r
-}
-
-/*
-	A network helper.  Read from the wire until a 0x00 byte is encountered.
-*/
-func readUntilNul(c *Connection) ([]uint8, error) {
-	c.setReadDeadline()
-	b, e := c.rdr.ReadBytes(0)
-	if c.checkReadError(e) != nil {
-		return b, e
-	}
-	if len(b) == 1 {
-		b = NULLBUFF
-	} else {
-		b = b[0 : len(b)-1]
-	}
-	return b, e
-}
-
-func readUntilNulOverWS(c *Connection) ([]uint8, error) {
-	c.setReadDeadlineOverWS()
-	b, e := c.rdr.ReadBytes(0)
-	if c.checkReadError(e) != nil {
-		return b, e
-	}
-	if len(b) == 1 {
-		b = NULLBUFF
-	} else {
-		b = b[0 : len(b)-1]
-	}
-	return b, e
-}
-
-/*
-	A network helper.  Read a full message body with a known length that is
-	> 0.  Then read the trailing 'null' byte expected for STOMP frames.
-*/
-func readBody(c *Connection, l int) ([]uint8, error) {
-	b := make([]byte, l)
-	c.setReadDeadline()
-	n, e := io.ReadFull(c.rdr, b)
-	if n < l && n != 0 { // Short read, e is ErrUnexpectedEOF
-		c.log("SHORT READ", n, l, e)
-		return b[0 : n-1], e
-	}
-	if c.checkReadError(e) != nil { // Other erors
-		return b, e
-	}
-	c.setReadDeadline()
-	_, _ = c.rdr.ReadByte()         // trailing NUL
-	if c.checkReadError(e) != nil { // Other erors
-		return b, e
-	}
-	return b, e
-}
-
-func readBodyOverWS(c *Connection, l int) ([]uint8, error) {
-	b := make([]byte, l)
-	c.setReadDeadlineOverWS()
-	n, e := io.ReadFull(c.rdr, b)
-	if n < l && n != 0 { // Short read, e is ErrUnexpectedEOF
-		c.log("SHORT READ", n, l, e)
-		return b[0 : n-1], e
-	}
-	if c.checkReadError(e) != nil { // Other erors
-		return b, e
-	}
-	_, _ = c.rdr.ReadByte() // trailing NUL
-	return b, e
+	return frame.Unescape(s, SPL_12)
 }
 
 /*
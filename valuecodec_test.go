@@ -0,0 +1,139 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type codecTestValue struct {
+	Name  string
+	Count int
+}
+
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(strings.ToUpper(v.(string))), nil
+}
+
+func (upperCodec) Unmarshal(b []byte, v interface{}) error {
+	*v.(*string) = strings.ToLower(string(b))
+	return nil
+}
+
+/*
+	Test the built in codecs, and codec registration.
+*/
+func TestCodecRoundTrip(t *testing.T) {
+	for _, ct := range []string{CT_JSON, CT_GOB, CT_JSON + "; charset=UTF-8"} {
+		cd, ok := LookupCodec(ct)
+		if !ok {
+			t.Fatalf("TestCodecRoundTrip no codec for %s\n", ct)
+		}
+		b, e := cd.Marshal(codecTestValue{"a", 1})
+		if e != nil {
+			t.Fatalf("TestCodecRoundTrip %s Marshal expected nil, got %v\n", ct, e)
+		}
+		var v codecTestValue
+		md := MessageData{Message{MESSAGE, Headers{HK_CONTENT_TYPE, ct}, b}, nil}
+		if e = Decode(md, &v); e != nil || v != (codecTestValue{"a", 1}) {
+			t.Fatalf("TestCodecRoundTrip %s expected {a 1} nil, got %v %v\n", ct, v, e)
+		}
+	}
+	cd, _ := LookupCodec(CT_PROTOBUF)
+	if _, e := cd.Marshal(codecTestValue{}); e != ECODECTYP {
+		t.Fatalf("TestCodecRoundTrip expected [%v], got [%v]\n", ECODECTYP, e)
+	}
+	b, e := cd.Marshal(wrapperspb.String("proto"))
+	if e != nil {
+		t.Fatalf("TestCodecRoundTrip protobuf Marshal expected nil, got %v\n", e)
+	}
+	pv := &wrapperspb.StringValue{}
+	md := MessageData{Message{MESSAGE, Headers{HK_CONTENT_TYPE, CT_PROTOBUF}, b}, nil}
+	if e = Decode(md, pv); e != nil || pv.Value != "proto" {
+		t.Fatalf("TestCodecRoundTrip expected proto nil, got %v %v\n", pv.Value, e)
+	}
+	//
+	md = MessageData{Message{MESSAGE, Headers{}, b}, nil}
+	if e = Decode(md, pv); e == nil || !strings.HasPrefix(e.Error(), ENOCODEC.Error()) {
+		t.Fatalf("TestCodecRoundTrip expected [%v], got [%v]\n", ENOCODEC, e)
+	}
+	md.Error = ECONBAD
+	if e = Decode(md, pv); e != ECONBAD {
+		t.Fatalf("TestCodecRoundTrip expected [%v], got [%v]\n", ECONBAD, e)
+	}
+	RegisterCodec("text/x-upper", upperCodec{})
+	defer RegisterCodec("text/x-upper", nil)
+	if _, ok := LookupCodec("Text/X-Upper"); !ok {
+		t.Fatalf("TestCodecRoundTrip registered codec not found\n")
+	}
+}
+
+/*
+	Test SendValue and Decode through a broker.
+*/
+func TestCodecSendValue(t *testing.T) {
+	b, c := connectStomptest(t, SPL_12)
+	defer b.Close()
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/codec", HK_ID, "codec"})
+	if e != nil {
+		t.Fatalf("TestCodecSendValue Subscribe expected nil, got %v\n", e)
+	}
+	e = c.SendValue(Headers{HK_DESTINATION, "/queue/codec"}, codecTestValue{"json", 2})
+	if e != nil {
+		t.Fatalf("TestCodecSendValue expected nil, got %v\n", e)
+	}
+	e = c.SendValue(Headers{HK_DESTINATION, "/queue/codec",
+		HK_CONTENT_TYPE, CT_PROTOBUF}, wrapperspb.Int64(42))
+	if e != nil {
+		t.Fatalf("TestCodecSendValue expected nil, got %v\n", e)
+	}
+	e = c.SendValue(Headers{HK_DESTINATION, "/queue/codec",
+		HK_CONTENT_TYPE, "application/x-none"}, 1)
+	if e == nil || !strings.HasPrefix(e.Error(), ENOCODEC.Error()) {
+		t.Fatalf("TestCodecSendValue expected [%v], got [%v]\n", ENOCODEC, e)
+	}
+	for i := 0; i < 2; i++ {
+		var md MessageData
+		select {
+		case md = <-sc:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestCodecSendValue no MESSAGE\n")
+		}
+		switch md.Message.Headers.Value(HK_CONTENT_TYPE) {
+		case CT_JSON:
+			var v codecTestValue
+			if e = Decode(md, &v); e != nil || v != (codecTestValue{"json", 2}) {
+				t.Fatalf("TestCodecSendValue expected {json 2} nil, got %v %v\n", v, e)
+			}
+		case CT_PROTOBUF:
+			v := &wrapperspb.Int64Value{}
+			if e = Decode(md, v); e != nil || v.Value != 42 {
+				t.Fatalf("TestCodecSendValue expected 42 nil, got %v %v\n", v.Value, e)
+			}
+		default:
+			t.Fatalf("TestCodecSendValue bad MESSAGE %v\n", md.Message)
+		}
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
@@ -19,13 +19,15 @@ package stompws
 import (
 	"bufio"
 	"bytes"
-	"github.com/gorilla/websocket"
 	"io"
 	"net"
 
 	// "bytes"
 	"strconv"
+	"sync/atomic"
 	"time"
+
+	"github.com/drawdy/stomp-ws-go/frame"
 )
 
 /*
@@ -47,63 +49,52 @@ func (c *Connection) writeWireData(wd wiredata) error {
 	channel, and put the frame on the wire.
 */
 func (c *Connection) writer() {
+	defer close(c.wtrdc)
 writerLoop:
 	for {
 		select {
 		case d := <-c.output:
-			c.log("WTR_WIREWRITE start")
-			c.wireWrite(d)
-			logLock.Lock()
-			if c.logger != nil {
-				c.logx("WTR_WIREWRITE COMPLETE", d.frame.Command, d.frame.Headers,
-					HexData(d.frame.Body))
-			}
-			logLock.Unlock()
+			c.logWireWrite(d)
 			if d.frame.Command == DISCONNECT {
 				break writerLoop // we are done with this connection
 			}
 		case _ = <-c.ssdc:
-			c.log("WTR_WIREWRITE shutdown S received")
+			c.log(LogLifecycle, LevelDebug, "WTR_WIREWRITE shutdown S received")
 			break writerLoop
 		case _ = <-c.wtrsdc:
-			c.log("WTR_WIREWRITE shutdown W received")
+			c.log(LogLifecycle, LevelDebug, "WTR_WIREWRITE shutdown W received")
 			break writerLoop
 		}
 	} // of for
 	//
 	c.setConnected(false)
-	c.sysAbort()
-	c.log("WTR_SHUTDOWN", time.Now())
+	if !c.isReconnecting() {
+		c.sysAbort()
+	}
+	c.log(LogLifecycle, LevelInfo, "WTR_SHUTDOWN")
 }
 
-func (c *Connection) writerOverWS() {
-writerLoop:
-	for {
-		select {
-		case d := <-c.output:
-			c.log("WTR_WIREWRITE start")
-			c.wireWriteOverWS(d)
-			logLock.Lock()
-			if c.logger != nil {
-				c.logx("WTR_WIREWRITE COMPLETE", d.frame.Command, d.frame.Headers,
-					HexData(d.frame.Body))
-			}
-			logLock.Unlock()
-			if d.frame.Command == DISCONNECT {
-				break writerLoop // we are done with this connection
-			}
-		case _ = <-c.ssdc:
-			c.log("WTR_WIREWRITE shutdown S received")
-			break writerLoop
-		case _ = <-c.wtrsdc:
-			c.log("WTR_WIREWRITE shutdown W received")
-			break writerLoop
-		}
-	} // of for
-	//
-	c.setConnected(false)
-	c.sysAbort()
-	c.log("WTR_SHUTDOWN", time.Now())
+/*
+	Write a frame on the current transport before the writer is started.
+	CONNECT, and SUBSCRIBE frames replayed after a reconnect, are written
+	this way, so no client frame can precede them.
+*/
+func (c *Connection) writeDirect(f Frame) error {
+	d := wiredata{f, make(chan error, 1)}
+	c.logWireWrite(d)
+	return <-d.errchan
+}
+
+/*
+	Write and log one frame.
+*/
+func (c *Connection) logWireWrite(d wiredata) {
+	c.log(LogWire, LevelDebug, "WTR_WIREWRITE start")
+	c.wireWrite(d)
+	if c.logEnabled(LogWire, LevelDebug) {
+		c.log(LogWire, LevelDebug, "WTR_WIREWRITE COMPLETE", "command", d.frame.Command,
+			"headers", d.frame.Headers, "body", HexData(d.frame.Body))
+	}
 }
 
 /*
@@ -111,97 +102,67 @@ writerLoop:
 */
 func (c *Connection) wireWrite(d wiredata) {
 	f := &d.frame
-	// fmt.Printf("WWD01 f:[%v]\n", f)
-	switch f.Command {
-	case "\n": // HeartBeat frame
-		if c.dld.wde && c.dld.wds {
-			_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-		}
-		_, e := c.wtr.WriteString(f.Command)
-		if e != nil {
-			if e.(net.Error).Timeout() {
-				if c.dld.dns {
-					c.dld.dlnotify(e, true)
-				}
-			}
-			d.errchan <- e
-			return
-		}
-	default: // Other frames
-		if e := f.writeFrame(c.wtr, c); e != nil {
+	var ws io.WriteCloser // WebSocket message writer
+	if c.wsConn != nil {
+		var e error
+		if ws, e = c.wsConn.NextWriter(c.wsMessageType(f)); e != nil {
+			c.writeError(f, e)
 			d.errchan <- e
 			return
 		}
-		if e := c.wtr.Flush(); e != nil {
-			d.errchan <- e
-			return
+	}
+	e := f.writeFrame(wireWriter{c, ws}, c)
+	if e == nil {
+		c.setWriteDeadline()
+		if ws != nil {
+			e = ws.Close()
+		} else {
+			e = c.wtr.Flush()
 		}
+		e = c.checkWriteError(e)
+	}
+	// End of write - set no deadline
+	if c.dld.wde {
+		c.writeDeadline(c.dld.t0)
 	}
-	if e := c.wtr.Flush(); e != nil {
+	if e != nil {
+		atomic.AddInt64(&c.mets.wer, 1)
+		c.writeError(f, e)
 		d.errchan <- e
 		return
 	}
 	//
-	if c.hbd != nil {
-		c.hbd.sdl.Lock()
-		c.hbd.ls = time.Now().UnixNano() // Latest good send
-		c.hbd.sdl.Unlock()
+	if hbd := c.heartBeatData(); hbd != nil {
+		hbd.sdl.Lock()
+		hbd.ls = time.Now().UnixNano() // Latest good send
+		hbd.sdl.Unlock()
 	}
-	c.mets.tfw++                // Frame written count
-	c.mets.tbw += f.Size(false) // Bytes written count
+	c.mets.write(f.Command, f.Size(false))
 	//
 	d.errchan <- nil
 	return
 }
 
-func (c *Connection) wireWriteOverWS(d wiredata) {
-	f := &d.frame
-	// fmt.Printf("WWD01 f:[%v]\n", f)
-	wtr, e := c.wsConn.NextWriter(websocket.TextMessage)
-	if e != nil {
-		d.errchan <- e
-		return
-	}
-
-	switch f.Command {
-	case "\n": // HeartBeat frame
-		if c.dld.wde && c.dld.wds {
-			_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-		}
-		_, e := wtr.Write([]byte(f.Command))
-		if e != nil {
-			if e.(net.Error).Timeout() {
-				if c.dld.dns {
-					c.dld.dlnotify(e, true)
-				}
-			}
-			d.errchan <- e
-			return
-		}
-	default: // Other frames
-		if e := f.writeFrameOverWS(wtr, c); e != nil {
-			d.errchan <- e
-			return
-		}
-	}
-
-	//
-	if c.hbd != nil {
-		c.hbd.sdl.Lock()
-		c.hbd.ls = time.Now().UnixNano() // Latest good send
-		c.hbd.sdl.Unlock()
+/*
+	Report a failed frame write.  Heart beats have no Command.
+*/
+func (c *Connection) writeError(f *Frame, e error) {
+	m := Message{Headers: f.Headers}
+	if f.Command != "\n" {
+		m.Command = f.Command
 	}
-	c.mets.tfw++                // Frame written count
-	c.mets.tbw += f.Size(false) // Bytes written count
-	//
-	d.errchan <- nil
-	return
+	c.event(Event{Type: EventWriteError, Error: e, Message: m})
 }
 
 /*
 	Physical frame write to the wire.
 */
-func (f *Frame) writeFrame(w *bufio.Writer, c *Connection) error {
+func (f *Frame) writeFrame(w io.Writer, c *Connection) error {
+	en := frame.NewEncoder(w)
+	en.CRLF = c.writeCRLF() && c.Protocol() == SPL_12
+	if f.Command == "\n" { // HeartBeat frame
+		return en.Encode(&frame.Frame{})
+	}
 
 	var sctok bool
 	// Content type.  Always add it if the client does not suppress and does not
@@ -223,186 +184,76 @@ func (f *Frame) writeFrame(w *bufio.Writer, c *Connection) error {
 			f.Headers = append(f.Headers, HK_CONTENT_LENGTH, strconv.Itoa(len(f.Body)))
 		}
 	}
-	// Encode the headers if needed
-	if c.Protocol() > SPL_10 && f.Command != CONNECT {
-		for i := 0; i < len(f.Headers); i += 2 {
-			f.Headers[i] = encode(f.Headers[i])
-			f.Headers[i+1] = encode(f.Headers[i+1])
-		}
-	}
 
 	if sclok {
 		nz := bytes.IndexByte(f.Body, 0)
-		// fmt.Printf("WDBG41 ok:%v\n", nz)
 		if nz == 0 {
 			f.Body = []byte{}
-			// fmt.Printf("WDBG42 body:%v bodystring: %v\n", f.Body, string(f.Body))
 		} else if nz > 0 {
 			f.Body = f.Body[0:nz]
-			// fmt.Printf("WDBG43 body:%v bodystring: %v\n", f.Body, string(f.Body))
-		}
-	}
-
-	if c.dld.wde && c.dld.wds {
-		_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-
-	// Writes start
-
-	// Write the frame Command
-	_, e := w.WriteString(f.Command + "\n")
-	if c.checkWriteError(e) != nil {
-		return e
-	}
-	// fmt.Println("WRCMD", f.Command)
-	// Write the frame Headers
-	for i := 0; i < len(f.Headers); i += 2 {
-		if c.dld.wde && c.dld.wds {
-			_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
 		}
-		_, e := w.WriteString(f.Headers[i] + ":" + f.Headers[i+1] + "\n")
-		if c.checkWriteError(e) != nil {
-			return e
-		}
-		// fmt.Println("WRHDR", f.Headers[i]+":"+f.Headers[i+1]+"\n")
-	}
-
-	// Write the last Header LF
-	if c.dld.wde && c.dld.wds {
-		_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-	e = w.WriteByte('\n')
-	if c.checkWriteError(e) != nil {
-		return e
 	}
-	// fmt.Printf("WDBG40 ok:%v\n", sclok)
 
-	// Write the body
-	if len(f.Body) != 0 { // Foolish to write 0 length data
-		// fmt.Println("WRBDY", f.Body)
-		e := c.writeBody(f)
-		if c.checkWriteError(e) != nil {
-			return e
-		}
-	}
-	if c.dld.wde && c.dld.wds {
-		_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-	e = w.WriteByte(0)
-	if c.checkWriteError(e) != nil {
-		return e
-	}
-	// End of write loop - set no deadline
-	if c.dld.wde {
-		_ = c.netconn.SetWriteDeadline(c.dld.t0)
-	}
-	return nil
+	// Headers are encoded for 1.1+
+	en.Version = c.Protocol()
+	return en.Encode(&frame.Frame{Command: f.Command, Headers: f.Headers,
+		Body: f.Body})
 }
 
 /*
-	Physical frame write to the wire over websocket
+	The transport byte stream for frame writes.  Each network write sets any
+	write deadline first, and short writes are retried if requested.
 */
-func (f *Frame) writeFrameOverWS(w io.WriteCloser, c *Connection) error {
-
-	var sctok bool
-	// Content type.  Always add it if the client does not suppress and does not
-	// supply it.
-	_, sctok = f.Headers.Contains(HK_SUPPRESS_CT)
-	if !sctok {
-		if _, ctok := f.Headers.Contains(HK_CONTENT_TYPE); !ctok {
-			f.Headers = append(f.Headers, HK_CONTENT_TYPE,
-				DFLT_CONTENT_TYPE)
-		}
-	}
-
-	var sclok bool
-	// Content length - Always add it if client does not suppress it and
-	// does not supply it.
-	_, sclok = f.Headers.Contains(HK_SUPPRESS_CL)
-	if !sclok {
-		if _, clok := f.Headers.Contains(HK_CONTENT_LENGTH); !clok {
-			f.Headers = append(f.Headers, HK_CONTENT_LENGTH, strconv.Itoa(len(f.Body)))
-		}
-	}
-	// Encode the headers if needed
-	if c.Protocol() > SPL_10 && f.Command != CONNECT {
-		for i := 0; i < len(f.Headers); i += 2 {
-			f.Headers[i] = encode(f.Headers[i])
-			f.Headers[i+1] = encode(f.Headers[i+1])
-		}
-	}
+type wireWriter struct {
+	c  *Connection
+	ws io.Writer // Current WebSocket message, nil for TCP
+}
 
-	if sclok {
-		nz := bytes.IndexByte(f.Body, 0)
-		// fmt.Printf("WDBG41 ok:%v\n", nz)
-		if nz == 0 {
-			f.Body = []byte{}
-			// fmt.Printf("WDBG42 body:%v bodystring: %v\n", f.Body, string(f.Body))
-		} else if nz > 0 {
-			f.Body = f.Body[0:nz]
-			// fmt.Printf("WDBG43 body:%v bodystring: %v\n", f.Body, string(f.Body))
+func (w wireWriter) Write(b []byte) (int, error) {
+	c := w.c
+	t := 0
+	for {
+		c.setWriteDeadline()
+		var n int
+		var e error
+		if w.ws != nil {
+			n, e = w.ws.Write(b[t:])
+		} else {
+			n, e = c.wtr.Write(b[t:])
+		}
+		t += n
+		if t == len(b) || e == nil {
+			return t, c.checkWriteError(e)
+		}
+		c.log(LogWire, LevelDebug, "SHORT WRITE", "written", n, "remaining", len(b)-t+n)
+		if n == 0 || !c.dld.rfsw { // Zero bytes would mean something is seriously wrong.
+			return t, c.checkWriteError(e)
 		}
-	}
-
-	//w, e := c.wsClient.NextWriter(websocket.TextMessage)
-	//if e != nil {
-	//	return e
-	//}
-	if c.dld.wde && c.dld.wds {
-		_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-
-	// Writes start
-
-	// Write the frame Command
-	_, e := w.Write([]byte(f.Command + "\n"))
-	if c.checkWriteError(e) != nil {
-		return e
-	}
-	// fmt.Println("WRCMD", f.Command)
-	// Write the frame Headers
-	for i := 0; i < len(f.Headers); i += 2 {
-		if c.dld.wde && c.dld.wds {
-			_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
+		if c.dld.wde && c.dld.wds && c.dld.dns && isErrorTimeout(e) {
+			c.log(LogLifecycle, LevelWarn, "invoking write deadline callback 2")
+			c.dld.dlnotify(e, true)
 		}
-		_, e := w.Write([]byte(f.Headers[i] + ":" + f.Headers[i+1] + "\n"))
-		if c.checkWriteError(e) != nil {
-			return e
+		if w.ws == nil {
+			// *Any* error from a bufio.Writer is *not* recoverable.  See code in
+			// bufio.go to understand this.  We get a new writer here, to clear any
+			// error condition.
+			c.wtr = bufio.NewWriter(c.netconn) // Create new writer
 		}
-		// fmt.Println("WRHDR", f.Headers[i]+":"+f.Headers[i+1]+"\n")
 	}
+}
 
-	// Write the last Header LF
+func (c *Connection) setWriteDeadline() {
 	if c.dld.wde && c.dld.wds {
-		_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-	_, e = w.Write([]byte("\n"))
-	if c.checkWriteError(e) != nil {
-		return e
+		c.writeDeadline(time.Now().Add(c.dld.wdld))
 	}
-	// fmt.Printf("WDBG40 ok:%v\n", sclok)
+}
 
-	// Write the body
-	if len(f.Body) != 0 { // Foolish to write 0 length data
-		// fmt.Println("WRBDY", f.Body)
-		e := c.writeBodyOverWS(w, f)
-		if c.checkWriteError(e) != nil {
-			return e
-		}
-	}
-	if c.dld.wde && c.dld.wds {
-		_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-	}
-	_, e = w.Write([]byte{0})
-	if c.checkWriteError(e) != nil {
-		return e
-	}
-	// End of write loop - set no deadline
-	if c.dld.wde {
-		_ = c.wsConn.SetWriteDeadline(c.dld.t0)
+func (c *Connection) writeDeadline(t time.Time) {
+	if c.wsConn != nil {
+		_ = c.wsConn.SetWriteDeadline(t)
+		return
 	}
-
-	return w.Close()
+	_ = c.netconn.SetWriteDeadline(t)
 }
 
 func (c *Connection) checkWriteError(e error) error {
@@ -415,74 +266,13 @@ func (c *Connection) checkWriteError(e error) error {
 	}
 	if ne.Timeout() {
 		if c.dld.dns {
-			c.log("invoking write deadline callback 1")
+			c.log(LogLifecycle, LevelWarn, "invoking write deadline callback 1")
 			c.dld.dlnotify(e, true)
 		}
 	}
 	return e
 }
 
-func (c *Connection) writeBody(f *Frame) error {
-	// fmt.Printf("WDBG99 body:%v bodystring: %v\n", f.Body, string(f.Body))
-	var n = 0
-	var e error
-	for {
-		if c.dld.wde && c.dld.wds {
-			_ = c.netconn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-		}
-		n, e = c.wtr.Write(f.Body)
-		if n == len(f.Body) {
-			return e
-		}
-		c.log("SHORT WRITE", n, len(f.Body))
-		if n == 0 { // Zero bytes would mean something is seriously wrong.
-			return e
-		}
-		if !c.dld.rfsw {
-			return e
-		}
-		if c.dld.wde && c.dld.wds && c.dld.dns && isErrorTimeout(e) {
-			c.log("invoking write deadline callback 2")
-			c.dld.dlnotify(e, true)
-		}
-		// *Any* error from a bufio.Writer is *not* recoverable.  See code in
-		// bufio.go to understand this.  We get a new writer here, to clear any
-		// error condition.
-		c.wtr = bufio.NewWriter(c.netconn) // Create new writer
-		f.Body = f.Body[n:]
-	}
-}
-
-func (c *Connection) writeBodyOverWS(w io.WriteCloser, f *Frame) error {
-	// fmt.Printf("WDBG99 body:%v bodystring: %v\n", f.Body, string(f.Body))
-	var n = 0
-	var e error
-	for {
-		if c.dld.wde && c.dld.wds {
-			_ = c.wsConn.SetWriteDeadline(time.Now().Add(c.dld.wdld))
-		}
-		n, e = w.Write(f.Body)
-		if n == len(f.Body) {
-			return e
-		}
-		c.log("SHORT WRITE", n, len(f.Body))
-		if n == 0 { // Zero bytes would mean something is seriously wrong.
-			return e
-		}
-		if !c.dld.rfsw {
-			return e
-		}
-		if c.dld.wde && c.dld.wds && c.dld.dns && isErrorTimeout(e) {
-			c.log("invoking write deadline callback 2")
-			c.dld.dlnotify(e, true)
-		}
-		// *Any* error from a bufio.Writer is *not* recoverable.  See code in
-		// bufio.go to understand this.  We get a new writer here, to clear any
-		// error condition.
-		f.Body = f.Body[n:]
-	}
-}
-
 func isErrorTimeout(e error) bool {
 	if e == nil {
 		return false
@@ -0,0 +1,161 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	WebSocket subprotocol names for each STOMP protocol level.
+*/
+const (
+	WSP_10 = "v10.stomp"
+	WSP_11 = "v11.stomp"
+	WSP_12 = "v12.stomp"
+)
+
+/*
+	STOMP subprotocols requested by DialWS, highest level first.
+*/
+var WSSubprotocols = []string{WSP_12, WSP_11, WSP_10}
+
+var wsProtocolLevels = map[string]string{
+	WSP_10: SPL_10,
+	WSP_11: SPL_11,
+	WSP_12: SPL_12,
+}
+
+/*
+	WSOptions controls the WebSocket handshake performed by DialWS.  DialWS
+	starts from a copy of websocket.DefaultDialer, which uses
+	http.ProxyFromEnvironment and a 45 second handshake timeout, and
+	overrides only the fields set here.  A nil *WSOptions changes nothing.
+*/
+type WSOptions struct {
+	Header           http.Header                           // Extra handshake headers (cookies, Authorization, ...)
+	TLSConfig        *tls.Config                           // For wss URLs
+	Proxy            func(*http.Request) (*url.URL, error) // Replaces the default proxy
+	HandshakeTimeout time.Duration                         // Replaces the default timeout
+	Subprotocols     []string                              // Default WSSubprotocols
+}
+
+/*
+	WSHandshakeError is returned by DialWS when the server answers the
+	handshake with an HTTP response, but does not upgrade the connection.
+	StatusCode tells, for example, 401 Unauthorized from 403 Forbidden.
+*/
+type WSHandshakeError struct {
+	StatusCode int    // HTTP response status code
+	Status     string // HTTP response status line, e.g. "401 Unauthorized"
+	Err        error  // The gorilla handshake error
+}
+
+func (e *WSHandshakeError) Error() string {
+	return e.Err.Error() + ": " + e.Status
+}
+
+/*
+	Unwrap returns the gorilla handshake error.
+*/
+func (e *WSHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+/*
+	DialWS opens a WebSocket connection suitable for ConnectOverWS.
+
+	The STOMP subprotocols are requested during the handshake.  If the server
+	selects one, ConnectOverWS checks it against the CONNECT accept-version
+	header before sending CONNECT.  A handshake the server refuses returns a
+	*WSHandshakeError.
+
+	Example:
+		o := &stompngo.WSOptions{
+			Header: http.Header{"Authorization": {"Bearer " + token}},
+			HandshakeTimeout: 10 * time.Second,
+		}
+		ws, e := stompngo.DialWS("wss://broker.example.com/ws", o)
+		if e != nil {
+			// Do something sane ...
+		}
+		h := stompngo.Headers{stompngo.HK_ACCEPT_VERSION, "1.2",
+			stompngo.HK_HOST, "broker.example.com"}
+		c, e := stompngo.ConnectOverWS(ws, h)
+		if e != nil {
+			// Do something sane ...
+		}
+*/
+func DialWS(u string, o *WSOptions) (*websocket.Conn, error) {
+	if o == nil {
+		o = &WSOptions{}
+	}
+	ws, r, e := wsDialer(o).Dial(u, o.Header)
+	if e != nil && r != nil {
+		return nil, &WSHandshakeError{StatusCode: r.StatusCode, Status: r.Status, Err: e}
+	}
+	return ws, e
+}
+
+/*
+	The handshake Dialer for o: the gorilla default, with the fields set in
+	o replaced.
+*/
+func wsDialer(o *WSOptions) *websocket.Dialer {
+	d := *websocket.DefaultDialer
+	if o.Proxy != nil {
+		d.Proxy = o.Proxy
+	}
+	if o.TLSConfig != nil {
+		d.TLSClientConfig = o.TLSConfig
+	}
+	if o.HandshakeTimeout != 0 {
+		d.HandshakeTimeout = o.HandshakeTimeout
+	}
+	d.Subprotocols = WSSubprotocols
+	if len(o.Subprotocols) != 0 {
+		d.Subprotocols = o.Subprotocols
+	}
+	return &d
+}
+
+/*
+	Check that a negotiated STOMP subprotocol is one of the versions the
+	CONNECT headers accept.  An empty or non-STOMP subprotocol is not checked.
+*/
+func checkSubprotocol(sp string, ch Headers) error {
+	v, ok := wsProtocolLevels[sp]
+	if !ok {
+		return nil
+	}
+	av := ch.Value(HK_ACCEPT_VERSION)
+	if av == "" {
+		av = SPL_10
+	}
+	for _, w := range strings.Split(av, ",") {
+		if strings.TrimSpace(w) == v {
+			return nil
+		}
+	}
+	return Error(EBADWSSP.Error() + ": " + sp + " " + av)
+}
@@ -0,0 +1,149 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Test helper.  A WebSocket server that selects subprotocol sp, and answers
+	CONNECT and DISCONNECT.
+*/
+func newSubprotocolServer(t *testing.T, sp string) *httptest.Server {
+	u := websocket.Upgrader{Subprotocols: []string{sp}}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ws, e := u.Upgrade(w, r, nil)
+		if e != nil {
+			return
+		}
+		defer ws.Close()
+		h, ok := wsNextCommand(ws, CONNECT)
+		if !ok {
+			return
+		}
+		_ = ws.WriteMessage(websocket.TextMessage,
+			[]byte("CONNECTED\nversion:"+h.Value(HK_ACCEPT_VERSION)+"\n\n\x00"))
+		if h, ok = wsNextCommand(ws, DISCONNECT); ok {
+			_ = ws.WriteMessage(websocket.TextMessage,
+				[]byte("RECEIPT\nreceipt-id:"+h.Value(HK_RECEIPT)+"\n\n\x00"))
+		}
+	}))
+}
+
+/*
+	Test DialWS options and subprotocol negotiation.
+*/
+func TestWSDialSubprotocol(t *testing.T) {
+	srv := newSubprotocolServer(t, WSP_11)
+	defer srv.Close()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http")
+	_, e := DialWS(u, nil)
+	if he, ok := e.(*WSHandshakeError); !ok || he.StatusCode != http.StatusUnauthorized ||
+		he.Unwrap() != websocket.ErrBadHandshake {
+		t.Fatalf("TestWSDialSubprotocol expected 401 handshake error, got %v\n", e)
+	}
+	o := &WSOptions{Header: http.Header{"Authorization": {"Bearer tok"}},
+		HandshakeTimeout: 5 * time.Second}
+	ws, e := DialWS(u, o)
+	if e != nil {
+		t.Fatalf("TestWSDialSubprotocol DialWS expected nil, got %v\n", e)
+	}
+	if ws.Subprotocol() != WSP_11 {
+		t.Fatalf("TestWSDialSubprotocol expected %s, got %s\n", WSP_11,
+			ws.Subprotocol())
+	}
+	// Server chose 1.1, client only accepts 1.2
+	ch := Headers{HK_ACCEPT_VERSION, SPL_12, HK_HOST, "localhost"}
+	if _, e = ConnectOverWS(ws, ch); e == nil ||
+		!strings.HasPrefix(e.Error(), EBADWSSP.Error()) {
+		t.Fatalf("TestWSDialSubprotocol expected [%v], got [%v]\n", EBADWSSP, e)
+	}
+	ws.Close()
+	ws, e = DialWS(u, o)
+	if e != nil {
+		t.Fatalf("TestWSDialSubprotocol DialWS expected nil, got %v\n", e)
+	}
+	ch = Headers{HK_ACCEPT_VERSION, SPL_11, HK_HOST, "localhost"}
+	c, e := ConnectOverWS(ws, ch)
+	if e != nil {
+		t.Fatalf("TestWSDialSubprotocol CONNECT expected nil, got %v\n", e)
+	}
+	if c.Protocol() != SPL_11 {
+		t.Fatalf("TestWSDialSubprotocol expected %s, got %s\n", SPL_11,
+			c.Protocol())
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}
+
+/*
+	Test DialWS keeps the gorilla defaults for options not set.
+*/
+func TestWSDialDefaults(t *testing.T) {
+	d := wsDialer(&WSOptions{})
+	if d.Proxy == nil || d.HandshakeTimeout != websocket.DefaultDialer.HandshakeTimeout {
+		t.Fatalf("TestWSDialDefaults expected gorilla defaults, got %v\n",
+			d.HandshakeTimeout)
+	}
+	if len(d.Subprotocols) != len(WSSubprotocols) {
+		t.Fatalf("TestWSDialDefaults expected %v, got %v\n", WSSubprotocols,
+			d.Subprotocols)
+	}
+	d = wsDialer(&WSOptions{HandshakeTimeout: time.Second, Subprotocols: []string{WSP_12}})
+	if d.HandshakeTimeout != time.Second || len(d.Subprotocols) != 1 || d.Proxy == nil {
+		t.Fatalf("TestWSDialDefaults bad override %v %v\n", d.HandshakeTimeout,
+			d.Subprotocols)
+	}
+}
+
+/*
+	Test the subprotocol / accept-version cross check.
+*/
+func TestWSDialCheckSubprotocol(t *testing.T) {
+	for _, d := range []struct {
+		sp string
+		av string
+		ok bool
+	}{
+		{"", SPL_12, true},
+		{"mqtt", SPL_12, true},
+		{WSP_10, "", true},
+		{WSP_12, "", false},
+		{WSP_12, "1.0,1.1,1.2", true},
+		{WSP_10, "1.1,1.2", false},
+	} {
+		h := Headers{}
+		if d.av != "" {
+			h = h.Add(HK_ACCEPT_VERSION, d.av)
+		}
+		if e := checkSubprotocol(d.sp, h); (e == nil) != d.ok {
+			t.Fatalf("TestWSDialCheckSubprotocol %q %q expected %v, got %v\n",
+				d.sp, d.av, d.ok, e)
+		}
+	}
+}
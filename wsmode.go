@@ -0,0 +1,70 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	WSMessageMode selects the WebSocket message type used for STOMP frames.
+*/
+type WSMessageMode int
+
+/*
+	WebSocket message modes.
+*/
+const (
+	WSModeAuto   WSMessageMode = iota // Binary if the body is not valid UTF-8, else text
+	WSModeText                        // Always text
+	WSModeBinary                      // Always binary
+)
+
+/*
+	SetWSMessageMode sets the WebSocket message type used for frames sent
+	after the call.  The default is WSModeAuto.  Heart beats are always sent
+	as text.  Received frames may use either message type.
+*/
+func (c *Connection) SetWSMessageMode(m WSMessageMode) {
+	c.wsmmLock.Lock()
+	c.wsmm = m
+	c.wsmmLock.Unlock()
+}
+
+/*
+	The WebSocket message type for a frame.
+*/
+func (c *Connection) wsMessageType(f *Frame) int {
+	c.wsmmLock.Lock()
+	m := c.wsmm
+	c.wsmmLock.Unlock()
+	return wsFrameMessageType(m, f)
+}
+
+func wsFrameMessageType(m WSMessageMode, f *Frame) int {
+	switch {
+	case f.Command == "\n":
+		return websocket.TextMessage
+	case m == WSModeBinary:
+		return websocket.BinaryMessage
+	case m == WSModeAuto && !utf8.Valid(f.Body):
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
@@ -0,0 +1,130 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Test text and binary WebSocket message selection, and binary messages
+	from the broker.
+*/
+func TestWSModeBinary(t *testing.T) {
+	types := make(chan int, 8)
+	srv, ws := newFakeWSServer(t, func(ws *websocket.Conn) {
+		if _, ok := wsNextCommand(ws, CONNECT); !ok {
+			return
+		}
+		_ = ws.WriteMessage(websocket.TextMessage,
+			[]byte("CONNECTED\nversion:1.2\n\n\x00"))
+		for {
+			mt, b, e := ws.ReadMessage()
+			if e != nil {
+				return
+			}
+			switch {
+			case strings.HasPrefix(string(b), SEND):
+				types <- mt
+			case strings.HasPrefix(string(b), SUBSCRIBE):
+				_ = ws.WriteMessage(websocket.BinaryMessage,
+					[]byte("MESSAGE\nsubscription:bsub\nmessage-id:b1\n\n\xff\xfe\x00"))
+			case strings.HasPrefix(string(b), DISCONNECT):
+				return
+			}
+		}
+	})
+	defer srv.Close()
+	c, e := ConnectOverWS(ws, Headers{HK_ACCEPT_VERSION, SPL_12,
+		HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestWSModeBinary CONNECT expected nil, got %v\n", e)
+	}
+	sh := Headers{HK_DESTINATION, "/queue/ws.binary"}
+	_ = c.Send(sh, "plain text")
+	_ = c.SendBytes(sh, []byte{0xff, 0x00, 0x01})
+	wm := c.(WSModeHandler)
+	wm.SetWSMessageMode(WSModeBinary)
+	_ = c.Send(sh, "forced")
+	wm.SetWSMessageMode(WSModeText)
+	_ = c.SendBytes(sh, []byte{0xff})
+	for i, w := range []int{websocket.TextMessage, websocket.BinaryMessage,
+		websocket.BinaryMessage, websocket.TextMessage} {
+		select {
+		case mt := <-types:
+			if mt != w {
+				t.Fatalf("TestWSModeBinary SEND %d expected type %d, got %d\n",
+					i, w, mt)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestWSModeBinary SEND %d timeout\n", i)
+		}
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/ws.binary",
+		HK_ID, "bsub"})
+	if e != nil {
+		t.Fatalf("TestWSModeBinary SUBSCRIBE expected nil, got %v\n", e)
+	}
+	select {
+	case md := <-sc:
+		if md.Error != nil || md.Message.BodyString() != "\xff\xfe" {
+			t.Fatalf("TestWSModeBinary expected binary body, got %v\n", md)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("TestWSModeBinary MESSAGE timeout\n")
+	}
+	_ = c.Disconnect(NoDiscReceipt)
+}
+
+/*
+	Test SetWSMessageMode while the writer is sending.
+*/
+func TestWSModeConcurrent(t *testing.T) {
+	srv, ws := newFakeWSServer(t, func(ws *websocket.Conn) {
+		if _, ok := wsNextCommand(ws, CONNECT); !ok {
+			return
+		}
+		_ = ws.WriteMessage(websocket.TextMessage,
+			[]byte("CONNECTED\nversion:1.2\n\n\x00"))
+		_, _ = wsNextCommand(ws, DISCONNECT)
+	})
+	defer srv.Close()
+	c, e := ConnectOverWS(ws, Headers{HK_ACCEPT_VERSION, SPL_12,
+		HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestWSModeConcurrent CONNECT expected nil, got %v\n", e)
+	}
+	wm := c.(WSModeHandler)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			wm.SetWSMessageMode(WSMessageMode(i % 3))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if e = c.Send(Headers{HK_DESTINATION, "/queue/ws.mode"}, "m"); e != nil {
+			t.Fatalf("TestWSModeConcurrent SEND expected nil, got %v\n", e)
+		}
+	}
+	<-done
+	_ = c.Disconnect(NoDiscReceipt)
+}
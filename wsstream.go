@@ -0,0 +1,64 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"io"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	wsStream presents the messages received on a WebSocket connection as one
+	continuous byte stream.
+
+	STOMP frame boundaries need not match WebSocket message boundaries.  A
+	broker may put several frames (and heart beats) in one message, or split
+	one frame over several messages.
+*/
+type wsStream struct {
+	ws *websocket.Conn
+	r  io.Reader // Current message, nil between messages
+}
+
+func newWSStream(ws *websocket.Conn) *wsStream {
+	return &wsStream{ws: ws}
+}
+
+/*
+	Read implements io.Reader.  Empty messages are skipped.
+*/
+func (s *wsStream) Read(p []byte) (int, error) {
+	for {
+		if s.r == nil {
+			_, r, e := s.ws.NextReader()
+			if e != nil {
+				return 0, e
+			}
+			s.r = r
+		}
+		n, e := s.r.Read(p)
+		if e == io.EOF {
+			s.r = nil
+			if n == 0 {
+				continue
+			}
+			e = nil
+		}
+		return n, e
+	}
+}
@@ -0,0 +1,135 @@
+//
+// Copyright © 2011-2019 Guy M. Allard
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package stompws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	Test helper.  Start an in process WebSocket server, and dial it.  The
+	handler owns the server side connection.
+*/
+func newFakeWSServer(t *testing.T, hf func(ws *websocket.Conn)) (*httptest.Server, *websocket.Conn) {
+	u := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, e := u.Upgrade(w, r, nil)
+		if e != nil {
+			return
+		}
+		defer ws.Close()
+		hf(ws)
+	}))
+	ws, _, e := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if e != nil {
+		srv.Close()
+		t.Fatalf("newFakeWSServer dial error: %v\n", e)
+	}
+	return srv, ws
+}
+
+/*
+	Test helper.  Read client frames, one per WebSocket message, until one
+	with the wanted command arrives.
+*/
+func wsNextCommand(ws *websocket.Conn, cmd string) (Headers, bool) {
+	for {
+		_, b, e := ws.ReadMessage()
+		if e != nil {
+			return nil, false
+		}
+		l := strings.Split(strings.TrimLeft(string(b), "\n"), "\n")
+		if l[0] != cmd {
+			continue
+		}
+		h := Headers{}
+		for _, hl := range l[1:] {
+			p := strings.SplitN(hl, ":", 2)
+			if len(p) != 2 {
+				break
+			}
+			h = append(h, p[0], p[1])
+		}
+		return h, true
+	}
+}
+
+/*
+	Test frames batched in one WebSocket message, and frames split over
+	several messages.
+*/
+func TestWSStreamFraming(t *testing.T) {
+	msgs := func(s ...string) []string { return s }
+	srv, ws := newFakeWSServer(t, func(ws *websocket.Conn) {
+		send := func(p []string) {
+			for _, m := range p {
+				_ = ws.WriteMessage(websocket.TextMessage, []byte(m))
+			}
+		}
+		if _, ok := wsNextCommand(ws, CONNECT); !ok {
+			return
+		}
+		// Heart beat, then CONNECTED split in two
+		send(msgs("\n", "CONNECTED\nversion:1.2\nses", "sion:ws1\n\n\x00"))
+		if _, ok := wsNextCommand(ws, SUBSCRIBE); !ok {
+			return
+		}
+		// Two frames and a heart beat in one message
+		send(msgs("MESSAGE\nsubscription:wssub\nmessage-id:m1\n\none\x00" +
+			"\nMESSAGE\nsubscription:wssub\nmessage-id:m2\n\ntwo\x00\n"))
+		// One frame over three messages, body split too
+		send(msgs("MESSAGE\nsubscription:wssub\nmess",
+			"age-id:m3\ncontent-length:6\n\nth", "r\x00ee\x00"))
+		h, ok := wsNextCommand(ws, DISCONNECT)
+		if !ok {
+			return
+		}
+		send(msgs("RECEIPT\nreceipt-id:" + h.Value(HK_RECEIPT) + "\n\n\x00"))
+	})
+	defer srv.Close()
+	c, e := ConnectOverWS(ws, Headers{HK_ACCEPT_VERSION, SPL_12,
+		HK_HOST, "localhost"})
+	if e != nil {
+		t.Fatalf("TestWSStreamFraming CONNECT expected nil, got %v\n", e)
+	}
+	if c.Session() != "ws1" {
+		t.Fatalf("TestWSStreamFraming expected session ws1, got %s\n", c.Session())
+	}
+	sc, e := c.Subscribe(Headers{HK_DESTINATION, "/queue/ws.stream",
+		HK_ID, "wssub"})
+	if e != nil {
+		t.Fatalf("TestWSStreamFraming SUBSCRIBE expected nil, got %v\n", e)
+	}
+	for _, w := range []string{"one", "two", "thr\x00ee"} {
+		select {
+		case md := <-sc:
+			if md.Error != nil || md.Message.BodyString() != w {
+				t.Fatalf("TestWSStreamFraming expected %q, got %v\n", w, md)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("TestWSStreamFraming timeout waiting for %q\n", w)
+		}
+	}
+	e = c.Disconnect(empty_headers)
+	checkDisconnectError(t, e)
+}